@@ -1,69 +1,171 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
 // Aof構造体: AOFファイルの操作を管理します。
+//
+// 追記したデータは、まずOSのページキャッシュに書き込まれるだけで、ディスクに届くのは fsync したときです。
+// いつ fsync するかは appendfsync の設定（Redisと同じ3種類）で決まります。
+//   - always:   書き込みコマンドの応答を返す前に fsync します（Server.call を参照）。最も安全ですが最も遅くなります
+//   - everysec: 1秒ごとに、まだ同期していない書き込みがあれば fsync します。障害時に失うのは最大で約1秒分です
+//   - no:       fsync はOSに任せます（Linux では通常30秒ほどでディスクに書き出されます）
+//
+// 設定は CONFIG SET appendfsync で実行中にも切り替えられるため、Aof は fsync のたびに現在の設定を確認します。
 type Aof struct {
-	file *os.File      // ディスク上のファイルオブジェクト
-	rd   *bufio.Reader // ファイルから効率的に読み取るためのリーダー
-	mu   sync.Mutex    // ファイルへの書き込みを排他的にするためのMutex
+	dir      string       // AOFのファイルを置くディレクトリ（appenddirname の絶対パス）
+	filename string       // AOFのファイル名の元になる名前（appendfilename）
+	manifest *aofManifest // AOFを構成するファイルの一覧（aofmanifest.go を参照。mu で保護されます）
+	file     *os.File     // 追記中の incr ファイル（マニフェストの最後の incr）
+	mu       sync.Mutex   // ファイルへの書き込みを排他的にするためのMutex
+
+	written  int64 // 追記中の incr ファイルのサイズ（mu で保護されます）
+	prevSize int64 // base と、追記中のもの以外の incr ファイルのサイズの合計（mu で保護されます）
+
+	// AOFの書き換え（BGREWRITEAOF）の状態です。aofrewrite.go を参照してください。以下は mu で保護されます。
+	rewriting       bool           // 書き換え中かどうか
+	rewriteBaseSize int64          // 前回の書き換えが終わったとき（または起動したとき）のAOF全体のサイズ（自動の書き換えの判定に使います）
+	rewriteFailedAt int64          // 前回の書き換えが失敗した時刻（Unix時間のミリ秒）。失敗していなければ 0
+	rewriteWg       sync.WaitGroup // 書き換えのゴルーチンの終了を待つためのWaitGroup
+
+	// syncMu: fsync を1つずつ実行するためのMutexです。fsync の間は mu を解放しているので、
+	// ディスクへの同期を待っている間も、他のクライアントはファイルへの追記を続けられます。
+	syncMu sync.Mutex
+	synced int64 // 追記中のファイルのうち、ディスクへの同期が終わっている位置（written の値。syncMu で保護されます）
+
+	done    chan struct{} // 同期ゴルーチンに停止を伝えるためのチャネル（Closeで閉じられます）
+	stopped chan struct{} // 同期ゴルーチンが終了したことを知らせるチャネル
 }
 
-// NewAof: AOF構造体の新しいインスタンスを作成し、ファイルを開き、同期ゴルーチンを開始します。
-func NewAof(path string) (*Aof, error) {
-	// ファイルが存在しなければ作成し、読み書きモードで開きます。パーミッションは 0666。
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+// NewAof: AOF構造体の新しいインスタンスを作成し、ディレクトリ dir のマニフェストを読み込んで、
+// 最後の incr ファイルを追記用に開き、同期ゴルーチンを開始します。
+// マニフェストがなければ作成します（以前のバージョンの単一のAOFファイルがあれば、それを取り込みます）。
+func NewAof(dir, filename string) (*Aof, error) {
+	// 作業ディレクトリは CONFIG SET dir で実行中に変わる（os.Chdir）ため、起動時の場所を絶対パスで覚えておきます。
+	// 相対パスのままだと、変更後の書き換えが新しい作業ディレクトリの中にファイルを作ろうとして失敗します。
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m, err := loadManifest(dir, filename)
+	if err == errManifestNotFound {
+		m, err = createManifest(dir, filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 前回、書き換えの後の削除が終わる前に停止していた場合は、ここで削除します。
+	if err := deleteHistoryFiles(dir, filename, m); err != nil {
+		return nil, err
+	}
+
+	// 追記する incr ファイルがなければ、作ってマニフェストに加えます。
+	if len(m.incrs) == 0 {
+		m.newIncr(filename)
+		if err := persistManifest(dir, filename, m); err != nil {
+			return nil, err
+		}
+	}
+
+	// 最後の incr ファイルを、追記用に開きます（まだなければ作成します）。パーミッションは 0666。
+	f, err := os.OpenFile(filepath.Join(dir, m.incrs[len(m.incrs)-1].name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		return nil, err
 	}
 
+	// AOF全体のサイズを調べます（自動の書き換えの判定に使います）。マニフェストに載っているファイルがなければエラーにします。
+	var written, prevSize int64
+	for _, info := range m.files() {
+		fi, err := os.Stat(filepath.Join(dir, info.name))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		prevSize += fi.Size()
+		written = fi.Size()
+	}
+	prevSize -= written
+
 	aof := &Aof{
-		file: f,
-		// ファイルオブジェクトfを元に、読み取り用のバッファ付きリーダーを作成します。
-		rd: bufio.NewReader(f),
+		dir:      dir,
+		filename: filename,
+		manifest: m,
+		file:     f,
+		written:  written,
+		prevSize: prevSize,
+		// 起動前からあるデータは、前回の終了時（Close）に同期済みとみなします。
+		synced:          written,
+		rewriteBaseSize: prevSize + written,
+		done:            make(chan struct{}),
+		stopped:         make(chan struct{}),
 	}
 
-	// 永続性を高めるため、1秒ごとにファイルをディスクに同期するゴルーチン（並行処理）を開始します。
+	// appendfsync everysec のために、1秒ごとにファイルをディスクに同期するゴルーチン（並行処理）を開始します。
+	// 設定は実行中に変わることがあるので、ゴルーチンは常に動かしておき、そのときの設定を見て同期するかを決めます。
 	go func() {
+		defer close(aof.stopped)
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
 		for {
-			aof.mu.Lock()
-			// aof.file.Sync() はメモリ上のバッファを強制的にディスクに書き込みます。
-			err := aof.file.Sync()
-			if err != nil {
-				fmt.Println("Error syncing AOF file:", err)
+			select {
+			case <-aof.done:
+				// Close が呼ばれたら、ゴルーチンを終了します。
+				return
+			case <-ticker.C:
 			}
-			aof.mu.Unlock()
 
-			// 1秒間待機します。
-			time.Sleep(time.Second)
+			if config.getAppendFsync() != "everysec" {
+				continue
+			}
+			// 前回の同期の後に何も書き込まれていなければ、Fsync は何もせずに戻ります。
+			if err := aof.Fsync(); err != nil {
+				fmt.Println("Error syncing AOF file:", err)
+			}
 		}
 	}()
 
 	return aof, nil
 }
 
-// Close: ファイルを閉じ、Mutexを安全に解放します。
+// Close: 同期ゴルーチンを停止し、最後にもう一度ディスクへ同期してからファイルを閉じます。
+// これにより、appendfsync の設定に関係なく、まだ同期されていなかった書き込みも失われません。
 func (aof *Aof) Close() error {
-	aof.mu.Lock()
-	defer aof.mu.Unlock()
+	// 同期ゴルーチンに停止を伝え、終了するまで待ちます。
+	// 書き換え中であれば、書き換えのゴルーチンも done を見て中止するので、その終了も待ちます。
+	close(aof.done)
+	<-aof.stopped
+	aof.rewriteWg.Wait()
+
+	// 閉じる前に、未同期のデータをすべてディスクに書き込みます。
+	if err := aof.Fsync(); err != nil {
+		aof.file.Close()
+		return err
+	}
 
 	return aof.file.Close()
 }
 
 // Write: ValueオブジェクトをRESPバイト列に変換し、AOFファイルに追記します。
+// ディスクへの同期は行いません（Fsync を参照）。
 func (aof *Aof) Write(value Value) error {
 	aof.mu.Lock()
 	defer aof.mu.Unlock()
 
 	// value.Marshal() でValueをRESP形式のバイト列に変換します。
-	_, err := aof.file.Write(value.Marshal())
+	n, err := aof.file.Write(value.Marshal())
+	aof.written += int64(n)
 	if err != nil {
 		return err
 	}
@@ -71,41 +173,230 @@ func (aof *Aof) Write(value Value) error {
 	return nil
 }
 
-// Read: AOFファイルの内容をRESP形式として読み取り、読み取ったコマンドごとにコールバック関数を実行します。
+// Fsync: ここまでに追記したデータを、すべてディスクに同期します。すでに同期済みなら何もしません。
+//
+// 複数のクライアントが同時に呼び出した場合は、1つずつ fsync します（グループコミット）。
+// fsync には、それを始めた時点までに追記されたすべてのデータが含まれるので、
+// 前の fsync を待っている間に追記したクライアントたちは、次の1回の fsync でまとめて同期され、
+// 自分の番が来たときには、すでに同期が終わっていることがほとんどです。
+func (aof *Aof) Fsync() error {
+	aof.mu.Lock()
+	offset := aof.written
+	aof.mu.Unlock()
+
+	aof.syncMu.Lock()
+	defer aof.syncMu.Unlock()
+
+	// 待っている間に、他のクライアントの fsync が自分の書き込みまで同期していれば終わりです。
+	if aof.synced >= offset {
+		return nil
+	}
+
+	// fsync を始める時点までに追記されたデータは、すべてこの fsync で同期されます。
+	aof.mu.Lock()
+	written := aof.written
+	aof.mu.Unlock()
+
+	// aof.file.Sync() はメモリ上のバッファを強制的にディスクに書き込みます。
+	if err := aof.file.Sync(); err != nil {
+		return err
+	}
+	aof.synced = written
+	return nil
+}
+
+// Read: マニフェストに載っているAOFのファイル（base、incr の順）をRESP形式として読み取り、
+// 読み取ったコマンドごとにコールバック関数を実行します（Redisの loadAppendOnlyFiles）。
+//
+// ファイルが壊れていた場合は、読み取れなかった位置を含むエラーを返します。ただし、最後のファイル（lastAofFile）が
+// 書きかけのコマンドで終わっている場合（追記の途中でクラッシュした場合）は、aof-load-truncated が yes なら
+// 最後まで書き終えたコマンドの位置でファイルを切り詰めて、エラーにせずに戻ります。
+// 書きかけのコマンドは応答を返す前のものなので、捨ててもクライアントに成功を伝えた変更は失われません。
 func (aof *Aof) Read(callback func(value Value)) error {
 	// AOFファイルの読み取り中は書き込みを禁止します。
 	aof.mu.Lock()
 	defer aof.mu.Unlock()
 
-	// ファイルの読み取りを最初から開始するために、ポインターを先頭（オフセット0）に戻します。
-	_, err := aof.file.Seek(0, 0)
+	files := aof.manifest.files()
+	last := lastAofFile(aof.dir, files)
+	for i, info := range files {
+		path := filepath.Join(aof.dir, info.name)
+		_, err := readAofFile(path, callback)
+		if err == nil {
+			continue
+		}
+
+		var lerr *aofLoadError
+		if !errors.As(err, &lerr) {
+			return fmt.Errorf("Error reading the append only file %s: %w", info.name, err)
+		}
+		// 切り詰めてよいのは最後のファイルだけです。それより前のファイルの後ろには
+		// 続きのコマンドがあるので、途中で終わっているのはクラッシュではなく、ファイルが壊れています。
+		if !lerr.truncated || i != last {
+			return fmt.Errorf("Bad file format reading the append only file %s: %w. "+
+				"Make a backup of your AOF file, then use 'check-aof --fix %s'",
+				info.name, err, filepath.Join(aof.dir, manifestName(aof.filename)))
+		}
+		if !config.getAofLoadTruncated() {
+			return fmt.Errorf("Unexpected end of file reading the append only file %s at offset %d "+
+				"(the last complete command ends at offset %d). You can: "+
+				"1) Make a backup of your AOF file, then use 'check-aof --fix %s'. "+
+				"2) Alternatively you can set the 'aof-load-truncated' configuration option to yes and restart the server.",
+				info.name, lerr.errOffset, lerr.offset, filepath.Join(aof.dir, manifestName(aof.filename)))
+		}
+
+		fmt.Printf("!!! Warning: short read while loading the AOF file %s !!!\n", info.name)
+		fmt.Printf("!!! Truncating the AOF %s at offset %d !!!\n", info.name, lerr.offset)
+		fi, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("Error truncating the AOF file %s: %w", info.name, err)
+		}
+		if err := truncateAofFile(path, lerr.offset); err != nil {
+			return fmt.Errorf("Error truncating the AOF file %s: %w", info.name, err)
+		}
+		// 切り詰めた分だけ、NewAof で調べたサイズを減らします。追記用に開いている aof.file（マニフェストの最後のファイル）は
+		// O_APPEND で開いているので、切り詰めた後の追記は、新しいファイルの末尾（切り詰めた位置）から続きます。
+		cut := fi.Size() - lerr.offset
+		if i == len(files)-1 {
+			aof.written -= cut
+			aof.synced = aof.written
+		} else {
+			aof.prevSize -= cut
+		}
+		aof.rewriteBaseSize -= cut
+		fmt.Printf("AOF %s loaded anyway because aof-load-truncated is enabled\n", info.name)
+	}
+	return nil
+}
+
+// lastAofFile: 書きかけのコマンドを切り詰めてよい「最後のファイル」の位置を返します（Aof.Read と check-aof で使います）。
+// 後ろに続くファイルがすべて空なら、そのファイルも最後のファイルとして扱います。
+// 例えば、以前の単一のAOFファイルから移行した直後は、base の後ろに NewAof が作った空の incr があるだけなので、
+// 移行した base の末尾が書きかけでも、切り詰めて読み込めます。
+func lastAofFile(dir string, files []*aofInfo) int {
+	last := len(files) - 1
+	for last > 0 {
+		fi, err := os.Stat(filepath.Join(dir, files[last].name))
+		if err != nil || fi.Size() != 0 {
+			break
+		}
+		last--
+	}
+	return last
+}
+
+// truncateAofFile: AOFのファイルを size バイトに切り詰め、ディスクに同期します。
+func truncateAofFile(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0666)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
+
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// aofLoadError: AOFのファイルを最後まで読み取れなかった理由と位置です。
+type aofLoadError struct {
+	offset    int64 // 最後まで正しく読み取れたコマンドの終わりの位置（読み取れなかったコマンドの先頭の位置）
+	errOffset int64 // 読み取りに失敗した位置
+	truncated bool  // ファイルがコマンドの途中で終わっている（追記の途中でクラッシュした）場合は true
+	err       error // 読み取りのエラー（ProtocolError か io.ErrUnexpectedEOF など）
+}
+
+func (e *aofLoadError) Error() string {
+	if e.truncated {
+		return fmt.Sprintf("unexpected end of file at offset %d (the last complete command ends at offset %d)", e.errOffset, e.offset)
+	}
+	return fmt.Sprintf("%v at offset %d (in the command starting at offset %d)", e.err, e.errOffset, e.offset)
+}
+
+func (e *aofLoadError) Unwrap() error {
+	return e.err
+}
+
+// readAofFile: 1つのAOFファイルを読み取り、コマンドごとにコールバック関数を実行します。
+// 最後まで読み取れた場合は、ファイルのサイズを返します。途中で読み取れなくなった場合は、
+// そこまでに読み取れた最後のコマンドの終わりの位置と、*aofLoadError を返します（check-aof でも使います）。
+func readAofFile(path string, callback func(value Value)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
 
-	// ファイルリーダーを使って新しいRESPパーサーを作成します。
-	resp := NewResp(aof.file)
+	// ファイルを使って新しいRESPパーサーを作成します。
+	// 読み取った位置を知るために、ファイルから読み込んだバイト数を数えます。
+	cr := &countingReader{r: f}
+	resp := NewResp(cr)
+
+	// pos: パーサーが解析し終えた位置です。ファイルから読み込んだバイト数から、
+	// 先読みしてまだバッファに残っている分を引いたものになります。
+	pos := func() int64 {
+		return cr.n - int64(resp.Buffered())
+	}
 
 	// EOF（ファイルの終端）に達するまでループし、コマンドを一つずつ読み取ります。
+	var valid int64
 	for {
 		// RESPパーサーを使ってファイルから次のValue（コマンド）を読み取ります。
-		value, err := resp.Read()
+		value, err := readAofCommand(resp)
 
-		// 正常に読み込めた場合
-		if err == nil {
-			callback(value)
+		// コマンドの区切りでファイルの終端に達した場合は、正常な終了（"good error"）です。
+		if err == io.EOF && pos() == valid {
+			return valid, nil
 		}
 
-		// ファイルの終端に達した場合、これは正常な終了（"good error"）とみなし、ループを抜けます。
-		if err == io.EOF {
-			break
-		}
-
-		// その他のエラー（ファイル破損など）が発生した場合は、エラーを呼び出し元に返します。
+		// コマンドの途中で終端に達した場合（書きかけのコマンド）や、形式が崩れている場合（ファイル破損など）は、
+		// 位置を付けたエラーを呼び出し元に返します。
 		if err != nil {
-			return err
+			return valid, &aofLoadError{
+				offset:    valid,
+				errOffset: pos(),
+				truncated: errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF),
+				err:       err,
+			}
 		}
+
+		callback(value)
+		valid = pos()
 	}
+}
 
-	return nil
+// readAofCommand: AOFから次のコマンドを1つ読み取ります。
+// AOFのコマンドは必ず Bulk String の配列（*<要素数>\r\n$<長さ>\r\n...）で書かれているので、
+// クライアントからの入力と違い、インラインコマンドやそれ以外の型はファイルの破損とみなします。
+func readAofCommand(resp *Resp) (Value, error) {
+	b, err := resp.reader.Peek(1)
+	if err != nil {
+		return Value{}, err
+	}
+	if b[0] != ARRAY {
+		return Value{}, &ProtocolError{msg: fmt.Sprintf("expected '*', got '%s'", b)}
+	}
+
+	resp.reader.ReadByte()
+	value, err := resp.readMultibulk()
+	if err != nil {
+		return Value{}, err
+	}
+	if len(value.array) == 0 {
+		return Value{}, &ProtocolError{msg: "empty command"}
+	}
+	return value, nil
+}
+
+// countingReader: 読み込んだバイト数を数える io.Reader です。
+type countingReader struct {
+	r io.Reader
+	n int64 // これまでに読み込んだバイト数
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
 }
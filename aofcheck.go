@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ====================================================================
+// AOFの検査と修復（check-aof）
+// ====================================================================
+//
+// Redisの redis-check-aof に相当するサブコマンドです。サーバーを起動せずにAOFのファイルを最後まで読み取り、
+// 壊れていればその位置を表示します。--fix を付けると、最後まで正しく読み取れたコマンドの終わりの位置で
+// ファイルを切り詰めます。
+//
+//	go run . check-aof appendonlydir/database.aof.manifest        # マニフェストに載っているすべてのファイルを検査
+//	go run . check-aof --fix appendonlydir/database.aof.1.incr.aof # 1つのファイルを検査して修復
+//
+// 切り詰めると、壊れた位置より後ろのコマンドはすべて失われます。書きかけのコマンドが末尾に残っているだけなら
+// 失うのはそのコマンドだけですが、ファイルの途中が壊れている場合は、その後ろの正しいコマンドも消えます。
+// そのため、Redisと同じく、切り詰める前に減るバイト数を表示して確認を求めます。
+
+// checkAofMain: check-aof サブコマンドを実行し、プロセスの終了コードを返します（Redisの redis_check_aof_main）。
+// args は "check-aof" より後ろの引数です。
+func checkAofMain(args []string) int {
+	fix := false
+	if len(args) == 2 && args[0] == "--fix" {
+		fix = true
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		fmt.Println("Usage: check-aof [--fix] <file.manifest|file.aof>")
+		return 1
+	}
+	path := args[0]
+
+	// マニフェストが指定された場合は、載っているファイルを読み込む順に検査します。
+	if strings.HasSuffix(path, ".manifest") {
+		return checkAofManifest(path, fix)
+	}
+	if !checkAofFile(path, fix, true) {
+		return 1
+	}
+	return 0
+}
+
+// checkAofManifest: マニフェストに載っているファイルを、base、incr の順に検査します。
+// 壊れたファイルが見つかったら、そこで検査をやめます。サーバーの読み込み（Aof.Read）と同じく、
+// 切り詰めて修復できるのは最後のファイル（後ろのファイルがすべて空なら、そのファイルも含みます。lastAofFile を参照）だけです。
+func checkAofManifest(path string, fix bool) int {
+	dir := filepath.Dir(path)
+	filename := strings.TrimSuffix(filepath.Base(path), ".manifest")
+	m, err := loadManifest(dir, filename)
+	if errors.Is(err, errManifestNotFound) {
+		fmt.Printf("Cannot open the AOF manifest %s\n", path)
+		return 1
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	files := m.files()
+	last := lastAofFile(dir, files)
+	fmt.Printf("Start checking the multi part AOF %s (%d files)\n", path, len(files))
+	for i, info := range files {
+		if !checkAofFile(filepath.Join(dir, info.name), fix, i >= last) {
+			return 1
+		}
+	}
+	fmt.Println("All AOF files and the manifest are valid")
+	return 0
+}
+
+// checkAofFile: 1つのAOFファイルを検査し、正しい（または修復できた）場合は true を返します（Redisの checkSingleAof）。
+// fix が true なら、壊れていた場合に確認を求めてから、最後まで正しく読み取れた位置でファイルを切り詰めます。
+// last が false（マニフェストの最後のファイルではない）なら、後ろのファイルとの間が欠けてしまうので切り詰めません。
+func checkAofFile(path string, fix, last bool) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		fmt.Printf("Cannot open file %s: %v\n", path, err)
+		return false
+	}
+
+	valid, err := readAofFile(path, func(Value) {})
+	var lerr *aofLoadError
+	if err != nil && !errors.As(err, &lerr) {
+		fmt.Printf("Cannot read file %s: %v\n", path, err)
+		return false
+	}
+
+	size := fi.Size()
+	fmt.Printf("AOF analyzed: filename=%s, size=%d, ok_up_to=%d, diff=%d\n", path, size, valid, size-valid)
+	if err == nil {
+		fmt.Printf("AOF %s is valid\n", path)
+		return true
+	}
+
+	fmt.Printf("%s: %v\n", path, err)
+	if !fix {
+		fmt.Printf("AOF %s is not valid. Use the --fix option to try fixing it.\n", path)
+		return false
+	}
+	if !last {
+		fmt.Printf("Failed to truncate AOF %s because it is not the last file\n", path)
+		return false
+	}
+
+	fmt.Printf("This will shrink the AOF %s from %d bytes, with %d bytes, to %d bytes\n", path, size, size-valid, valid)
+	fmt.Print("Continue? [y/N]: ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+		fmt.Println("Aborting...")
+		return false
+	}
+
+	if err := truncateAofFile(path, valid); err != nil {
+		fmt.Printf("Failed to truncate AOF %s: %v\n", path, err)
+		return false
+	}
+	fmt.Printf("Successfully truncated AOF %s\n", path)
+	return true
+}
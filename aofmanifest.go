@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ====================================================================
+// マルチパートAOFとマニフェスト
+// ====================================================================
+//
+// Redis 7 と同じく、AOFは1つのファイルではなく、appenddirname のディレクトリ（デフォルトは appendonlydir/）に
+// 置いた複数のファイルで構成します。
+//
+//	appendonlydir/
+//	├── database.aof.1.base.aof   # base: 書き換えた時点のキースペースを再現するコマンド
+//	├── database.aof.1.incr.aof   # incr: その後に実行された書き込みコマンド（追記はここに行います）
+//	└── database.aof.manifest     # マニフェスト: どのファイルをどの順に読むか
+//
+// マニフェストは、ファイルごとに次のような1行を持つテキストファイルです（Redisと同じ形式です）。
+//
+//	file database.aof.1.base.aof seq 1 type b
+//	file database.aof.1.incr.aof seq 1 type i
+//
+// type は b（base）、i（incr）、h（履歴: 書き換えで不要になり、削除を待っているファイル）のどれかです。
+// 読み込むときは、base に続けて incr を書かれている順に再生します。
+//
+// 書き換え（aofrewrite.go）は、開始時に新しい incr ファイルに切り替え、スナップショットから新しい base を作ります。
+// 書き換え中の書き込みコマンドは新しい incr ファイルに追記されるだけなので、単一ファイルのときのように
+// 書き換え用のバッファに溜めて後からコピーする必要がありません。書き換えが終わったら、新しい base と
+// 新しい incr だけを載せたマニフェストに置き換え、古いファイルを削除します。
+// どのファイルも追記か新規作成しかせず、構成の切り替えはマニフェストのアトミックな置き換えで行うため、
+// ディレクトリをそのままコピーすれば、一貫したバックアップになります（コピー中にマニフェストが置き換わっても、
+// 古いマニフェストが指すファイルは、削除されるまで途中で書き換わりません）。
+
+// マニフェストのファイルの種類です（Redisの AOF_FILE_TYPE_BASE などと同じ記号です）。
+const (
+	aofTypeBase    = "b"
+	aofTypeIncr    = "i"
+	aofTypeHistory = "h"
+)
+
+// aofInfo構造体: マニフェストの1行（1つのAOFファイル）です。
+type aofInfo struct {
+	name string // ファイル名（appenddirname の中の名前）
+	seq  int64  // 通し番号
+	typ  string // 種類（aofTypeBase, aofTypeIncr, aofTypeHistory）
+}
+
+// aofManifest構造体: マニフェストの内容です（Redisの aofManifest に相当します）。
+type aofManifest struct {
+	base       *aofInfo   // base ファイル（まだない場合は nil）
+	incrs      []*aofInfo // incr ファイル（読み込む順。最後のものに追記します）
+	history    []*aofInfo // 削除を待っている、不要になったファイル
+	curBaseSeq int64      // これまでに作った base ファイルの最大の通し番号
+	curIncrSeq int64      // これまでに作った incr ファイルの最大の通し番号
+}
+
+// errManifestNotFound: マニフェストがまだ作られていないことを表すエラーです。
+var errManifestNotFound = errors.New("AOF manifest not found")
+
+// manifestName: マニフェストのファイル名を返します（例: database.aof.manifest）。
+func manifestName(filename string) string {
+	return filename + ".manifest"
+}
+
+// loadManifest: マニフェストを読み込みます（Redisの aofLoadManifestFromFile）。
+// マニフェストが存在しない場合は errManifestNotFound を返します。
+func loadManifest(dir, filename string) (*aofManifest, error) {
+	path := filepath.Join(dir, manifestName(filename))
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errManifestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &aofManifest{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		// 空行と '#' で始まるコメント行は無視します。
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		info, err := parseManifestLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AOF manifest %s at line %d: %w", path, i+1, err)
+		}
+		switch info.typ {
+		case aofTypeBase:
+			if m.base != nil {
+				return nil, fmt.Errorf("invalid AOF manifest %s at line %d: found duplicate base file information", path, i+1)
+			}
+			m.base = info
+			m.curBaseSeq = info.seq
+		case aofTypeIncr:
+			// incr ファイルは、通し番号の順に並んでいる必要があります。
+			if info.seq <= m.curIncrSeq {
+				return nil, fmt.Errorf("invalid AOF manifest %s at line %d: found a non-monotonic sequence number", path, i+1)
+			}
+			m.incrs = append(m.incrs, info)
+			m.curIncrSeq = info.seq
+		case aofTypeHistory:
+			m.history = append(m.history, info)
+		}
+	}
+	return m, nil
+}
+
+// parseManifestLine: マニフェストの1行（"file <name> seq <seq> type <type>"）を解析します。
+// ファイル名に空白などが含まれる場合は、設定ファイルと同じく引用符で囲まれています。
+func parseManifestLine(line string) (*aofInfo, error) {
+	argv, err := splitArgs(line)
+	if err != nil {
+		return nil, errors.New("unbalanced quotes")
+	}
+	if len(argv) < 6 || len(argv)%2 != 0 {
+		return nil, errors.New("invalid number of arguments")
+	}
+
+	info := &aofInfo{}
+	for i := 0; i < len(argv); i += 2 {
+		switch argv[i] {
+		case "file":
+			info.name = argv[i+1]
+		case "seq":
+			seq, err := strconv.ParseInt(argv[i+1], 10, 64)
+			if err != nil || seq < 1 {
+				return nil, errors.New("invalid sequence number")
+			}
+			info.seq = seq
+		case "type":
+			info.typ = argv[i+1]
+		}
+		// 知らないキーは、新しいバージョンとの互換性のために無視します（Redisと同じです）。
+	}
+
+	if info.name == "" || info.seq == 0 {
+		return nil, errors.New("missing file name or sequence number")
+	}
+	if strings.ContainsRune(info.name, filepath.Separator) {
+		return nil, errors.New("file name can't be a path")
+	}
+	switch info.typ {
+	case aofTypeBase, aofTypeIncr, aofTypeHistory:
+	default:
+		return nil, errors.New("unknown file type")
+	}
+	return info, nil
+}
+
+// String: マニフェストを、ファイルに書き出す形式のテキストにします。
+func (m *aofManifest) String() string {
+	var b strings.Builder
+	write := func(info *aofInfo) {
+		fmt.Fprintf(&b, "file %s seq %d type %s\n", quoteConfigArg(info.name), info.seq, info.typ)
+	}
+	if m.base != nil {
+		write(m.base)
+	}
+	for _, info := range m.history {
+		write(info)
+	}
+	for _, info := range m.incrs {
+		write(info)
+	}
+	return b.String()
+}
+
+// dup: マニフェストのコピーを返します。変更したコピーを書き出せた場合だけ、元のマニフェストと置き換えるために使います。
+func (m *aofManifest) dup() *aofManifest {
+	dup := *m
+	dup.incrs = append([]*aofInfo(nil), m.incrs...)
+	dup.history = append([]*aofInfo(nil), m.history...)
+	return &dup
+}
+
+// newBase: 新しい base ファイルを追加し、それまでの base を履歴にします（Redisの getNewBaseFileNameAndMarkPreAsHistory）。
+func (m *aofManifest) newBase(filename string) *aofInfo {
+	if m.base != nil {
+		m.base.typ = aofTypeHistory
+		m.history = append(m.history, m.base)
+	}
+	m.curBaseSeq++
+	m.base = &aofInfo{name: fmt.Sprintf("%s.%d.base.aof", filename, m.curBaseSeq), seq: m.curBaseSeq, typ: aofTypeBase}
+	return m.base
+}
+
+// newIncr: 新しい incr ファイルを末尾に追加します（Redisの getNewIncrAofName）。
+func (m *aofManifest) newIncr(filename string) *aofInfo {
+	m.curIncrSeq++
+	info := &aofInfo{name: fmt.Sprintf("%s.%d.incr.aof", filename, m.curIncrSeq), seq: m.curIncrSeq, typ: aofTypeIncr}
+	m.incrs = append(m.incrs, info)
+	return info
+}
+
+// markRewrittenIncrsAsHistory: 最後のもの以外の incr ファイルを履歴にします（Redisの markRewrittenIncrAofAsHistory）。
+// 書き換えが終わったとき、それらの内容はすべて新しい base に含まれているためです。
+func (m *aofManifest) markRewrittenIncrsAsHistory() {
+	if len(m.incrs) <= 1 {
+		return
+	}
+	for _, info := range m.incrs[:len(m.incrs)-1] {
+		info.typ = aofTypeHistory
+		m.history = append(m.history, info)
+	}
+	m.incrs = m.incrs[len(m.incrs)-1:]
+}
+
+// files: 読み込む順（base、incr の順）にファイルを返します。
+func (m *aofManifest) files() []*aofInfo {
+	files := make([]*aofInfo, 0, len(m.incrs)+1)
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+// persistManifest: マニフェストをファイルに書き出します（Redisの persistAofManifest）。
+// 書き込み途中でクラッシュしても元のマニフェストが壊れないよう、一時ファイルに書いてから置き換えます。
+func persistManifest(dir, filename string, m *aofManifest) error {
+	tmp, err := os.CreateTemp(dir, "temp-"+manifestName(filename)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // 置き換えに成功した場合は、何もしません
+
+	// CreateTemp は所有者だけが読めるファイルを作るので、AOFのファイルと同じく誰でも読めるようにします。
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.WriteString(m.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, manifestName(filename))); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// deleteHistoryFiles: 履歴のファイルを削除し、マニフェストからも取り除きます（Redisの aofDelHistoryFiles）。
+// 書き換えが終わった直後と、起動時（前回の削除の途中で停止した場合のため）に呼び出します。
+func deleteHistoryFiles(dir, filename string, m *aofManifest) error {
+	if len(m.history) == 0 {
+		return nil
+	}
+	for _, info := range m.history {
+		if err := os.Remove(filepath.Join(dir, info.name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Println("Error removing the history AOF file:", err)
+		}
+	}
+	m.history = nil
+	return persistManifest(dir, filename, m)
+}
+
+// createManifest: マニフェストがない場合に、最初のマニフェストを作ります。
+// 以前のバージョンの単一のAOFファイル（作業ディレクトリの appendfilename）があれば、それをディレクトリに移して
+// base にします（Redisの aofUpgradePrepare）。なければ、空の base ファイルを作ります。
+//
+// 移行は「ファイルを移す → マニフェストを書き出す」の順に行います。その間に停止しても、
+// 次の起動時にはディレクトリに移したファイルを見つけて、同じ移行を続けます。
+func createManifest(dir, filename string) (*aofManifest, error) {
+	m := &aofManifest{}
+	legacy := filepath.Join(dir, filename)
+
+	if _, err := os.Stat(filename); err == nil {
+		fmt.Printf("Migrating the old AOF file %s to %s\n", filename, legacy)
+		if err := os.Rename(filename, legacy); err != nil {
+			return nil, err
+		}
+		if err := syncDir(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := os.Stat(legacy); err == nil {
+		// 移した古いファイルは、名前を変えずにそのまま base として使います。
+		m.curBaseSeq = 1
+		m.base = &aofInfo{name: filename, seq: 1, typ: aofTypeBase}
+	} else {
+		base := m.newBase(filename)
+		f, err := os.OpenFile(filepath.Join(dir, base.name), os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+
+	if err := persistManifest(dir, filename, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// syncDir: ディレクトリを fsync します。ファイルの作成やリネームは、ディレクトリを同期して初めてディスクに残ります。
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
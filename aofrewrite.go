@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ====================================================================
+// AOFの書き換え（BGREWRITEAOF）
+// ====================================================================
+//
+// AOFは書き込みコマンドを追記していくだけなので、同じキーへの SET を繰り返すと、最後の1つ以外は不要なのに
+// ファイルはいつまでも大きくなっていきます。AOFの書き換えは、その時点のキースペースを再現する最小限のコマンド
+// （キーごとに SET や RPUSH を1つずつ）で新しいファイルを作り、古いファイルと置き換えます（Redisの aof.c）。
+//
+//	SET counter 1, SET counter 2, ..., SET counter 100  →  SET counter 100
+//
+// 書き換えはクライアントの書き込みを止めずに、バックグラウンドのゴルーチンで行います（マルチパートAOF。aofmanifest.go を参照）。
+//  1. その時点のキースペースのスナップショットを取り、同時に追記先を新しい incr ファイルに切り替えます
+//  2. ゴルーチンがスナップショットから一時ファイルにコマンドを書き出します。
+//     その間の書き込みコマンドは、新しい incr ファイルに追記されます
+//  3. 書き出しが終わったら、一時ファイルを新しい base ファイルにリネームし、「新しい base + 新しい incr」だけを
+//     載せたマニフェストにアトミックに置き換えてから、古い base と incr を削除します。
+//     途中でクラッシュしても、マニフェストは古い構成か新しい構成のどちらかを指しているので、AOFが壊れることはありません
+//
+// Redisは fork した子プロセスがコピーオンライトでスナップショットを読みますが、ここでは値をコピー（Object の dup）して
+// スナップショットにします。コピーの間はキースペースのロックを取得したままなので、キーが多いと一瞬止まりますが、
+// その後はスナップショットがキースペースと何も共有しないため、クライアントが値を変更しても、
+// UNLINK が値をバックグラウンドで破棄しても（lazyfree.go）、書き出す内容には影響しません。
+//
+// 書き換えは BGREWRITEAOF コマンドのほか、前回の書き換えから auto-aof-rewrite-percentage（%）以上大きくなり、
+// auto-aof-rewrite-min-size を超えたときに自動でも始まります。
+
+// aofRewriteItemsPerCmd: 書き換えで、1つのコマンドにまとめる要素の最大数です（Redisの AOF_REWRITE_ITEMS_PER_CMD）。
+// 要素数の多いリストやハッシュは、この数ずつに分けた RPUSH や HSET で書き出します。
+const aofRewriteItemsPerCmd = 64
+
+// aofRewriteRetryDelay: 書き換えが失敗した後、次に自動で書き換えを始めるまでの時間（ミリ秒）です。
+// ディスクがいっぱいの場合などに、書き込みコマンドのたびに失敗する書き換えを繰り返さないためです。
+const aofRewriteRetryDelay = 10 * 1000
+
+var (
+	errRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+	errRewriteAborted    = errors.New("rewrite aborted because the server is shutting down")
+)
+
+// ------------------------------
+// BGREWRITEAOF コマンド
+// ------------------------------
+
+// bgrewriteaof: BGREWRITEAOF
+// AOFの書き換えをバックグラウンドで開始します。書き換えが終わるのを待たずに応答を返します。
+func bgrewriteaof(c *Client, args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'bgrewriteaof' command"}
+	}
+	if server == nil || server.aof == nil {
+		return Value{typ: "error", str: "ERR Append only file is disabled"}
+	}
+
+	// BGREWRITEAOF は書き込みコマンドではないので、call は callMu を取得しません。
+	// スナップショットを取る間に書き込みコマンドがAOFに追記されないよう、ここで取得します。
+	server.callMu.Lock()
+	defer server.callMu.Unlock()
+
+	if err := server.rewriteAppendOnlyFileBackground(); err != nil {
+		return Value{typ: "error", str: "ERR " + err.Error()}
+	}
+	return Value{typ: "string", str: "Background append only file rewriting started"}
+}
+
+// ====================================================================
+// 書き換えの開始と終了
+// ====================================================================
+
+// rewriteAppendOnlyFileBackground: キースペースのスナップショットを取り、AOFの書き換えをバックグラウンドで開始します
+// （Redisの rewriteAppendOnlyFileBackground）。callMu を取得した状態で呼び出します。
+// callMu によって書き込みコマンドが止まっているので、スナップショットに含まれる変更と、
+// 新しい incr ファイルに追記される変更は、ちょうど重ならずに分かれます。
+func (s *Server) rewriteAppendOnlyFileBackground() error {
+	aof := s.aof
+	if err := aof.startRewrite(); err != nil {
+		return err
+	}
+	snapshot, expires := db.snapshot()
+
+	aof.rewriteWg.Add(1)
+	go func() {
+		defer aof.rewriteWg.Done()
+		if err := aof.rewrite(snapshot, expires); err != nil {
+			fmt.Println("Background AOF rewrite failed:", err)
+			return
+		}
+		fmt.Println("Background AOF rewrite finished successfully")
+	}()
+	return nil
+}
+
+// rewriteAppendOnlyFileIfNeeded: AOFが前回の書き換えから auto-aof-rewrite-percentage 以上大きくなっていれば、
+// 書き換えを開始します（Redisが serverCron の中で行う判定と同じです）。
+// call が書き込みコマンドを実行するたびに、callMu を取得した状態で呼び出します。
+func (s *Server) rewriteAppendOnlyFileIfNeeded() {
+	if s.aof == nil {
+		return
+	}
+	growth, ok := s.aof.rewriteNeeded()
+	if !ok {
+		return
+	}
+	fmt.Printf("Starting automatic rewriting of AOF on %d%% growth\n", growth)
+	if err := s.rewriteAppendOnlyFileBackground(); err != nil {
+		fmt.Println("Error starting automatic AOF rewrite:", err)
+	}
+}
+
+// snapshot: その時点のキースペースのコピーを返します。期限切れのキーは含めません。
+// 値はすべて dup でコピーするので、返した後にキースペースが変更されても、スナップショットは変わりません。
+func (d *DB) snapshot() (map[string]*Object, map[string]int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	snapshot := make(map[string]*Object, len(d.dict))
+	expires := make(map[string]int64, len(d.expires))
+	for key, o := range d.dict {
+		if d.isExpired(key) {
+			continue
+		}
+		snapshot[key] = o.dup()
+		if when, ok := d.expires[key]; ok {
+			expires[key] = when
+		}
+	}
+	return snapshot, expires
+}
+
+// startRewrite: 追記先を新しい incr ファイルに切り替えて、書き換え中の状態にします（Redisの openNewIncrAofForAppend）。
+// これ以降の書き込みコマンドは新しい incr ファイルに追記されるので、書き換えが終われば、それより前のファイルは不要になります。
+// すでに書き換え中の場合は errRewriteInProgress を返します。
+func (aof *Aof) startRewrite() error {
+	aof.syncMu.Lock()
+	defer aof.syncMu.Unlock()
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	if aof.rewriting {
+		return errRewriteInProgress
+	}
+
+	m := aof.manifest.dup()
+	info := m.newIncr(aof.filename)
+	path := filepath.Join(aof.dir, info.name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	if err := persistManifest(aof.dir, aof.filename, m); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	// これまでの incr ファイルは、ディスクに同期してから閉じます。
+	if err := aof.file.Sync(); err != nil {
+		fmt.Println("Error syncing AOF file:", err)
+	}
+	aof.file.Close()
+	aof.file = f
+	aof.manifest = m
+	aof.prevSize += aof.written
+	aof.written, aof.synced = 0, 0
+	aof.rewriting = true
+	return nil
+}
+
+// rewriteNeeded: 自動で書き換えるべきかを判定し、前回の書き換えからの増加率（%）を返します。
+func (aof *Aof) rewriteNeeded() (int64, bool) {
+	percentage, minSize := config.getAutoAofRewrite()
+	if percentage == 0 {
+		return 0, false
+	}
+
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	size := aof.prevSize + aof.written
+	if aof.rewriting || size <= int64(minSize) {
+		return 0, false
+	}
+	if aof.rewriteFailedAt != 0 && mstime()-aof.rewriteFailedAt < aofRewriteRetryDelay {
+		return 0, false
+	}
+	base := max(aof.rewriteBaseSize, 1)
+	growth := size*100/base - 100
+	return growth, growth >= int64(percentage)
+}
+
+// rewrite: スナップショットを一時ファイルに書き出し、新しい base ファイルにしてマニフェストを置き換えます。
+// 失敗した場合は一時ファイルを削除し、マニフェストはこれまでのまま使い続けます
+// （書き換えの開始時に作った incr ファイルも、そのままマニフェストに残ります）。
+func (aof *Aof) rewrite(snapshot map[string]*Object, expires map[string]int64) error {
+	tmpPath := filepath.Join(aof.dir, fmt.Sprintf("temp-rewriteaof-bg-%d.aof", os.Getpid()))
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err == nil {
+		err = rewriteKeyspace(f, snapshot, expires, aof.done)
+		if err == nil {
+			err = f.Sync()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = aof.finishRewrite(tmpPath)
+		}
+		os.Remove(tmpPath) // リネームに成功した場合は、何もしません
+	}
+
+	if err != nil {
+		aof.mu.Lock()
+		aof.rewriting = false
+		aof.rewriteFailedAt = mstime()
+		aof.mu.Unlock()
+	}
+	return err
+}
+
+// finishRewrite: 一時ファイルを新しい base ファイルにし、マニフェストを置き換えます（Redisの backgroundRewriteDoneHandler）。
+// 書き換えの開始時より前の incr ファイルと古い base は、いったん履歴としてマニフェストに残してから削除します。
+// 削除の途中で停止しても、次の起動時に履歴のファイルを削除できるようにするためです。
+//
+// マニフェストを変更するのは startRewrite と書き換えのゴルーチンだけで、rewriting によって同時には動かないため、
+// マニフェストの書き出しやファイルの削除の間も mu を取得せず、クライアントの追記を止めません。
+// エラーを返すのは、新しいマニフェストに置き換える前に失敗した場合だけです。
+func (aof *Aof) finishRewrite(tmpPath string) error {
+	aof.mu.Lock()
+	m := aof.manifest.dup()
+	aof.mu.Unlock()
+
+	base := m.newBase(aof.filename)
+	basePath := filepath.Join(aof.dir, base.name)
+	if err := os.Rename(tmpPath, basePath); err != nil {
+		return err
+	}
+	m.markRewrittenIncrsAsHistory()
+	if err := persistManifest(aof.dir, aof.filename, m); err != nil {
+		os.Remove(basePath)
+		return err
+	}
+	// 新しいマニフェストを公開してから、履歴のファイルを削除します。
+	aof.mu.Lock()
+	aof.manifest = m
+	aof.mu.Unlock()
+	if err := deleteHistoryFiles(aof.dir, aof.filename, m); err != nil {
+		fmt.Println("Error removing the history AOF files:", err)
+	}
+
+	var baseSize int64
+	if fi, err := os.Stat(basePath); err == nil {
+		baseSize = fi.Size()
+	}
+
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	aof.prevSize = baseSize
+	aof.rewriteBaseSize = baseSize + aof.written
+	aof.rewriting = false
+	aof.rewriteFailedAt = 0
+	return nil
+}
+
+// ====================================================================
+// スナップショットの書き出し
+// ====================================================================
+
+// rewriteKeyspace: スナップショットの各キーを、それを再現するコマンドとして w に書き出します
+// （Redisの rewriteAppendOnlyFileRio）。done が閉じられたら（サーバーの停止）、途中で中止します。
+func rewriteKeyspace(w io.Writer, snapshot map[string]*Object, expires map[string]int64, done <-chan struct{}) error {
+	bw := bufio.NewWriter(w)
+	for key, o := range snapshot {
+		select {
+		case <-done:
+			return errRewriteAborted
+		default:
+		}
+
+		rewriteObject(bw, key, o)
+		if when, ok := expires[key]; ok {
+			writeRewriteCommand(bw, "PEXPIREAT", key, strconv.FormatInt(when, 10))
+		}
+	}
+	// bufio.Writer は途中で起きた書き込みエラーを覚えているので、最後の Flush でまとめて確認します。
+	return bw.Flush()
+}
+
+// rewriteObject: 1つのキーの値を再現するコマンドを書き出します。
+func rewriteObject(w *bufio.Writer, key string, o *Object) {
+	switch o.typ {
+	case ObjString:
+		writeRewriteCommand(w, "SET", key, o.str())
+	case ObjList:
+		ql := o.list()
+		writeRewriteItems(w, "RPUSH", key, ql.Range(0, ql.Len()-1), 1)
+	case ObjSet:
+		writeRewriteItems(w, "SADD", key, setTypeMembers(o), 1)
+	case ObjZset:
+		entries := zsetEntries(o)
+		items := make([]string, 0, len(entries)*2)
+		for _, e := range entries {
+			items = append(items, formatDouble(e.score), e.member)
+		}
+		writeRewriteItems(w, "ZADD", key, items, 2)
+	case ObjHash:
+		hash := o.hash()
+		items := make([]string, 0, len(hash)*2)
+		for field, value := range hash {
+			items = append(items, field, value)
+		}
+		writeRewriteItems(w, "HSET", key, items, 2)
+	case ObjStream:
+		rewriteStream(w, key, o.stream())
+	}
+}
+
+// rewriteStream: ストリームを再現するコマンドを書き出します（Redisの rewriteStreamObject）。
+//   - エントリーは、IDを指定した XADD で1つずつ追加します
+//   - lastID、追加したエントリーの総数、削除した最大のIDは、XSETID で設定します（XDEL で末尾を削除した場合などのため）
+//   - 消費者グループは XGROUP CREATE、消費者は XGROUP CREATECONSUMER、PEL のエントリーは XCLAIM で作ります
+//
+// 削除済みのエントリー（XDEL や XTRIM で削除したもの）を指す PEL のエントリーは、書き出しません。
+// XCLAIM はそのようなエントリーを PEL に作らないためで、書き換えた後の PEL からはなくなります（Redisと同じです）。
+// これらは、XCLAIM や XAUTOCLAIM で引き継ごうとした時点で PEL から削除されるものです。
+func rewriteStream(w *bufio.Writer, key string, s *stream) {
+	if s.length > 0 {
+		s.index.Range(minStreamID, maxStreamID, false, func(e *streamEntry) bool {
+			writeRewriteCommand(w, append([]string{"XADD", key, e.id.String()}, e.fields...)...)
+			return true
+		})
+	} else {
+		// エントリーのないストリームは、Redisと同じく MAXLEN 0 の XADD で作ります（追加したエントリーはすぐに削除されます）。
+		writeRewriteCommand(w, "XADD", key, "MAXLEN", "0", streamID{0, 1}.String(), "x", "y")
+	}
+	writeRewriteCommand(w, "XSETID", key, s.lastID.String(),
+		"ENTRIESADDED", strconv.FormatInt(s.entriesAdded, 10),
+		"MAXDELETEDID", s.maxDeletedID.String())
+
+	for _, cg := range s.sortedGroups() {
+		writeRewriteCommand(w, "XGROUP", "CREATE", key, cg.name, cg.lastID.String(),
+			"ENTRIESREAD", strconv.FormatInt(cg.entriesRead, 10))
+		for _, consumer := range cg.sortedConsumers() {
+			writeRewriteCommand(w, "XGROUP", "CREATECONSUMER", key, cg.name, consumer.name)
+		}
+		for _, id := range cg.pel.ids {
+			if s.index.Find(id) == nil {
+				continue
+			}
+			writeRewriteCommand(w, claimCommand(key, cg, cg.pel.Get(id))...)
+		}
+	}
+}
+
+// writeRewriteItems: "command key item..." を、aofRewriteItemsPerCmd 個の要素ずつに分けて書き出します。
+// 1つの要素が複数の引数になる場合（ハッシュのフィールドと値など）は、width にその数を指定します。
+func writeRewriteItems(w *bufio.Writer, command, key string, items []string, width int) {
+	step := aofRewriteItemsPerCmd * width
+	for start := 0; start < len(items); start += step {
+		end := min(start+step, len(items))
+		writeRewriteCommand(w, append([]string{command, key}, items[start:end]...)...)
+	}
+}
+
+// writeRewriteCommand: コマンドを RESP の配列として書き出します（AOFに追記するときと同じ形式です）。
+func writeRewriteCommand(w *bufio.Writer, args ...string) {
+	w.Write(commandValue(args).Marshal())
+}
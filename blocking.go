@@ -0,0 +1,347 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// ブロッキング操作（BLPOP, BRPOP, BLMOVE など）
+// ====================================================================
+//
+// BLPOP のようなコマンドは、キーにデータがない場合、データが追加されるかタイムアウトするまでクライアントを待たせます。
+// Redisと同じく、次のように動作します。
+//  1. コマンドの実行時にデータがあれば、ブロックせずにすぐ応答する
+//  2. データがなければ、待っているキーごとのキュー（DB.blocked）にクライアントを登録し、ロックを解放して待つ
+//  3. 別のクライアントがそのキーにデータを追加すると、追加したコマンドの中（同じロックの中）で
+//     キューの先頭から順番（FIFO）に待っているクライアントへデータを渡す
+//
+// 手順3をデータを追加したコマンドの中で行うため、待っているクライアントより先に他のクライアントが
+// データを横取りすることはありません。
+// 待っている間、そのクライアントのゴルーチンは止まりますが、ロックは持っていないので他のクライアントの処理は妨げません。
+
+// blockedClient構造体: キーにデータが追加されるのを待っているクライアントです。
+type blockedClient struct {
+	keys []string // 待っているキー
+
+	// tryServe: 待っているキーにデータが追加されたときに、書き込みロックを取得した状態で呼ばれます。
+	// 応答できた場合は、その応答と true を返します。データが足りず応答できない場合は false を返します。
+	tryServe func(key string) (Value, bool)
+
+	served bool       // すでに応答を渡したか（同じクライアントに2回渡さないための印）
+	reply  chan Value // 応答を待っているクライアントに渡すためのチャネル（容量1）
+}
+
+// blockForKeys: クライアントを、待っているキーそれぞれのキューの末尾に登録します。
+// 書き込みロックを取得した状態で呼び出します。
+func (d *DB) blockForKeys(bc *blockedClient) {
+	for _, key := range bc.keys {
+		// 同じキーが複数回指定された場合（BLPOP a a 0 など）は、1回だけ登録します。
+		if !slices.Contains(d.blocked[key], bc) {
+			d.blocked[key] = append(d.blocked[key], bc)
+		}
+	}
+}
+
+// unblock: クライアントを、すべてのキーのキューから取り除きます。書き込みロックを取得した状態で呼び出します。
+func (d *DB) unblock(bc *blockedClient) {
+	for _, key := range bc.keys {
+		queue := slices.DeleteFunc(d.blocked[key], func(other *blockedClient) bool { return other == bc })
+		if len(queue) == 0 {
+			delete(d.blocked, key)
+		} else {
+			d.blocked[key] = queue
+		}
+	}
+}
+
+// signalKeyAsReady: キーにデータが追加されたことを、そのキーを待っているクライアントに知らせます。
+// キューの先頭から順番に応答を渡します。応答できないクライアント（データがなくなった場合や、
+// BZPOPMIN で待っているキーにリストが作られた場合など）は、待たせたままにして次のクライアントを調べます。
+// データを追加したコマンドが、書き込みロックを取得した状態で呼び出します。
+func (d *DB) signalKeyAsReady(key string) {
+	queue := d.blocked[key]
+	if len(queue) == 0 {
+		return
+	}
+
+	// tryServe の中で別のキーにデータが追加され（BLMOVE）、キューが変わることがあるため、コピーをたどります。
+	for _, bc := range slices.Clone(queue) {
+		if bc.served {
+			continue
+		}
+		bc.served = true
+		v, ok := bc.tryServe(key)
+		if !ok {
+			bc.served = false
+			continue
+		}
+		d.unblock(bc)
+		bc.reply <- v
+	}
+}
+
+// parseBlockingTimeout: ブロッキングコマンドのタイムアウト（秒、小数も可）を解析します。0 は無期限です。
+func parseBlockingTimeout(s string) (time.Duration, *Value) {
+	seconds, ok := parseFloat(s)
+	if !ok || math.IsInf(seconds, 0) || seconds*1000 > math.MaxInt64/float64(time.Millisecond) {
+		return 0, &Value{typ: "error", str: "ERR timeout is not a float or out of range"}
+	}
+	if seconds < 0 {
+		return 0, &Value{typ: "error", str: "ERR timeout is negative"}
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// blockClient: クライアントを待ち状態にし、応答が渡されるか、タイムアウトするか、接続が閉じられるまで待ちます。
+// 書き込みロックを取得した状態で呼び出し、関数の中でロックを解放します。
+// タイムアウトした場合は timeoutReply を、接続が閉じられた場合は空の Value（何も送信しない）を返します。
+func blockClient(c *Client, bc *blockedClient, timeout time.Duration, timeoutReply Value) Value {
+	bc.reply = make(chan Value, 1)
+	db.blockForKeys(bc)
+	db.mu.Unlock()
+
+	// 書き込みコマンド（BLPOP など）は call の callMu を取得しているので、待っている間は解放して、
+	// データを追加するコマンドを実行できるようにします。解放する前に、それまでに記録した操作をAOFに書き込みます。
+	if c.cmd.flags&cmdWrite != 0 {
+		server.flushPropagated()
+		server.callMu.Unlock()
+		defer server.callMu.Lock()
+	}
+
+	// 待つ前に、それまでに溜まっている応答（パイプラインで先に送られたコマンドの応答）を送信しておきます。
+	c.writer.Flush()
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	closed, stopWatching := c.watchClose()
+
+	timedOut := false
+	select {
+	case v := <-bc.reply:
+		stopWatching()
+		return v
+	case <-timer:
+		timedOut = true
+	case <-closed:
+	case <-server.closingCh:
+	}
+	stopWatching()
+
+	// 待つのをやめる直前に、別のクライアントが応答を渡していた場合はそれを返します。
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	select {
+	case v := <-bc.reply:
+		return v
+	default:
+	}
+	db.unblock(bc)
+
+	if timedOut {
+		return timeoutReply
+	}
+	return Value{}
+}
+
+// watchClose: ブロック中にクライアントの接続が閉じられたことを検知するためのチャネルを返します。
+// 戻り値の関数を呼ぶと監視を止めます（監視用のゴルーチンが終了するまで待ちます）。
+//
+// ブロック中はコマンドの読み取りループが止まっているため、代わりに監視用のゴルーチンが
+// 1バイトだけ先読み（Peek）して接続の切断（EOF）を検知します。Peek はデータを消費しないので、
+// ブロック中にクライアントが送ってきた次のコマンドは、ブロックが終わった後に通常どおり読み取られます。
+// すでに次のコマンドがバッファに届いている場合は、Peek がすぐに戻ってしまうため監視しません。
+func (c *Client) watchClose() (<-chan struct{}, func()) {
+	closed := make(chan struct{})
+	if c.reader.Buffered() > 0 {
+		return closed, func() {}
+	}
+
+	var stopping atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := c.reader.reader.Peek(1); err != nil && !stopping.Load() {
+			close(closed)
+		}
+	}()
+
+	stop := func() {
+		// 読み取り期限を「今」にして Peek を終わらせ、ゴルーチンの終了を待ちます。
+		stopping.Store(true)
+		c.conn.SetReadDeadline(time.Now())
+		<-done
+
+		// 読み取り期限を元に戻します。ただしシャットダウン中は、次の読み取りですぐに終了するよう「今」のままにします。
+		server.mu.Lock()
+		if !server.closing {
+			c.conn.SetReadDeadline(time.Time{})
+		}
+		server.mu.Unlock()
+	}
+	return closed, stop
+}
+
+// ------------------------------
+// BLPOP / BRPOP コマンド
+// ------------------------------
+
+// blpop: BLPOP key [key ...] timeout
+// 指定したキーのうち、最初に空でないリストの先頭から要素を取り出し、[キー, 要素] を返します。
+// すべてのキーが空なら、いずれかのキーに要素が追加されるまで最大 timeout 秒待ちます（0 は無期限）。
+func blpop(c *Client, args []Value) Value {
+	return blockingPopGeneric(c, args, "blpop", true)
+}
+
+// brpop: BRPOP key [key ...] timeout
+// BLPOP と同じですが、リストの末尾から取り出します。
+func brpop(c *Client, args []Value) Value {
+	return blockingPopGeneric(c, args, "brpop", false)
+}
+
+// blockingPopGeneric: BLPOP と BRPOP の共通処理です。
+func blockingPopGeneric(c *Client, args []Value, name string, head bool) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	timeout, errValue := parseBlockingTimeout(args[len(args)-1].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[:len(args)-1] {
+		keys[i] = arg.bulk
+	}
+	preventPropagation(c)
+
+	// pop: キーのリストから要素を1つ取り出し、[キー, 要素] の応答を作ります。
+	// 実際に取り出した要素は、AOFには LPOP / RPOP として記録します（再生したときにブロックしないようにするためです）。
+	popCommand := "RPOP"
+	if head {
+		popCommand = "LPOP"
+	}
+	pop := func(key string, o *Object) Value {
+		value, _ := popListElement(o.list(), head)
+		deleteListIfEmpty(key, o)
+		propagate(popCommand, key)
+		return Value{typ: "array", array: []Value{{typ: "bulk", bulk: key}, {typ: "bulk", bulk: value}}}
+	}
+
+	db.mu.Lock()
+
+	// 1. 要素があるキーがあれば、ブロックせずにすぐ返します。
+	for _, key := range keys {
+		o, errValue := lookupListWrite(key, false)
+		if errValue != nil {
+			db.mu.Unlock()
+			return *errValue
+		}
+		if o != nil {
+			defer db.mu.Unlock()
+			return pop(key, o)
+		}
+	}
+
+	// AOFの再生中の疑似クライアントはブロックできないので、タイムアウトしたものとして扱います。
+	if c.conn == nil {
+		db.mu.Unlock()
+		return Value{typ: "nullarray"}
+	}
+
+	// 2. すべてのキーが空なので、要素が追加されるまで待ちます。
+	bc := &blockedClient{keys: keys}
+	bc.tryServe = func(key string) (Value, bool) {
+		o, errValue := lookupListWrite(key, false)
+		if errValue != nil || o == nil {
+			return Value{}, false
+		}
+		return pop(key, o), true
+	}
+	return blockClient(c, bc, timeout, Value{typ: "nullarray"})
+}
+
+// ------------------------------
+// BLMOVE / BRPOPLPUSH コマンド
+// ------------------------------
+
+// blmove: BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+// LMOVE と同じですが、source が空の場合は要素が追加されるまで最大 timeout 秒待ちます。
+func blmove(c *Client, args []Value) Value {
+	if len(args) != 5 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'blmove' command"}
+	}
+
+	fromHead, ok1 := parseListSide(args[2].bulk)
+	toHead, ok2 := parseListSide(args[3].bulk)
+	if !ok1 || !ok2 {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	return blockingMoveGeneric(c, args[0].bulk, args[1].bulk, fromHead, toHead, args[4].bulk)
+}
+
+// brpoplpush: BRPOPLPUSH source destination timeout
+// BLMOVE source destination RIGHT LEFT timeout と同じです。
+func brpoplpush(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'brpoplpush' command"}
+	}
+	return blockingMoveGeneric(c, args[0].bulk, args[1].bulk, false, true, args[2].bulk)
+}
+
+// blockingMoveGeneric: BLMOVE と BRPOPLPUSH の共通処理です。
+func blockingMoveGeneric(c *Client, source, destination string, fromHead, toHead bool, timeoutArg string) Value {
+	timeout, errValue := parseBlockingTimeout(timeoutArg)
+	if errValue != nil {
+		return *errValue
+	}
+	preventPropagation(c)
+
+	// move: LMOVE を実行し、AOFには LMOVE として記録します。
+	// 移動先で待っているクライアントへの受け渡し（その LPOP など）より前に記録されるよう、実行する前に記録します。
+	move := func() Value {
+		if _, errValue := lookupListWrite(destination, false); errValue != nil {
+			return *errValue
+		}
+		propagate("LMOVE", source, destination, listSideName(fromHead), listSideName(toHead))
+		return lmoveGeneric(source, destination, fromHead, toHead)
+	}
+
+	db.mu.Lock()
+
+	o, errValue := lookupListWrite(source, false)
+	if errValue != nil {
+		db.mu.Unlock()
+		return *errValue
+	}
+	if o != nil || c.conn == nil {
+		defer db.mu.Unlock()
+		if o == nil {
+			return Value{typ: "null"}
+		}
+		return move()
+	}
+
+	bc := &blockedClient{keys: []string{source}}
+	bc.tryServe = func(key string) (Value, bool) {
+		if o, _ := lookupListWrite(source, false); o == nil {
+			return Value{}, false
+		}
+		return move(), true
+	}
+	return blockClient(c, bc, timeout, Value{typ: "null"})
+}
+
+// listSideName: LMOVE の方向を表す文字列（LEFT / RIGHT）を返します。
+func listSideName(head bool) string {
+	if head {
+		return "LEFT"
+	}
+	return "RIGHT"
+}
@@ -0,0 +1,857 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ====================================================================
+// サーバー設定
+// ====================================================================
+
+// Config構造体: サーバーの設定値をまとめて保持します。
+// 値は「デフォルト値 → 設定ファイル（redis.conf 形式）→ コマンドラインフラグ」の順に上書きされます。
+type Config struct {
+	mu sync.RWMutex // 実行中に設定を読み書きするためのRWMutex
+
+	port            int      // 待ち受けるTCPポート番号
+	bind            []string // 待ち受けるアドレスの一覧（空の場合は全てのインターフェース）
+	appendOnly      bool     // AOFによる永続化を有効にするかどうか
+	appendFilename  string   // AOFファイルの名前（マルチパートAOFの各ファイル名の先頭にも使います）
+	appendDirname   string   // AOFのファイルとマニフェストを置くディレクトリの名前（dir の中に作成されます）
+	appendFsync     string   // AOFをディスクに同期するタイミング（always / everysec / no）
+	dir             string   // 作業ディレクトリ（AOFファイルはここに作成されます）
+	maxClients      int      // 同時に接続できるクライアントの最大数
+	requirePass     string   // クライアントに要求するパスワード（空の場合は認証なし）
+	protoMaxBulkLen int      // クライアントから受け付けるバルク文字列の最大バイト数
+
+	autoAofRewritePercentage int // 前回の書き換えからAOFがこの割合（%）以上大きくなったら自動で書き換えます（0 なら自動で書き換えません）
+	autoAofRewriteMinSize    int // AOFがこのバイト数より小さいうちは、自動で書き換えません
+
+	aofLoadTruncated bool // 起動時に、最後のAOFファイルが書きかけのコマンドで終わっていたら、そこを切り詰めて起動を続けるかどうか
+
+	setMaxIntsetEntries    int // セットを intset で保存する最大の要素数（超えるとハッシュテーブルに変換します）
+	zsetMaxListpackEntries int // ソート済みセットを listpack で保存する最大の要素数（超えるとスキップリストに変換します）
+	zsetMaxListpackValue   int // ソート済みセットを listpack で保存できるメンバーの最大バイト数
+	streamNodeMaxEntries   int // ストリームの索引の1つのノードにまとめるエントリーの最大数
+
+	file string // 読み込んだ設定ファイルの絶対パス（設定ファイルなしで起動した場合は空）
+}
+
+// config は現在のサーバー設定です。main で起動時に読み込まれます。
+var config = newConfig()
+
+// newConfig: デフォルト値で初期化された Config を作成します。
+func newConfig() *Config {
+	return &Config{
+		port:            6379,
+		appendOnly:      true,
+		appendFilename:  "database.aof",
+		appendDirname:   "appendonlydir",
+		appendFsync:     "everysec",
+		dir:             ".",
+		maxClients:      10000,
+		protoMaxBulkLen: defaultMaxBulkLen,
+
+		autoAofRewritePercentage: 100,
+		autoAofRewriteMinSize:    64 * 1024 * 1024,
+		aofLoadTruncated:         true,
+
+		setMaxIntsetEntries:    512,
+		zsetMaxListpackEntries: 128,
+		zsetMaxListpackValue:   64,
+		streamNodeMaxEntries:   100,
+	}
+}
+
+// ====================================================================
+// 設定項目（ディレクティブ）の定義
+// ====================================================================
+
+// configDirective構造体: 設定ファイルの1つのディレクティブ（例: "port 6379"）の扱い方を定義します。
+// set は文字列の値を検証して Config に反映し、get は現在の値を文字列で返します。
+type configDirective struct {
+	name         string                                // ディレクティブ名（小文字）
+	usage        string                                // コマンドラインフラグの説明文
+	multiArg     bool                                  // 複数の引数を取るかどうか（bind など）
+	immutable    bool                                  // 起動後に CONFIG SET で変更できないかどうか
+	forceRewrite bool                                  // CONFIG REWRITE でデフォルト値でも必ず書き出すかどうか
+	set          func(cfg *Config, value string) error // 値を検証して設定する関数
+	get          func(cfg *Config) string              // 現在の値を文字列で返す関数
+}
+
+// configDirectives: サポートしている全ディレクティブの一覧です。
+var configDirectives = []*configDirective{
+	{
+		name:      "port",
+		usage:     "TCP port to listen on",
+		immutable: true,
+		set: func(cfg *Config, value string) error {
+			n, err := parseConfigInt(value, 0, 65535)
+			if err != nil {
+				return err
+			}
+			cfg.port = n
+			return nil
+		},
+		get: func(cfg *Config) string { return strconv.Itoa(cfg.port) },
+	},
+	{
+		name:      "bind",
+		usage:     "space separated list of addresses to listen on",
+		immutable: true,
+		multiArg:  true,
+		set: func(cfg *Config, value string) error {
+			cfg.bind = strings.Fields(value)
+			return nil
+		},
+		get: func(cfg *Config) string { return strings.Join(cfg.bind, " ") },
+	},
+	{
+		name:      "appendonly",
+		usage:     "enable AOF persistence (yes|no)",
+		immutable: true,
+		set: func(cfg *Config, value string) error {
+			b, err := parseConfigBool(value)
+			if err != nil {
+				return err
+			}
+			cfg.appendOnly = b
+			return nil
+		},
+		get: func(cfg *Config) string { return formatConfigBool(cfg.appendOnly) },
+	},
+	{
+		name:      "appendfilename",
+		usage:     "name of the append only file",
+		immutable: true,
+		set: func(cfg *Config, value string) error {
+			// Redisと同様に、ディレクトリを含むパスは指定できません（ファイルは dir に作成されます）。
+			if value == "" || strings.ContainsRune(value, filepath.Separator) {
+				return errors.New("appendfilename can't be a path, just a filename")
+			}
+			cfg.appendFilename = value
+			return nil
+		},
+		get: func(cfg *Config) string { return cfg.appendFilename },
+	},
+	{
+		name:      "appenddirname",
+		usage:     "name of the directory that holds the AOF files and the manifest",
+		immutable: true,
+		set: func(cfg *Config, value string) error {
+			// appendfilename と同じく、ディレクトリは dir の中に作成するので、パスは指定できません。
+			if value == "" || strings.ContainsRune(value, filepath.Separator) {
+				return errors.New("appenddirname can't be a path, just a dirname")
+			}
+			cfg.appendDirname = value
+			return nil
+		},
+		get: func(cfg *Config) string { return cfg.appendDirname },
+	},
+	{
+		name:  "appendfsync",
+		usage: "fsync policy for the AOF (always|everysec|no)",
+		set: func(cfg *Config, value string) error {
+			value = strings.ToLower(value)
+			switch value {
+			case "always", "everysec", "no":
+			default:
+				return errors.New("argument must be 'always', 'everysec' or 'no'")
+			}
+			cfg.appendFsync = value
+			return nil
+		},
+		get: func(cfg *Config) string { return cfg.appendFsync },
+	},
+	{
+		name:  "auto-aof-rewrite-percentage",
+		usage: "rewrite the AOF automatically when it grows by this percentage since the last rewrite (0 disables)",
+		set: func(cfg *Config, value string) error {
+			n, err := parseConfigInt(value, 0, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			cfg.autoAofRewritePercentage = n
+			return nil
+		},
+		get: func(cfg *Config) string { return strconv.Itoa(cfg.autoAofRewritePercentage) },
+	},
+	{
+		name:  "auto-aof-rewrite-min-size",
+		usage: "minimum size of the AOF before it is rewritten automatically (e.g. 64mb)",
+		set: func(cfg *Config, value string) error {
+			n, err := parseConfigMemory(value, 0, math.MaxInt64)
+			if err != nil {
+				return err
+			}
+			cfg.autoAofRewriteMinSize = n
+			return nil
+		},
+		get: func(cfg *Config) string { return strconv.Itoa(cfg.autoAofRewriteMinSize) },
+	},
+	{
+		name:  "aof-load-truncated",
+		usage: "load an AOF whose last command was only partially written, truncating the torn tail (yes|no)",
+		set: func(cfg *Config, value string) error {
+			b, err := parseConfigBool(value)
+			if err != nil {
+				return err
+			}
+			cfg.aofLoadTruncated = b
+			return nil
+		},
+		get: func(cfg *Config) string { return formatConfigBool(cfg.aofLoadTruncated) },
+	},
+	{
+		name:         "dir",
+		usage:        "working directory where the AOF is created",
+		forceRewrite: true, // Redisと同様に、作業ディレクトリは常に書き出します
+		set: func(cfg *Config, value string) error {
+			// Redisと同様に、プロセスの作業ディレクトリそのものを変更します。
+			if err := os.Chdir(value); err != nil {
+				return err
+			}
+			cfg.dir = value
+			return nil
+		},
+		get: func(cfg *Config) string {
+			// 相対パスで指定されていても、実際の絶対パスを返します。
+			if wd, err := os.Getwd(); err == nil {
+				return wd
+			}
+			return cfg.dir
+		},
+	},
+	{
+		name:  "maxclients",
+		usage: "maximum number of connected clients",
+		set: func(cfg *Config, value string) error {
+			n, err := parseConfigInt(value, 1, 1<<31-1)
+			if err != nil {
+				return err
+			}
+			cfg.maxClients = n
+			return nil
+		},
+		get: func(cfg *Config) string { return strconv.Itoa(cfg.maxClients) },
+	},
+	{
+		name:  "requirepass",
+		usage: "password clients must send with AUTH",
+		set: func(cfg *Config, value string) error {
+			cfg.requirePass = value
+			return nil
+		},
+		get: func(cfg *Config) string { return cfg.requirePass },
+	},
+	{
+		name:  "proto-max-bulk-len",
+		usage: "maximum size of a single bulk string sent by clients (e.g. 512mb)",
+		set: func(cfg *Config, value string) error {
+			n, err := parseConfigMemory(value, 1024*1024, math.MaxInt64)
+			if err != nil {
+				return err
+			}
+			cfg.protoMaxBulkLen = n
+			return nil
+		},
+		get: func(cfg *Config) string { return strconv.Itoa(cfg.protoMaxBulkLen) },
+	},
+	{
+		name:  "set-max-intset-entries",
+		usage: "maximum number of entries of a set encoded as an intset",
+		set: func(cfg *Config, value string) error {
+			n, err := parseConfigInt(value, 0, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			cfg.setMaxIntsetEntries = n
+			return nil
+		},
+		get: func(cfg *Config) string { return strconv.Itoa(cfg.setMaxIntsetEntries) },
+	},
+	{
+		name:  "zset-max-listpack-entries",
+		usage: "maximum number of entries of a sorted set encoded as a listpack",
+		set: func(cfg *Config, value string) error {
+			n, err := parseConfigInt(value, 0, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			cfg.zsetMaxListpackEntries = n
+			return nil
+		},
+		get: func(cfg *Config) string { return strconv.Itoa(cfg.zsetMaxListpackEntries) },
+	},
+	{
+		name:  "zset-max-listpack-value",
+		usage: "maximum length in bytes of a sorted set member encoded in a listpack",
+		set: func(cfg *Config, value string) error {
+			n, err := parseConfigInt(value, 0, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			cfg.zsetMaxListpackValue = n
+			return nil
+		},
+		get: func(cfg *Config) string { return strconv.Itoa(cfg.zsetMaxListpackValue) },
+	},
+	{
+		name:  "stream-node-max-entries",
+		usage: "maximum number of entries in a single node of a stream (0 means unlimited)",
+		set: func(cfg *Config, value string) error {
+			n, err := parseConfigInt(value, 0, math.MaxInt32)
+			if err != nil {
+				return err
+			}
+			cfg.streamNodeMaxEntries = n
+			return nil
+		},
+		get: func(cfg *Config) string { return strconv.Itoa(cfg.streamNodeMaxEntries) },
+	},
+}
+
+// lookupConfigDirective: 名前（大文字小文字は区別しない）からディレクティブを探します。
+func lookupConfigDirective(name string) *configDirective {
+	name = strings.ToLower(name)
+	for _, d := range configDirectives {
+		if d.name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// parseConfigInt: 設定値を整数として解析し、[min, max] の範囲内かを検証します。
+func parseConfigInt(value string, min, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("argument couldn't be parsed into an integer")
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+	}
+	return n, nil
+}
+
+// parseConfigMemory: "512mb" や "1gb" のような単位付きのサイズをバイト数に変換し、[min, max] の範囲内かを検証します。
+// 単位は Redis と同じく k/kb/m/mb/g/gb（k は1000、kb は1024）で、大文字小文字は区別しません。
+func parseConfigMemory(value string, min, max int) (int, error) {
+	units := []struct {
+		suffix string
+		mul    int
+	}{
+		// 長い接尾辞から順に調べます（"mb" を "b" より先に判定するため）。
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	lower := strings.ToLower(value)
+	mul := 1
+	for _, u := range units {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower = strings.TrimSuffix(lower, u.suffix)
+			mul = u.mul
+			break
+		}
+	}
+
+	n, err := strconv.Atoi(lower)
+	if err != nil || n < 0 || n > max/mul {
+		return 0, errors.New("argument must be a memory value")
+	}
+	n *= mul
+	if n < min || n > max {
+		return 0, fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+	}
+	return n, nil
+}
+
+// parseConfigBool: "yes" / "no" 形式の設定値を bool に変換します。
+func parseConfigBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, errors.New("argument must be 'yes' or 'no'")
+}
+
+// formatConfigBool: bool を "yes" / "no" 形式の文字列に変換します。
+func formatConfigBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// ====================================================================
+// 設定の読み込み（設定ファイルとコマンドラインフラグ）
+// ====================================================================
+
+// configFileError: 設定ファイルの読み込みエラーです。
+// Redisと同様に、問題のある行の番号と内容をエラーメッセージに含めます。
+type configFileError struct {
+	line int    // 問題のある行の番号（1始まり）
+	text string // 問題のある行の内容
+	msg  string // エラーの理由
+}
+
+func (e *configFileError) Error() string {
+	return fmt.Sprintf("\n*** FATAL CONFIG FILE ERROR ***\nReading the configuration file, at line %d\n>>> '%s'\n%s",
+		e.line, e.text, e.msg)
+}
+
+// loadConfig: コマンドライン引数からサーバー設定を読み込みます。
+// redis-server と同様に、最初の引数が "-" で始まらない場合は設定ファイルのパスとして扱います。
+//
+//	例: ./redis redis.conf --port 6380 --appendonly no
+func loadConfig(args []string) (*Config, error) {
+	cfg := newConfig()
+
+	// 1. 設定ファイルを読み込みます。
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		// dir ディレクティブで作業ディレクトリが変わる前に、絶対パスに変換しておきます。
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+		cfg.file = path
+		args = args[1:]
+	}
+
+	// 2. コマンドラインフラグで上書きします。各ディレクティブがそのままフラグになります（例: --port 6380）。
+	fs := flag.NewFlagSet("redis-server", flag.ContinueOnError)
+	for _, d := range configDirectives {
+		fs.Func(d.name, d.usage, func(value string) error {
+			return d.set(cfg, value)
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument '%s' (the config file must be the first argument)", fs.Arg(0))
+	}
+
+	return cfg, nil
+}
+
+// loadFile: redis.conf 形式の設定ファイルを1行ずつ読み込みます。
+// 空行と '#' で始まるコメント行は無視し、それ以外は「ディレクティブ名 引数...」として解釈します。
+func (cfg *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+
+		// 空行とコメント行をスキップします。
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		// 行を引数に分割します（引用符で囲まれた値も扱えます）。
+		argv, err := splitArgs(line)
+		if err != nil {
+			return &configFileError{line: lineno, text: line, msg: "Unbalanced quotes in configuration line"}
+		}
+		if len(argv) == 0 {
+			continue
+		}
+
+		// ディレクティブを検索し、引数の数を検証します。
+		d := lookupConfigDirective(argv[0])
+		if d == nil || len(argv) < 2 || (!d.multiArg && len(argv) != 2) {
+			return &configFileError{line: lineno, text: line, msg: "Bad directive or wrong number of arguments"}
+		}
+
+		// 値を設定します。
+		if err := d.set(cfg, strings.Join(argv[1:], " ")); err != nil {
+			return &configFileError{line: lineno, text: line, msg: err.Error()}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// listenAddrs: 待ち受けるアドレス（"host:port" 形式）の一覧を返します。
+func (cfg *Config) listenAddrs() []string {
+	port := strconv.Itoa(cfg.port)
+
+	// bind が指定されていない場合は、全てのインターフェースで待ち受けます。
+	if len(cfg.bind) == 0 {
+		return []string{":" + port}
+	}
+
+	addrs := make([]string, 0, len(cfg.bind))
+	for _, host := range cfg.bind {
+		// "*" は全てのインターフェースを意味します。
+		if host == "*" {
+			host = ""
+		}
+		addrs = append(addrs, net.JoinHostPort(host, port))
+	}
+	return addrs
+}
+
+// getMaxClients: 現在の maxclients の値を返します。
+func (cfg *Config) getMaxClients() int {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+
+	return cfg.maxClients
+}
+
+// getProtoMaxBulkLen: 現在の proto-max-bulk-len の値を返します。
+func (cfg *Config) getProtoMaxBulkLen() int {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+
+	return cfg.protoMaxBulkLen
+}
+
+// getSetMaxIntsetEntries: 現在の set-max-intset-entries の値を返します。
+func (cfg *Config) getSetMaxIntsetEntries() int {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+
+	return cfg.setMaxIntsetEntries
+}
+
+// getZsetMaxListpack: 現在の zset-max-listpack-entries と zset-max-listpack-value の値を返します。
+func (cfg *Config) getZsetMaxListpack() (entries, value int) {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+
+	return cfg.zsetMaxListpackEntries, cfg.zsetMaxListpackValue
+}
+
+// getStreamNodeMaxEntries: 現在の stream-node-max-entries の値を返します。
+func (cfg *Config) getStreamNodeMaxEntries() int {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+
+	return cfg.streamNodeMaxEntries
+}
+
+// getAppendFsync: 現在の appendfsync の値（always / everysec / no）を返します。
+func (cfg *Config) getAppendFsync() string {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+
+	return cfg.appendFsync
+}
+
+// getAutoAofRewrite: 現在の auto-aof-rewrite-percentage と auto-aof-rewrite-min-size の値を返します。
+func (cfg *Config) getAutoAofRewrite() (percentage, minSize int) {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+
+	return cfg.autoAofRewritePercentage, cfg.autoAofRewriteMinSize
+}
+
+// getAofLoadTruncated: 現在の aof-load-truncated の値を返します。
+func (cfg *Config) getAofLoadTruncated() bool {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+
+	return cfg.aofLoadTruncated
+}
+
+// getRequirePass: 現在の requirepass の値を返します。
+func (cfg *Config) getRequirePass() string {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+
+	return cfg.requirePass
+}
+
+// ====================================================================
+// CONFIG コマンド（GET / SET / REWRITE）
+// ====================================================================
+
+// configCommand: CONFIG コマンドの処理関数です。サブコマンドに応じて処理を振り分けます。
+func configCommand(c *Client, args []Value) Value {
+	if len(args) == 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'config' command"}
+	}
+
+	subcommand := strings.ToUpper(args[0].bulk)
+	switch subcommand {
+	case "GET":
+		if len(args) < 2 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'config|get' command"}
+		}
+		return configGet(args[1:])
+	case "SET":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'config|set' command"}
+		}
+		return configSet(args[1:])
+	case "REWRITE":
+		if len(args) != 1 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'config|rewrite' command"}
+		}
+		if err := config.rewrite(); err != nil {
+			return Value{typ: "error", str: "ERR " + err.Error()}
+		}
+		return Value{typ: "string", str: "OK"}
+	default:
+		return Value{typ: "error", str: fmt.Sprintf("ERR unknown subcommand '%s'. Try CONFIG HELP.", args[0].bulk)}
+	}
+}
+
+// configGet: CONFIG GET pattern [pattern ...]
+// パターン（glob形式）に一致する設定項目を「名前 → 値」のマップで返します（RESP2 では配列になります）。
+func configGet(patterns []Value) Value {
+	config.mu.RLock()
+	defer config.mu.RUnlock()
+
+	reply := Value{typ: "map", array: []Value{}}
+	for _, d := range configDirectives {
+		for _, pattern := range patterns {
+			if stringMatch(pattern.bulk, d.name, true) {
+				reply.array = append(reply.array,
+					Value{typ: "bulk", bulk: d.name},
+					Value{typ: "bulk", bulk: d.get(config)},
+				)
+				// 複数のパターンに一致しても、1つの項目は1回だけ返します。
+				break
+			}
+		}
+	}
+	return reply
+}
+
+// configSet: CONFIG SET parameter value [parameter value ...]
+// 複数の項目を指定した場合は、すべて成功するか、すべて元に戻るかのどちらかになります。
+func configSet(args []Value) Value {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+
+	// 1. すべての項目名を先に検証します（未知の項目、変更できない項目、重複）。
+	directives := make([]*configDirective, 0, len(args)/2)
+	seen := map[string]bool{}
+	for i := 0; i < len(args); i += 2 {
+		d := lookupConfigDirective(args[i].bulk)
+		if d == nil {
+			return Value{typ: "error", str: fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i].bulk)}
+		}
+		if d.immutable {
+			return configSetError(d.name, "can't set immutable config")
+		}
+		if seen[d.name] {
+			return configSetError(d.name, "duplicate parameter")
+		}
+		seen[d.name] = true
+		directives = append(directives, d)
+	}
+
+	// 2. 失敗したときに元に戻せるよう、現在の値を保存してから順番に設定します。
+	oldValues := make([]string, 0, len(directives))
+	for i, d := range directives {
+		oldValues = append(oldValues, d.get(config))
+		if err := d.set(config, args[i*2+1].bulk); err != nil {
+			// すでに変更した項目を、逆順に元の値へ戻します。
+			for j := i - 1; j >= 0; j-- {
+				directives[j].set(config, oldValues[j])
+			}
+			return configSetError(d.name, err.Error())
+		}
+	}
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// configSetError: CONFIG SET が失敗したときのエラー応答を作成します。
+func configSetError(name, reason string) Value {
+	return Value{typ: "error", str: fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", name, reason)}
+}
+
+// CONFIG REWRITE が設定ファイルの末尾に項目を追加するときに、その前に書き出す目印のコメントです。
+const configRewriteSignature = "# Generated by CONFIG REWRITE"
+
+// rewrite: 現在の設定値を設定ファイルに書き戻します（CONFIG REWRITE）。
+// Redisと同様に、コメントや空行、項目の順序はそのまま残し、
+//   - ファイルにある項目は、その行を現在の値で書き換える（2回目以降に出てくる同じ項目の行は削除する）
+//   - ファイルにない項目は、デフォルト値から変更されている場合だけ末尾に追加する
+//
+// 書き込み途中でクラッシュしても元のファイルが壊れないよう、一時ファイルに書いてから置き換えます。
+func (cfg *Config) rewrite() error {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+
+	if cfg.file == "" {
+		return errors.New("The server is running without a config file")
+	}
+
+	// 1. 元の設定ファイルを読み込みます（削除されていた場合は空のファイルとして扱います）。
+	data, err := os.ReadFile(cfg.file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+
+	// 2. 既存の行を順番に処理し、ディレクティブの行だけを現在の値で置き換えます。
+	out := make([]string, 0, len(lines))
+	written := map[string]bool{} // すでに書き出したディレクティブ
+	needsSignature := true
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		// コメントと空行はそのまま残します。
+		if trimmed == "" || trimmed[0] == '#' {
+			if trimmed == configRewriteSignature {
+				needsSignature = false
+			}
+			out = append(out, line)
+			continue
+		}
+
+		argv, err := splitArgs(trimmed)
+		if err != nil || len(argv) == 0 {
+			out = append(out, line)
+			continue
+		}
+		d := lookupConfigDirective(argv[0])
+		if d == nil {
+			out = append(out, line)
+			continue
+		}
+
+		// 同じディレクティブが複数行ある場合は、最初の行だけを残します。
+		if written[d.name] {
+			continue
+		}
+		written[d.name] = true
+		out = append(out, formatConfigLine(d, cfg))
+	}
+
+	// 3. ファイルになかったディレクティブのうち、デフォルト値から変更されたものを末尾に追加します。
+	defaults := newConfig()
+	for _, d := range configDirectives {
+		if written[d.name] {
+			continue
+		}
+		if !d.forceRewrite && d.get(cfg) == d.get(defaults) {
+			continue
+		}
+		if needsSignature {
+			out = append(out, configRewriteSignature)
+			needsSignature = false
+		}
+		out = append(out, formatConfigLine(d, cfg))
+	}
+
+	// 4. 一時ファイルに書き込み、ディスクに同期してから元のファイルと置き換えます。
+	tmp, err := os.CreateTemp(filepath.Dir(cfg.file), "temp-config-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // 置き換えに成功した場合は、何もしません
+
+	if _, err := tmp.WriteString(strings.Join(out, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// 元のファイルのパーミッションを引き継ぎます。
+	if info, err := os.Stat(cfg.file); err == nil {
+		os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+
+	return os.Rename(tmp.Name(), cfg.file)
+}
+
+// formatConfigLine: ディレクティブを設定ファイルの1行（例: "port 6379"）の形式に変換します。
+func formatConfigLine(d *configDirective, cfg *Config) string {
+	value := d.get(cfg)
+
+	// bind のように複数の引数を取るものは、引数ごとに書き出します。
+	if d.multiArg {
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return d.name + ` ""`
+		}
+		for i, f := range fields {
+			fields[i] = quoteConfigArg(f)
+		}
+		return d.name + " " + strings.Join(fields, " ")
+	}
+
+	return d.name + " " + quoteConfigArg(value)
+}
+
+// quoteConfigArg: 設定ファイルに書き出す値を、必要に応じてダブルクォートで囲みます。
+// 空白や引用符、制御文字を含む値は、splitArgs で元に戻せるようにエスケープします（Redisの sdscatrepr に相当）。
+func quoteConfigArg(s string) string {
+	needsQuote := s == ""
+	for i := 0; i < len(s) && !needsQuote; i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == '\'' || c == '\\' {
+			needsQuote = true
+		}
+	}
+	if !needsQuote {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if c < ' ' || c >= 0x7f {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
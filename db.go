@@ -0,0 +1,293 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// ====================================================================
+// キースペース（DB）とオブジェクト
+// ====================================================================
+//
+// Redisでは、すべてのキーが1つの辞書（キースペース）に保存され、値は「型」の情報を持つオブジェクト
+// （redisObject）として表現されます。このサーバーも同じ構造で、キー -> *Object のマップを1つだけ持ちます。
+// そのため、同じキー名が文字列とハッシュの両方に存在することはなく、型が合わないコマンドを実行すると
+// WRONGTYPE エラーになります。
+
+// オブジェクトの型です（TYPE コマンドが返す名前と同じです）。
+const (
+	ObjString = "string"
+	ObjHash   = "hash"
+	ObjList   = "list"
+	ObjSet    = "set"
+	ObjZset   = "zset"
+	ObjStream = "stream"
+)
+
+// オブジェクトのエンコーディング（内部表現）です（OBJECT ENCODING コマンドが返す名前と同じです）。
+const (
+	EncodingRaw       = "raw"       // 通常の文字列
+	EncodingEmbstr    = "embstr"    // 短い文字列（44バイト以下）
+	EncodingInt       = "int"       // 64ビット整数として表現できる文字列
+	EncodingHashtable = "hashtable" // ハッシュテーブル（Goのマップ）
+	EncodingQuicklist = "quicklist" // 小さな配列をノードとする双方向連結リスト（quicklist.go）
+	EncodingIntset    = "intset"    // 小さい順に並んだ整数の配列（intset.go）
+	EncodingListpack  = "listpack"  // 小さな要素を順番に並べた配列（ソート済みセットでは zsetListpack）
+	EncodingSkiplist  = "skiplist"  // スキップリストと辞書の組み合わせ（skiplist.go）
+	EncodingStream    = "stream"    // エントリーをノードにまとめた索引（streamindex.go）
+)
+
+// Redisで embstr エンコーディングになる文字列の最大長です。
+const embstrSizeLimit = 44
+
+// Object構造体: キースペースに保存される値です（Redisの redisObject に相当します）。
+type Object struct {
+	typ      string // 型（ObjString, ObjHash など）
+	encoding string // エンコーディング（EncodingRaw, EncodingHashtable など）
+	value    any    // 値の本体（文字列なら string、ハッシュなら map[string]string、リストなら *quicklist、セットなら *intset か map[string]struct{}、ソート済みセットなら *zsetListpack か *zset、ストリームなら *stream）
+	lru      int64  // 最後にアクセスされた時刻（Unix時間のミリ秒）。読み取りロック中にも更新するため atomic で扱います
+}
+
+// wrongTypeError: キーの型がコマンドと合わない場合のエラー応答です。
+var wrongTypeError = Value{typ: "error", str: "WRONGTYPE Operation against a key holding the wrong kind of value"}
+
+// newStringObject: 文字列のオブジェクトを作成します。
+func newStringObject(s string) *Object {
+	return &Object{typ: ObjString, encoding: stringEncoding(s), value: s, lru: mstime()}
+}
+
+// newHashObject: 空のハッシュのオブジェクトを作成します。
+func newHashObject() *Object {
+	return &Object{typ: ObjHash, encoding: EncodingHashtable, value: map[string]string{}, lru: mstime()}
+}
+
+// newListObject: 空のリストのオブジェクトを作成します。
+func newListObject() *Object {
+	return &Object{typ: ObjList, encoding: EncodingQuicklist, value: newQuicklist(), lru: mstime()}
+}
+
+// newSetObject: 空のセットのオブジェクトを作成します。
+// 最初の要素が整数なら intset、そうでなければハッシュテーブルで作成します（Redisの setTypeCreate と同じ判定です）。
+func newSetObject(first string) *Object {
+	if _, ok := parseInteger(first); ok {
+		return &Object{typ: ObjSet, encoding: EncodingIntset, value: newIntset(), lru: mstime()}
+	}
+	return &Object{typ: ObjSet, encoding: EncodingHashtable, value: map[string]struct{}{}, lru: mstime()}
+}
+
+// newZsetObject: 空のソート済みセットのオブジェクトを作成します。
+// 要素数の見込み（sizeHint）とメンバーの最大の長さの見込み（valueLenHint）が listpack の上限以下なら listpack、
+// そうでなければスキップリストで作成します（Redisの zsetTypeCreate と同じ判定です）。
+func newZsetObject(sizeHint, valueLenHint int) *Object {
+	maxEntries, maxValue := config.getZsetMaxListpack()
+	if sizeHint <= maxEntries && valueLenHint <= maxValue {
+		return &Object{typ: ObjZset, encoding: EncodingListpack, value: &zsetListpack{}, lru: mstime()}
+	}
+	return &Object{typ: ObjZset, encoding: EncodingSkiplist, value: newZset(), lru: mstime()}
+}
+
+// newStreamObject: 空のストリームのオブジェクトを作成します。
+func newStreamObject() *Object {
+	return &Object{typ: ObjStream, encoding: EncodingStream, value: newStream(), lru: mstime()}
+}
+
+// dup: 値をすべてコピーした新しいオブジェクトを返します（COPY コマンドで使います）。
+// コピー元とコピー先は、どちらを変更してももう一方に影響しません。エンコーディングも元と同じになります。
+func (o *Object) dup() *Object {
+	var value any
+	switch v := o.value.(type) {
+	case string:
+		value = v // Goの文字列は変更できないので、共有しても問題ありません
+	case map[string]string:
+		value = maps.Clone(v)
+	case *quicklist:
+		value = v.Dup()
+	case *intset:
+		value = v.Dup()
+	case map[string]struct{}:
+		value = maps.Clone(v)
+	case *zsetListpack:
+		value = &zsetListpack{entries: slices.Clone(v.entries)}
+	case *zset:
+		value = v.dup()
+	case *stream:
+		value = v.dup()
+	}
+	return &Object{typ: o.typ, encoding: o.encoding, value: value, lru: mstime()}
+}
+
+// stringEncoding: 文字列の値に対応するエンコーディングを返します（Redisの tryObjectEncoding と同じ判定です）。
+func stringEncoding(s string) string {
+	if len(s) <= 20 {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(n, 10) == s {
+			return EncodingInt
+		}
+	}
+	if len(s) <= embstrSizeLimit {
+		return EncodingEmbstr
+	}
+	return EncodingRaw
+}
+
+// str: 文字列のオブジェクトの値を返します。
+func (o *Object) str() string {
+	return o.value.(string)
+}
+
+// hash: ハッシュのオブジェクトの値を返します。
+func (o *Object) hash() map[string]string {
+	return o.value.(map[string]string)
+}
+
+// list: リストのオブジェクトの値を返します。
+func (o *Object) list() *quicklist {
+	return o.value.(*quicklist)
+}
+
+// touch: オブジェクトの最終アクセス時刻を更新します。
+func (o *Object) touch() {
+	atomic.StoreInt64(&o.lru, mstime())
+}
+
+// DB構造体: キースペースです（Redisの redisDb に相当します）。
+// すべてのキーとその有効期限を1つのロックで保護します。
+// 読み取りコマンドは RLock、書き込みコマンドは Lock を取得してから、以下のメソッドを呼び出します。
+// 複数のキーにまたがるコマンドも、1つのロックの中で実行するだけでアトミックになります。
+type DB struct {
+	mu      sync.RWMutex
+	dict    map[string]*Object // キー -> 値のオブジェクト
+	expires map[string]int64   // キー -> 有効期限（Unix時間のミリ秒）。有効期限のないキーは含まれません
+
+	blocked map[string][]*blockedClient // キー -> そのキーを待っているクライアント（待ち始めた順。blocking.go を参照）
+
+	// dirty: データを変更した回数です（Redisの server.dirty）。書き込みコマンドは、データを変更するたびに増やします。
+	// call はコマンドの実行前後でこの値を比べ、変わっていればコマンドをAOFに記録します。
+	dirty int64
+
+	// loading: AOFを読み込んでいる間は true です（Redisの server.loading）。
+	// 読み込み中は、どのキーも期限切れとはみなしません（isExpired を参照）。
+	loading bool
+}
+
+// db: サーバーのキースペースです。
+var db = NewDB()
+
+// NewDB: 空のキースペースを作成します。
+func NewDB() *DB {
+	return &DB{
+		dict:    map[string]*Object{},
+		expires: map[string]int64{},
+		blocked: map[string][]*blockedClient{},
+	}
+}
+
+// lookupKeyRead: 読み取りのためにキーを検索します。キーが存在しないか、有効期限が切れている場合は nil を返します。
+// 読み取りロック（RLock）を取得した状態で呼び出します。
+// 読み取りロックではキーを削除できないため、期限切れのキーは「存在しない」とみなすだけです。
+// 実際の削除は、事前に呼び出す expireIfNeeded か、定期削除が行います。
+func (d *DB) lookupKeyRead(key string) *Object {
+	o, ok := d.dict[key]
+	if !ok || d.isExpired(key) {
+		return nil
+	}
+	o.touch()
+	return o
+}
+
+// lookupKeyWrite: 書き込みのためにキーを検索します。有効期限が切れている場合は削除してから nil を返します。
+// 書き込みロック（Lock）を取得した状態で呼び出します。
+func (d *DB) lookupKeyWrite(key string) *Object {
+	d.expireIfNeededLocked(key)
+	o, ok := d.dict[key]
+	if !ok {
+		return nil
+	}
+	o.touch()
+	return o
+}
+
+// setKey: キーに値を設定します。既存の値は型に関係なく上書きされます。
+// keepTTL が false の場合は、既存の有効期限を取り消します（SET コマンドと同じ動作です）。
+// 書き込みロックを取得した状態で呼び出します。
+func (d *DB) setKey(key string, o *Object, keepTTL bool) {
+	d.dict[key] = o
+	if !keepTTL {
+		delete(d.expires, key)
+	}
+}
+
+// deleteKey: キーとその有効期限を削除します。削除した場合は true を返します。
+// 書き込みロックを取得した状態で呼び出します。
+func (d *DB) deleteKey(key string) bool {
+	if _, ok := d.dict[key]; !ok {
+		return false
+	}
+	delete(d.dict, key)
+	delete(d.expires, key)
+	return true
+}
+
+// setExpire: キーの有効期限（Unix時間のミリ秒）を設定します。過去の時刻が指定された場合は、その場でキーを削除します。
+// ただし、AOFの読み込み中は過去の時刻でもそのまま設定します（削除は、読み込み後に遅延削除か定期削除が行います）。
+// 書き込みロックを取得した状態で呼び出します。
+func (d *DB) setExpire(key string, when int64) {
+	if when <= mstime() && !d.loading {
+		d.deleteKey(key)
+		return
+	}
+	d.expires[key] = when
+}
+
+// isExpired: キーの有効期限が切れているかを判定します。ロックを取得した状態で呼び出します。
+//
+// AOFの読み込み中は、常に false を返します。AOFには、コマンドを実行した時点の状態が記録されています。
+// 読み込み中に（再生している時刻で）期限切れとみなすと、たとえば「SET counter 5 PX 1500」の後の
+// 「INCR counter」が、期限切れで消えたキーへの INCR として 1 を作り直してしまい、期限のないキーが復活します。
+// 期限切れのキーの削除は、遅延削除と定期削除が DEL としてAOFに記録するので、その DEL を再生すれば消えます。
+func (d *DB) isExpired(key string) bool {
+	if d.loading {
+		return false
+	}
+	when, ok := d.expires[key]
+	return ok && when <= mstime()
+}
+
+// expireIfNeededLocked: キーの有効期限が切れていれば削除し、true を返します（遅延削除）。
+// 削除したキーは、DEL としてAOFに記録します（propagateExpire を参照）。
+// callMu と書き込みロックを取得した状態で呼び出します（書き込みコマンドの実行中は、call が callMu を取得しています）。
+func (d *DB) expireIfNeededLocked(key string) bool {
+	if !d.isExpired(key) {
+		return false
+	}
+	d.deleteKey(key)
+	propagateExpire(key)
+	return true
+}
+
+// expireIfNeeded: expireIfNeededLocked と同じですが、ロックを自分で取得します。
+// 読み取りコマンドは、読み取りロックを取得する前にこの関数を呼び出して、期限切れのキーを削除します。
+// 期限が切れていない大半の場合は読み取りロックだけで済むよう、先に RLock で確認します。
+// 削除する場合は、書き込みコマンドと同じく callMu を取得してから削除し、DEL をAOFに記録します（DB.lockExpire を参照）。
+func (d *DB) expireIfNeeded(keys ...string) {
+	d.mu.RLock()
+	expired := false
+	for _, key := range keys {
+		if d.isExpired(key) {
+			expired = true
+			break
+		}
+	}
+	d.mu.RUnlock()
+	if !expired {
+		return
+	}
+
+	unlock := d.lockExpire()
+	for _, key := range keys {
+		d.expireIfNeededLocked(key)
+	}
+	unlock()
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ====================================================================
+// キーの有効期限（TTL）
+// ====================================================================
+//
+// 有効期限は「期限が切れる時刻（Unix時間のミリ秒）」として、DB の expires に保存します（db.go を参照）。
+// 期限切れのキーは、次の2つの方法で削除されます（Redisと同じ仕組みです）。
+//   - 遅延削除: コマンドがキーにアクセスしたときに期限を確認し、切れていれば削除する
+//   - 定期削除: バックグラウンドで定期的に有効期限付きのキーをサンプリングし、切れているものを削除する
+//     （一度もアクセスされないキーがメモリに残り続けないようにするため）
+//
+// どちらの方法で削除した場合も、削除したキーを DEL としてAOFに記録します（propagateExpire を参照）。
+// AOFの読み込み中は期限切れの判定をしないので（DB.isExpired を参照）、再生した結果は、
+// 記録した時点の状態（と、記録した DEL）だけで決まり、再生する時刻には左右されません。
+
+// mstime: 現在時刻をUnix時間のミリ秒で返します。
+func mstime() int64 {
+	return time.Now().UnixMilli()
+}
+
+// ====================================================================
+// 定期削除（active expire cycle）
+// ====================================================================
+
+const (
+	activeExpireInterval     = 100 * time.Millisecond // 定期削除を実行する間隔（Redisの hz 10 に相当）
+	activeExpireKeysPerLoop  = 20                     // 1回のサンプリングで調べるキーの数
+	activeExpireCycleTimeout = 25 * time.Millisecond  // 1回の定期削除にかける時間の上限
+)
+
+// startActiveExpireCycle: 定期削除を行うゴルーチンを開始します。
+// 戻り値の関数を呼ぶと、ゴルーチンを停止して終了するまで待ちます。
+func startActiveExpireCycle() (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(activeExpireInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				activeExpireCycle()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// activeExpireCycle: 有効期限付きのキーをランダムにサンプリングし、期限切れのものを削除します。
+// Redisの activeExpireCycle と同じく、サンプルのうち25%より多くが期限切れだった場合は
+// まだ多くの期限切れキーが残っていると判断して、時間の上限まで繰り返します。
+func activeExpireCycle() {
+	start := time.Now()
+
+	for {
+		sampled, expired := 0, 0
+
+		unlock := db.lockExpire()
+		now := mstime()
+		// Go のマップの range は毎回ランダムな位置から始まるため、先頭から数個を取り出すだけで
+		// ランダムなサンプリングになります。
+		for key, when := range db.expires {
+			if sampled >= activeExpireKeysPerLoop {
+				break
+			}
+			sampled++
+			if when <= now {
+				db.deleteKey(key)
+				propagateExpire(key)
+				expired++
+			}
+		}
+		unlock()
+
+		// 期限切れの割合が25%以下になったか、時間の上限に達したら終了します。
+		if expired*4 <= sampled || time.Since(start) > activeExpireCycleTimeout {
+			return
+		}
+	}
+}
+
+// ====================================================================
+// 期限切れのキーの削除のAOFへの記録
+// ====================================================================
+
+// propagateExpire: 期限切れで削除したキーを、DEL key としてAOFに記録します（Redisの propagateDeletion に相当します）。
+// 記録した DEL は、実行中のコマンド自体より前にAOFに書き込まれます（Server.flushPropagated を参照）。
+// callMu と書き込みロックを取得した状態で呼び出します。AOFの再生中や appendonly no の場合は何もしません。
+func propagateExpire(key string) {
+	if server == nil || server.aof == nil {
+		return
+	}
+	server.expired = append(server.expired, commandValue([]string{"DEL", key}))
+}
+
+// lockExpire: 書き込みコマンドの外で期限切れのキーを削除するために、callMu と書き込みロックをこの順に取得します。
+// 戻り値の関数を呼ぶと、削除したキーの DEL をAOFに書き込んでから、ロックを解放します。
+// 書き込みコマンドと同じく callMu の中で削除と記録を行うので、DEL はAOFの正しい位置（削除した時点）に記録されます。
+// 読み取りコマンドの遅延削除（expireIfNeeded）と、定期削除が使います。書き込みコマンドの実行中に呼び出してはいけません。
+func (d *DB) lockExpire() (unlock func()) {
+	s := server
+	if s != nil {
+		s.callMu.Lock()
+	}
+	d.mu.Lock()
+	return func() {
+		d.mu.Unlock()
+		if s != nil {
+			s.flushPropagated()
+			s.callMu.Unlock()
+		}
+	}
+}
+
+// ====================================================================
+// EXPIRE / PEXPIRE / EXPIREAT / PEXPIREAT コマンド
+// ====================================================================
+
+// expire: EXPIRE key seconds [NX|XX|GT|LT]
+func expire(c *Client, args []Value) Value {
+	return expireGeneric(args, mstime(), 1000, "expire")
+}
+
+// pexpire: PEXPIRE key milliseconds [NX|XX|GT|LT]
+func pexpire(c *Client, args []Value) Value {
+	return expireGeneric(args, mstime(), 1, "pexpire")
+}
+
+// expireat: EXPIREAT key unix-time-seconds [NX|XX|GT|LT]
+func expireat(c *Client, args []Value) Value {
+	return expireGeneric(args, 0, 1000, "expireat")
+}
+
+// pexpireat: PEXPIREAT key unix-time-milliseconds [NX|XX|GT|LT]
+func pexpireat(c *Client, args []Value) Value {
+	return expireGeneric(args, 0, 1, "pexpireat")
+}
+
+// expireGeneric: EXPIRE 系コマンドの共通処理です。
+// 有効期限は basetime + 引数 * unit（ミリ秒）として計算します。
+// 相対時間のコマンド（EXPIRE, PEXPIRE）は basetime に現在時刻を、絶対時刻のコマンドは 0 を渡します。
+// 有効期限を設定した場合は 1、キーが存在しないか条件（NX/XX/GT/LT）を満たさない場合は 0 を返します。
+func expireGeneric(args []Value, basetime int64, unit int64, name string) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	key := args[0].bulk
+	when, errValue := parseExpireTime(args[1].bulk, basetime, unit, name)
+	if errValue != nil {
+		return *errValue
+	}
+	cond, errValue := parseExpireCondition(args[2:])
+	if errValue != nil {
+		return *errValue
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.lookupKeyWrite(key) == nil || !checkExpireCondition(key, when, cond) {
+		return Value{typ: "integer", num: 0}
+	}
+
+	// 過去の時刻が指定された場合は、setExpire がその場でキーを削除します。
+	db.setExpire(key, when)
+	db.dirty++
+	return Value{typ: "integer", num: 1}
+}
+
+// parseExpireTime: 有効期限の引数を解析し、期限のUnix時間（ミリ秒）を返します。
+// 整数でない場合や、計算結果が64ビット整数に収まらない場合はエラー応答を返します。
+func parseExpireTime(s string, basetime int64, unit int64, name string) (int64, *Value) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, &Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	invalid := &Value{typ: "error", str: "ERR invalid expire time in '" + name + "' command"}
+	if n > math.MaxInt64/unit || n < math.MinInt64/unit {
+		return 0, invalid
+	}
+	n *= unit
+	if (n > 0 && basetime > math.MaxInt64-n) || (n < 0 && basetime < math.MinInt64-n) {
+		return 0, invalid
+	}
+	return basetime + n, nil
+}
+
+// parseExpireCondition: EXPIRE 系コマンドのオプション（NX, XX, GT, LT）を解析します。
+//   - NX: 有効期限が設定されていない場合のみ設定する
+//   - XX: 有効期限が設定されている場合のみ設定する
+//   - GT: 新しい有効期限が現在より長い場合のみ設定する（有効期限なしは無限とみなす）
+//   - LT: 新しい有効期限が現在より短い場合のみ設定する
+func parseExpireCondition(opts []Value) (string, *Value) {
+	nx, xx, gt, lt := false, false, false, false
+	for _, opt := range opts {
+		switch strings.ToUpper(opt.bulk) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			return "", &Value{typ: "error", str: "ERR Unsupported option " + opt.bulk}
+		}
+	}
+
+	if nx && (xx || gt || lt) {
+		return "", &Value{typ: "error", str: "ERR NX and XX, GT or LT options at the same time are not compatible"}
+	}
+	if gt && lt {
+		return "", &Value{typ: "error", str: "ERR GT and LT options at the same time are not compatible"}
+	}
+
+	switch {
+	case nx:
+		return "NX", nil
+	case xx && gt:
+		return "XXGT", nil
+	case xx && lt:
+		return "XXLT", nil
+	case xx:
+		return "XX", nil
+	case gt:
+		return "GT", nil
+	case lt:
+		return "LT", nil
+	}
+	return "", nil
+}
+
+// checkExpireCondition: オプションで指定された条件を満たしているかを判定します。ロックを取得した状態で呼び出します。
+func checkExpireCondition(key string, when int64, cond string) bool {
+	current, hasTTL := db.expires[key]
+
+	if cond == "NX" && hasTTL {
+		return false
+	}
+	if strings.HasPrefix(cond, "XX") && !hasTTL {
+		return false
+	}
+	// 有効期限なしは「無限に長い」とみなすので、GT は常に失敗し、LT は常に成功します。
+	if strings.HasSuffix(cond, "GT") && (!hasTTL || when <= current) {
+		return false
+	}
+	if strings.HasSuffix(cond, "LT") && hasTTL && when >= current {
+		return false
+	}
+	return true
+}
+
+// ====================================================================
+// TTL / PTTL / EXPIRETIME / PEXPIRETIME / PERSIST コマンド
+// ====================================================================
+
+// ttl: TTL key
+// 残りの有効期限を秒で返します。キーが存在しない場合は -2、有効期限がない場合は -1 を返します。
+func ttl(c *Client, args []Value) Value {
+	return ttlGeneric(args, "ttl", false, false)
+}
+
+// pttl: PTTL key
+// 残りの有効期限をミリ秒で返します。
+func pttl(c *Client, args []Value) Value {
+	return ttlGeneric(args, "pttl", true, false)
+}
+
+// expiretime: EXPIRETIME key
+// 有効期限の絶対時刻（Unix時間の秒）を返します。
+func expiretime(c *Client, args []Value) Value {
+	return ttlGeneric(args, "expiretime", false, true)
+}
+
+// pexpiretime: PEXPIRETIME key
+// 有効期限の絶対時刻（Unix時間のミリ秒）を返します。
+func pexpiretime(c *Client, args []Value) Value {
+	return ttlGeneric(args, "pexpiretime", true, true)
+}
+
+// ttlGeneric: TTL 系コマンドの共通処理です。
+// msec が true ならミリ秒、false なら秒で返し、absolute が true なら残り時間ではなく絶対時刻を返します。
+func ttlGeneric(args []Value, name string, msec bool, absolute bool) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	key := args[0].bulk
+	db.expireIfNeeded(key)
+
+	db.mu.RLock()
+	o := db.lookupKeyRead(key)
+	when, hasTTL := db.expires[key]
+	db.mu.RUnlock()
+
+	// キーが存在しない場合
+	if o == nil {
+		return Value{typ: "integer", num: -2}
+	}
+	// 有効期限がない場合
+	if !hasTTL {
+		return Value{typ: "integer", num: -1}
+	}
+
+	// 絶対時刻を返す場合
+	if absolute {
+		if msec {
+			return Value{typ: "integer", num: int(when)}
+		}
+		return Value{typ: "integer", num: int(when / 1000)}
+	}
+
+	// 残り時間を返す場合（秒の場合は四捨五入します）
+	remaining := when - mstime()
+	if remaining < 0 {
+		remaining = 0
+	}
+	if msec {
+		return Value{typ: "integer", num: int(remaining)}
+	}
+	return Value{typ: "integer", num: int((remaining + 500) / 1000)}
+}
+
+// persist: PERSIST key
+// キーの有効期限を取り除きます。取り除いた場合は 1、キーが存在しないか有効期限がない場合は 0 を返します。
+func persist(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'persist' command"}
+	}
+
+	key := args[0].bulk
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.lookupKeyWrite(key) == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	if _, ok := db.expires[key]; !ok {
+		return Value{typ: "integer", num: 0}
+	}
+	delete(db.expires, key)
+	db.dirty++
+	return Value{typ: "integer", num: 1}
+}
+
+// ====================================================================
+// AOF 用のコマンドの書き換え
+// ====================================================================
+
+// rewriteExpireCommand: 相対時間で有効期限を指定するコマンドを、絶対時刻で指定するコマンドに書き換えます。
+//   - EXPIRE / PEXPIRE / EXPIREAT key n [opts] → PEXPIREAT key <絶対時刻ミリ秒> [opts]
+//   - SET key value EX / PX / EXAT n          → SET key value PXAT <絶対時刻ミリ秒>
+//   - GETEX key EX / PX / EXAT n              → GETEX key PXAT <絶対時刻ミリ秒>
+//
+// 書き換えたコマンドを実行し、そのままAOFに記録することで、サーバーを再起動してAOFを再生したときも
+// 「再生した時刻から n 秒後」ではなく、元と同じ時刻に期限が切れるようになります。
+// 引数が不正な場合は書き換えず、元のコマンドのエラーメッセージがそのまま返るようにします。
+// 書き換えた場合は、新しいコマンド名と引数、true を返します。
+func rewriteExpireCommand(command string, args []Value) (string, []Value, bool) {
+	switch command {
+	case "EXPIRE", "PEXPIRE", "EXPIREAT":
+		if len(args) < 2 {
+			return command, args, false
+		}
+		basetime, unit := int64(0), int64(1000)
+		if command == "EXPIRE" || command == "PEXPIRE" {
+			basetime = mstime()
+		}
+		if command == "PEXPIRE" {
+			unit = 1
+		}
+		when, errValue := parseExpireTime(args[1].bulk, basetime, unit, strings.ToLower(command))
+		if errValue != nil {
+			return command, args, false
+		}
+
+		newArgs := append([]Value{args[0], {typ: "bulk", bulk: strconv.FormatInt(when, 10)}}, args[2:]...)
+		return "PEXPIREAT", newArgs, true
+
+	case "SET", "GETEX":
+		// オプションが始まる位置です（SET key value [オプション] と GETEX key [オプション]）。
+		start := 2
+		if command == "GETEX" {
+			start = 1
+		}
+		opts, errValue := parseStringOptions(args, start, strings.ToLower(command))
+		if errValue != nil || opts.expireAt == 0 || opts.expireOption == "PXAT" {
+			return command, args, false
+		}
+
+		// 有効期限のオプション（EX 10 など）だけを PXAT <絶対時刻> に置き換え、それ以外の引数はそのまま残します。
+		newArgs := make([]Value, 0, len(args))
+		for i := 0; i < len(args); i++ {
+			if i >= start && strings.EqualFold(args[i].bulk, opts.expireOption) {
+				newArgs = append(newArgs,
+					Value{typ: "bulk", bulk: "PXAT"},
+					Value{typ: "bulk", bulk: strconv.FormatInt(opts.expireAt, 10)})
+				i++ // オプションの値を読み飛ばします
+				continue
+			}
+			newArgs = append(newArgs, args[i])
+		}
+		return command, newArgs, true
+	}
+
+	return command, args, false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// runCommand: テスト用に、コマンドを call を通して（AOFに記録しながら）実行します。
+// handleClient と同じく、相対時間の有効期限は絶対時刻に書き換えてから実行します。
+func runCommand(t *testing.T, args ...string) Value {
+	t.Helper()
+	command := strings.ToUpper(args[0])
+	argv := commandValue(args).array
+	if newCommand, newArgs, ok := rewriteExpireCommand(command, argv[1:]); ok {
+		command = newCommand
+		argv = append([]Value{{typ: "bulk", bulk: newCommand}}, newArgs...)
+	}
+	cmd, ok := commandTable[command]
+	if !ok {
+		t.Fatalf("unknown command %q", args[0])
+	}
+	c := &Client{authenticated: true, proto: 2}
+	return server.call(c, cmd, argv)
+}
+
+// startTestServer: dir のAOFを読み込んでから、ネットワーク接続を持たないサーバーを用意します（main と同じ順序です）。
+// 戻り値の関数はAOFを閉じます。再起動は、これを呼んでからもう一度 startTestServer を呼び出して再現します。
+func startTestServer(t *testing.T, dir string) (stop func()) {
+	t.Helper()
+	db = NewDB()
+	server = nil
+
+	aof, err := NewAof(dir, "appendonly.aof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadAof(aof); err != nil {
+		aof.Close()
+		t.Fatal(err)
+	}
+	server = NewServer(nil, aof)
+	return func() {
+		aof.Close()
+		db = NewDB()
+		server = nil
+	}
+}
+
+// 期限切れのキーが、再起動（AOFの再生）で期限のないキーとして復活しないことを確認します。
+func TestExpiredKeysAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	stop := startTestServer(t, dir)
+
+	// counter: 期限が切れた後に一度もアクセスしないキー。
+	// 再生する時刻には SET の期限が過ぎていますが、INCR は期限内に実行されたので、1 を作り直してはいけません。
+	runCommand(t, "SET", "counter", "5", "PX", "100")
+	runCommand(t, "INCR", "counter")
+
+	// recreated: 期限が切れた後に INCR で作り直したキー。
+	// 遅延削除の DEL が INCR より前に記録されていれば、再生後も期限のない 1 になります。
+	runCommand(t, "SET", "recreated", "5", "PX", "100")
+	time.Sleep(200 * time.Millisecond)
+	if v := runCommand(t, "INCR", "recreated"); v.num != 1 {
+		t.Fatalf("INCR recreated = %d, want 1", v.num)
+	}
+	stop()
+
+	stop = startTestServer(t, dir)
+	defer stop()
+
+	if v := runCommand(t, "GET", "counter"); v.typ != "null" {
+		t.Errorf("GET counter = %+v, want null", v)
+	}
+	if v := runCommand(t, "GET", "recreated"); v.bulk != "1" {
+		t.Errorf("GET recreated = %+v, want 1", v)
+	}
+	if v := runCommand(t, "PTTL", "recreated"); v.num != -1 {
+		t.Errorf("PTTL recreated = %d, want -1", v.num)
+	}
+}
@@ -1,3 +1,3 @@
-module main
+module github.com/pochy/learn-redis-internals-go
 
 go 1.25.0
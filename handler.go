@@ -1,42 +1,169 @@
 package main
 
 import (
-	"sync" // 並行処理（複数のリクエストを同時に処理）のための排他制御（Mutex）を提供します。
+	"fmt"     // エラーメッセージの組み立てに使います。
+	"strconv" // 文字列を数値に変換するためのパッケージです。
+	"strings" // 文字列操作（オプション名を大文字に変換するなど）のためのパッケージです。
 )
 
 // ====================================================================
-// インメモリデータストア
+// コマンドハンドラーの定義
 // ====================================================================
 
-// SET/GET コマンド用のデータストア: キーと値のシンプルなマップ（Goのハッシュマップ）です。
-// RedisのString型を模倣しています。
-var SETs = map[string]string{}
-
-// SETsマップへの同時アクセスを防ぐためのRWMutex（読み書きロック）です。
-// 読み取り（RLock）は並行して行えますが、書き込み（Lock）は排他的に行われます。
-var SETsMu = sync.RWMutex{}
+// redisCommand構造体: コマンドテーブルの1つのコマンドです（Redisの redisCommand に相当します）。
+type redisCommand struct {
+	// proc: コマンドの処理関数です。コマンドを送ってきたクライアント（c）と引数（args）を受け取ります。
+	// AUTH のように接続ごとの状態を変更するコマンドは c を使います。
+	proc func(c *Client, args []Value) Value
 
-// HSET/HGET コマンド用のデータストア: ハッシュ名 -> キーと値のマップ、という二重構造です。
-// RedisのHash型を模倣しています。
-var HSETs = map[string]map[string]string{}
-
-// HSETsマップへの同時アクセスを防ぐためのRWMutexです。
-var HSETsMu = sync.RWMutex{}
+	flags int // コマンドの性質（cmdWrite, cmdReadonly）
+}
 
-// ====================================================================
-// コマンドハンドラーの定義
-// ====================================================================
+// コマンドのフラグです。どちらも付かないコマンド（PING、CONFIG など）は、キースペースに触れません。
+const (
+	// cmdWrite: データを変更することがあるコマンドです。
+	// 実行してデータを実際に変更した場合だけ、AOFに記録します（server.go の call を参照）。
+	cmdWrite = 1 << iota
+	// cmdReadonly: データを読み取るだけのコマンドです。AOFには記録しません。
+	cmdReadonly
+)
 
-// Handlers マップ: コマンド名（大文字の文字列）を、対応する処理関数にマッピングします。
-// 例: "PING" -> ping 関数
-var Handlers = map[string]func([]Value) Value{
-	"PING": ping,
-	"SET":  set,
-	"GET":  get,
-	"HSET": hset,
-	"HGET": hget,
-	// "HGETALL" は記事で定義されていませんが、マップには含められています。
-	// "HGETALL": hgetall,
+// commandTable: コマンド名（大文字の文字列）を、対応するコマンドにマッピングします。
+// 例: "PING" -> ping 関数（フラグなし）、"SET" -> set 関数（書き込みコマンド）
+var commandTable = map[string]*redisCommand{
+	"AUTH":        {auth, 0},
+	"HELLO":       {hello, 0},
+	"PING":        {ping, 0},
+	"SET":         {set, cmdWrite},
+	"GET":         {get, cmdReadonly},
+	"MSET":        {mset, cmdWrite},
+	"MSETNX":      {msetnx, cmdWrite},
+	"MGET":        {mget, cmdReadonly},
+	"GETSET":      {getset, cmdWrite},
+	"GETDEL":      {getdel, cmdWrite},
+	"GETEX":       {getex, cmdWrite},
+	"INCR":        {incr, cmdWrite},
+	"DECR":        {decr, cmdWrite},
+	"INCRBY":      {incrby, cmdWrite},
+	"DECRBY":      {decrby, cmdWrite},
+	"INCRBYFLOAT": {incrbyfloat, cmdWrite},
+	"APPEND":      {appendCommand, cmdWrite},
+	"STRLEN":      {strlen, cmdReadonly},
+	"GETRANGE":    {getrange, cmdReadonly},
+	"SETRANGE":    {setrange, cmdWrite},
+
+	"HSET":         {hset, cmdWrite},
+	"HMSET":        {hmset, cmdWrite},
+	"HSETNX":       {hsetnx, cmdWrite},
+	"HGET":         {hget, cmdReadonly},
+	"HMGET":        {hmget, cmdReadonly},
+	"HDEL":         {hdel, cmdWrite},
+	"HEXISTS":      {hexists, cmdReadonly},
+	"HLEN":         {hlen, cmdReadonly},
+	"HSTRLEN":      {hstrlen, cmdReadonly},
+	"HGETALL":      {hgetall, cmdReadonly},
+	"HKEYS":        {hkeys, cmdReadonly},
+	"HVALS":        {hvals, cmdReadonly},
+	"HINCRBY":      {hincrby, cmdWrite},
+	"HINCRBYFLOAT": {hincrbyfloat, cmdWrite},
+	"HSCAN":        {hscan, cmdReadonly},
+	"HRANDFIELD":   {hrandfield, cmdReadonly},
+
+	"LPUSH":      {lpush, cmdWrite},
+	"RPUSH":      {rpush, cmdWrite},
+	"LPOP":       {lpop, cmdWrite},
+	"RPOP":       {rpop, cmdWrite},
+	"LLEN":       {llen, cmdReadonly},
+	"LINDEX":     {lindex, cmdReadonly},
+	"LRANGE":     {lrange, cmdReadonly},
+	"LSET":       {lset, cmdWrite},
+	"LINSERT":    {linsert, cmdWrite},
+	"LREM":       {lrem, cmdWrite},
+	"LTRIM":      {ltrim, cmdWrite},
+	"LPOS":       {lpos, cmdReadonly},
+	"LMOVE":      {lmove, cmdWrite},
+	"RPOPLPUSH":  {rpoplpush, cmdWrite},
+	"BLPOP":      {blpop, cmdWrite},
+	"BRPOP":      {brpop, cmdWrite},
+	"BLMOVE":     {blmove, cmdWrite},
+	"BRPOPLPUSH": {brpoplpush, cmdWrite},
+
+	"SADD":        {sadd, cmdWrite},
+	"SREM":        {srem, cmdWrite},
+	"SMEMBERS":    {smembers, cmdReadonly},
+	"SISMEMBER":   {sismember, cmdReadonly},
+	"SMISMEMBER":  {smismember, cmdReadonly},
+	"SCARD":       {scard, cmdReadonly},
+	"SPOP":        {spop, cmdWrite},
+	"SRANDMEMBER": {srandmember, cmdReadonly},
+	"SMOVE":       {smove, cmdWrite},
+	"SINTER":      {sinter, cmdReadonly},
+	"SUNION":      {sunion, cmdReadonly},
+	"SDIFF":       {sdiff, cmdReadonly},
+	"SINTERSTORE": {sinterstore, cmdWrite},
+	"SUNIONSTORE": {sunionstore, cmdWrite},
+	"SDIFFSTORE":  {sdiffstore, cmdWrite},
+	"SINTERCARD":  {sintercard, cmdReadonly},
+	"SSCAN":       {sscan, cmdReadonly},
+
+	"ZADD":        {zadd, cmdWrite},
+	"ZINCRBY":     {zincrby, cmdWrite},
+	"ZREM":        {zrem, cmdWrite},
+	"ZCARD":       {zcard, cmdReadonly},
+	"ZSCORE":      {zscore, cmdReadonly},
+	"ZRANK":       {zrank, cmdReadonly},
+	"ZREVRANK":    {zrevrank, cmdReadonly},
+	"ZRANGE":      {zrange, cmdReadonly},
+	"ZCOUNT":      {zcount, cmdReadonly},
+	"ZLEXCOUNT":   {zlexcount, cmdReadonly},
+	"ZPOPMIN":     {zpopmin, cmdWrite},
+	"ZPOPMAX":     {zpopmax, cmdWrite},
+	"BZPOPMIN":    {bzpopmin, cmdWrite},
+	"BZPOPMAX":    {bzpopmax, cmdWrite},
+	"ZUNIONSTORE": {zunionstore, cmdWrite},
+	"ZINTERSTORE": {zinterstore, cmdWrite},
+	"ZRANDMEMBER": {zrandmember, cmdReadonly},
+
+	"XADD":       {xadd, cmdWrite},
+	"XRANGE":     {xrange, cmdReadonly},
+	"XREVRANGE":  {xrevrange, cmdReadonly},
+	"XLEN":       {xlen, cmdReadonly},
+	"XDEL":       {xdel, cmdWrite},
+	"XTRIM":      {xtrim, cmdWrite},
+	"XSETID":     {xsetid, cmdWrite},
+	"XREAD":      {xread, cmdReadonly},
+	"XINFO":      {xinfo, cmdReadonly},
+	"XGROUP":     {xgroup, cmdWrite},
+	"XREADGROUP": {xreadgroup, cmdWrite},
+	"XACK":       {xack, cmdWrite},
+	"XPENDING":   {xpending, cmdReadonly},
+	"XCLAIM":     {xclaim, cmdWrite},
+	"XAUTOCLAIM": {xautoclaim, cmdWrite},
+
+	"DEL":       {del, cmdWrite},
+	"UNLINK":    {unlink, cmdWrite},
+	"EXISTS":    {exists, cmdReadonly},
+	"TYPE":      {typeCommand, cmdReadonly},
+	"RENAME":    {rename, cmdWrite},
+	"RENAMENX":  {renamenx, cmdWrite},
+	"COPY":      {copyCommand, cmdWrite},
+	"RANDOMKEY": {randomkey, cmdReadonly},
+	"TOUCH":     {touch, cmdReadonly},
+
+	"EXPIRE":      {expire, cmdWrite},
+	"PEXPIRE":     {pexpire, cmdWrite},
+	"EXPIREAT":    {expireat, cmdWrite},
+	"PEXPIREAT":   {pexpireat, cmdWrite},
+	"TTL":         {ttl, cmdReadonly},
+	"PTTL":        {pttl, cmdReadonly},
+	"EXPIRETIME":  {expiretime, cmdReadonly},
+	"PEXPIRETIME": {pexpiretime, cmdReadonly},
+	"PERSIST":     {persist, cmdWrite},
+
+	"OBJECT":       {objectCommand, cmdReadonly},
+	"CONFIG":       {configCommand, 0},
+	"SHUTDOWN":     {shutdown, 0},
+	"BGREWRITEAOF": {bgrewriteaof, 0},
 }
 
 // ------------------------------
@@ -44,7 +171,7 @@ var Handlers = map[string]func([]Value) Value{
 // ------------------------------
 
 // ping コマンドの処理関数です。引数（args）の有無によって応答を変えます。
-func ping(args []Value) Value {
+func ping(c *Client, args []Value) Value {
 	// 引数が提供されていない場合 (例: PING)
 	if len(args) == 0 {
 		// Simple String の "PONG" を返します。
@@ -56,126 +183,152 @@ func ping(args []Value) Value {
 }
 
 // ------------------------------
-// SET コマンド
+// AUTH コマンド
 // ------------------------------
 
-// set コマンドの処理関数です。キーと値をデータストアに保存します。
-func set(args []Value) Value {
-	// 引数の数（キーと値の2つ）が正しいか検証します。
-	if len(args) != 2 {
-		// 間違っている場合、RESP Errorを返します。
-		return Value{typ: "error", str: "ERR wrong number of arguments for 'set' command"}
+// auth コマンドの処理関数です。requirepass に設定されたパスワードでクライアントを認証します。
+// AUTH password
+// AUTH username password （ユーザーは "default" のみサポートしています）
+func auth(c *Client, args []Value) Value {
+	if len(args) != 1 && len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'auth' command"}
 	}
 
-	key := args[0].bulk   // 最初の引数をキーとして取得
-	value := args[1].bulk // 2番目の引数を値として取得
+	requirePass := config.getRequirePass()
 
-	// 書き込み操作なので、排他制御のためにロックを取得します。
-	SETsMu.Lock()
-	// SETsマップにキーと値を保存します。
-	SETs[key] = value
-	// 処理が完了したらロックを解放します。
-	SETsMu.Unlock()
-
-	// 成功応答として Simple String の "OK" を返します。
-	return Value{typ: "string", str: "OK"}
-}
-
-// ------------------------------
-// GET コマンド
-// ------------------------------
+	// パスワードが設定されていないのに AUTH password が送られてきた場合は、設定ミスの可能性を知らせます。
+	if requirePass == "" && len(args) == 1 {
+		return Value{typ: "error", str: "ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"}
+	}
 
-// get コマンドの処理関数です。指定されたキーの値を取得します。
-func get(args []Value) Value {
-	// 引数の数（キーの1つ）が正しいか検証します。
-	if len(args) != 1 {
-		return Value{typ: "error", str: "ERR wrong number of arguments for 'get' command"}
+	username, password := "default", args[0].bulk
+	if len(args) == 2 {
+		username, password = args[0].bulk, args[1].bulk
 	}
 
-	key := args[0].bulk // キーを取得
+	if !checkPassword(username, password) {
+		return Value{typ: "error", str: "WRONGPASS invalid username-password pair or user is disabled."}
+	}
 
-	// 読み取り操作なので、読み取りロックを取得します。
-	SETsMu.RLock()
-	// マップから値を取得します。値と、キーが存在したかどうかのフラグ（ok）を受け取ります。
-	value, ok := SETs[key]
-	// 処理が完了したら読み取りロックを解放します。
-	SETsMu.RUnlock()
+	c.authenticated = true
+	return Value{typ: "string", str: "OK"}
+}
 
-	// キーが存在しなかった場合
-	if !ok {
-		// Null Bulk String 応答を返します（Redisの標準的な「値がない」という応答）。
-		return Value{typ: "null"}
+// checkPassword: ユーザー名とパスワードの組が正しいかを判定します。
+// ユーザーは "default" のみで、requirepass が設定されていなければどのパスワードでも認証に成功します。
+func checkPassword(username, password string) bool {
+	if username != "default" {
+		return false
 	}
-
-	// 値が存在した場合、その値を Bulk String として返します。
-	return Value{typ: "bulk", bulk: value}
+	requirePass := config.getRequirePass()
+	return requirePass == "" || password == requirePass
 }
 
 // ------------------------------
-// HSET コマンド
+// HELLO コマンド
 // ------------------------------
 
-// hset コマンドの処理関数です。指定されたハッシュ（外側のキー）に、フィールド（内側のキー）と値を保存します。
-func hset(args []Value) Value {
-	// 引数の数（ハッシュ名、キー、値の3つ）が正しいか検証します。
-	if len(args) != 3 {
-		return Value{typ: "error", str: "ERR wrong number of arguments for 'hset' command"}
+// このサーバーが互換性の目安としているRedisのバージョンです（HELLO の応答に含めます）。
+const redisVersion = "7.2.0"
+
+// hello コマンドの処理関数です。接続で使うRESPのバージョンを切り替え、サーバーの情報を返します。
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func hello(c *Client, args []Value) Value {
+	proto := c.proto
+
+	// 最初の引数はプロトコルのバージョン（2 または 3）です。
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(args[0].bulk)
+		if err != nil {
+			return Value{typ: "error", str: "ERR Protocol version is not an integer or out of range"}
+		}
+		if ver < 2 || ver > 3 {
+			return Value{typ: "error", str: "NOPROTO unsupported protocol version"}
+		}
+		proto = ver
+	}
+
+	// 残りの引数（AUTH と SETNAME）を解析します。
+	var username, password, clientName string
+	authRequested, setName := false, false
+	for i := 1; i < len(args); i++ {
+		moreArgs := len(args) - 1 - i
+		opt := strings.ToUpper(args[i].bulk)
+		switch {
+		case opt == "AUTH" && moreArgs >= 2:
+			authRequested = true
+			username, password = args[i+1].bulk, args[i+2].bulk
+			i += 2
+		case opt == "SETNAME" && moreArgs >= 1:
+			setName = true
+			clientName = args[i+1].bulk
+			i++
+		default:
+			return Value{typ: "error", str: fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i].bulk)}
+		}
 	}
 
-	hash := args[0].bulk  // ハッシュ名（外側のキー）
-	key := args[1].bulk   // フィールドキー（内側のキー）
-	value := args[2].bulk // 値
+	// AUTH が指定されていれば、AUTH コマンドと同じ方法で認証します。
+	if authRequested {
+		if !checkPassword(username, password) {
+			return Value{typ: "error", str: "WRONGPASS invalid username-password pair or user is disabled."}
+		}
+		c.authenticated = true
+	}
 
-	// 書き込み操作のためロックを取得します。
-	HSETsMu.Lock()
-	// ハッシュ名がまだ存在しない場合、新しい内部マップ（map[string]string{}）を作成します。
-	if _, ok := HSETs[hash]; !ok {
-		HSETs[hash] = map[string]string{}
+	// 認証が済んでいない場合は、プロトコルを切り替えずにエラーを返します。
+	if !c.authenticated {
+		return Value{typ: "error", str: "NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"}
 	}
-	// 指定されたハッシュの内部マップにキーと値を保存します。
-	HSETs[hash][key] = value
-	HSETsMu.Unlock()
 
-	// 成功応答として Simple String の "OK" を返します。
-	return Value{typ: "string", str: "OK"}
+	if setName {
+		// クライアント名に空白や改行は使えません（CLIENT SETNAME と同じ規則です）。
+		for _, ch := range clientName {
+			if ch < '!' || ch > '~' {
+				return Value{typ: "error", str: "ERR Client names cannot contain spaces, newlines or special characters."}
+			}
+		}
+		c.name = clientName
+	}
+
+	// ここでプロトコルを切り替えます。HELLO 自身の応答から新しいプロトコルで送られます。
+	c.proto = proto
+
+	// サーバーの情報をマップで返します（RESP2 のクライアントには配列として送られます）。
+	return Value{typ: "map", array: []Value{
+		{typ: "bulk", bulk: "server"}, {typ: "bulk", bulk: "redis"},
+		{typ: "bulk", bulk: "version"}, {typ: "bulk", bulk: redisVersion},
+		{typ: "bulk", bulk: "proto"}, {typ: "integer", num: c.proto},
+		{typ: "bulk", bulk: "id"}, {typ: "integer", num: int(c.id)},
+		{typ: "bulk", bulk: "mode"}, {typ: "bulk", bulk: "standalone"},
+		{typ: "bulk", bulk: "role"}, {typ: "bulk", bulk: "master"},
+		{typ: "bulk", bulk: "modules"}, {typ: "array", array: []Value{}},
+	}}
 }
 
 // ------------------------------
-// HGET コマンド
+// SHUTDOWN コマンド
 // ------------------------------
 
-// hget コマンドの処理関数です。指定されたハッシュからフィールドの値を取得します。
-func hget(args []Value) Value {
-	// 引数の数（ハッシュ名、キーの2つ）が正しいか検証します。
-	if len(args) != 2 {
-		return Value{typ: "error", str: "ERR wrong number of arguments for 'hget' command"}
+// shutdown コマンドの処理関数です。シグナル（SIGINT/SIGTERM）を受け取った場合と同じ停止処理を要求します。
+// SHUTDOWN [NOSAVE|SAVE]
+// このサーバーにはRDBスナップショットがないため、NOSAVE/SAVE のどちらを指定しても
+// Redisと同様にAOFはディスクに同期されてから閉じられます。
+func shutdown(c *Client, args []Value) Value {
+	if len(args) > 1 {
+		return Value{typ: "error", str: "ERR syntax error"}
 	}
-
-	hash := args[0].bulk // ハッシュ名
-	key := args[1].bulk  // フィールドキー
-
-	// 読み取り操作のため読み取りロックを取得します。
-	HSETsMu.RLock()
-	// 指定されたハッシュの内部マップから値を取得します。
-	value, ok := HSETs[hash][key]
-	HSETsMu.RUnlock()
-
-	// キーが存在しなかった場合（ハッシュ自体が存在しない場合も含む）
-	if !ok {
-		// Null Bulk String 応答を返します。
-		return Value{typ: "null"}
+	if len(args) == 1 {
+		switch strings.ToUpper(args[0].bulk) {
+		case "NOSAVE", "SAVE":
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
 	}
 
-	// 値が存在した場合、その値を Bulk String として返します。
-	return Value{typ: "bulk", bulk: value}
-}
+	server.RequestShutdown()
 
-// ------------------------------
-// HGETALL コマンド (未実装だがマップに登録されている)
-// ------------------------------
-// func hgetall(args []Value) Value {
-//     // HGETALLの処理ロジックは記事に記述されていません。
-//     // 実際には、指定されたハッシュのすべてのキーと値をRESP Arrayとして返す必要があります。
-//     return Value{typ: "error", str: "ERR HGETALL is not implemented yet"}
-// }
-// ※上記の hgetall の実装はコメントアウトされており、エラー応答を返すなどの処理が必要です。
+	// Redisと同様に、成功した場合は応答を返しません（クライアントからは接続が閉じられたように見えます）。
+	// 空の Value は Marshal すると0バイトになるため、何も送信されません。
+	return Value{}
+}
@@ -0,0 +1,640 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ====================================================================
+// ハッシュ型のコマンド
+// ====================================================================
+//
+// ハッシュの値は Object の value に map[string]string（フィールド -> 値）として保存されます（db.go を参照）。
+// Redisと同じく、空のハッシュは存在できません。最後のフィールドを削除したときは、キーごと削除します。
+
+// lookupHashRead: 読み取りのためにハッシュのキーを検索します。
+// キーが存在しない場合は nil、ハッシュ以外の型の場合は WRONGTYPE のエラー応答を返します。
+// 読み取りロックを取得した状態で呼び出します。
+func lookupHashRead(key string) (*Object, *Value) {
+	o := db.lookupKeyRead(key)
+	if o != nil && o.typ != ObjHash {
+		return nil, &wrongTypeError
+	}
+	return o, nil
+}
+
+// lookupHashWrite: 書き込みのためにハッシュのキーを検索します。
+// create が true でキーが存在しない場合は、空のハッシュを作成します。
+// 書き込みロックを取得した状態で呼び出します。
+func lookupHashWrite(key string, create bool) (*Object, *Value) {
+	o := db.lookupKeyWrite(key)
+	if o == nil {
+		if !create {
+			return nil, nil
+		}
+		o = newHashObject()
+		db.setKey(key, o, false)
+	} else if o.typ != ObjHash {
+		return nil, &wrongTypeError
+	}
+	return o, nil
+}
+
+// ------------------------------
+// HSET / HMSET / HSETNX コマンド
+// ------------------------------
+
+// hset コマンドの処理関数です。指定されたハッシュ（外側のキー）に、フィールド（内側のキー）と値を保存します。
+// HSET key field value [field value ...]
+// 新しく追加されたフィールドの数を返します（既存のフィールドの値を更新した場合は数えません）。
+func hset(c *Client, args []Value) Value {
+	// 引数の数（ハッシュ名と、フィールドと値の組が1つ以上）が正しいか検証します。
+	if len(args) < 3 || len(args)%2 != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hset' command"}
+	}
+
+	added, errValue := hsetGeneric(args)
+	if errValue != nil {
+		return *errValue
+	}
+	return Value{typ: "integer", num: added}
+}
+
+// hmset: HMSET key field value [field value ...]
+// HSET と同じですが、古いコマンドとの互換性のため "OK" を返します。
+func hmset(c *Client, args []Value) Value {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hmset' command"}
+	}
+
+	if _, errValue := hsetGeneric(args); errValue != nil {
+		return *errValue
+	}
+	return Value{typ: "string", str: "OK"}
+}
+
+// hsetGeneric: HSET と HMSET の共通処理です。新しく追加されたフィールドの数を返します。
+func hsetGeneric(args []Value) (int, *Value) {
+	hash := args[0].bulk // ハッシュ名（外側のキー）
+
+	// 書き込み操作のためロックを取得します。
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// ハッシュがまだ存在しない場合は、新しいハッシュを作成します。
+	// 同じ名前のキーが文字列などとして存在する場合は、型のエラーになります。
+	o, errValue := lookupHashWrite(hash, true)
+	if errValue != nil {
+		return 0, errValue
+	}
+
+	// 指定されたハッシュにフィールドと値を保存します。
+	fields := o.hash()
+	added := 0
+	for i := 1; i < len(args); i += 2 {
+		if _, ok := fields[args[i].bulk]; !ok {
+			added++
+		}
+		fields[args[i].bulk] = args[i+1].bulk
+		db.dirty++
+	}
+	return added, nil
+}
+
+// hsetnx: HSETNX key field value
+// フィールドが存在しない場合のみ値を保存します。保存した場合は 1、既に存在した場合は 0 を返します。
+func hsetnx(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hsetnx' command"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupHashWrite(args[0].bulk, true)
+	if errValue != nil {
+		return *errValue
+	}
+
+	fields := o.hash()
+	if _, ok := fields[args[1].bulk]; ok {
+		return Value{typ: "integer", num: 0}
+	}
+	fields[args[1].bulk] = args[2].bulk
+	db.dirty++
+	return Value{typ: "integer", num: 1}
+}
+
+// ------------------------------
+// HGET / HMGET コマンド
+// ------------------------------
+
+// hget コマンドの処理関数です。指定されたハッシュからフィールドの値を取得します。
+func hget(c *Client, args []Value) Value {
+	// 引数の数（ハッシュ名、キーの2つ）が正しいか検証します。
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hget' command"}
+	}
+
+	hash := args[0].bulk // ハッシュ名
+	key := args[1].bulk  // フィールドキー
+
+	// 有効期限が切れていれば、読み取る前に削除します（遅延削除）。
+	db.expireIfNeeded(hash)
+
+	// 読み取り操作のため読み取りロックを取得します。
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupHashRead(hash)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		// ハッシュ自体が存在しない場合は、Null Bulk String 応答を返します。
+		return Value{typ: "null"}
+	}
+
+	// 指定されたハッシュから値を取得します。
+	value, ok := o.hash()[key]
+
+	// フィールドが存在しなかった場合
+	if !ok {
+		// Null Bulk String 応答を返します。
+		return Value{typ: "null"}
+	}
+
+	// 値が存在した場合、その値を Bulk String として返します。
+	return Value{typ: "bulk", bulk: value}
+}
+
+// hmget: HMGET key field [field ...]
+// 複数のフィールドの値をまとめて返します。存在しないフィールドは null になります。
+func hmget(c *Client, args []Value) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hmget' command"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupHashRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+
+	values := make([]Value, 0, len(args)-1)
+	for _, field := range args[1:] {
+		if o == nil {
+			values = append(values, Value{typ: "null"})
+			continue
+		}
+		value, ok := o.hash()[field.bulk]
+		if !ok {
+			values = append(values, Value{typ: "null"})
+			continue
+		}
+		values = append(values, Value{typ: "bulk", bulk: value})
+	}
+	return Value{typ: "array", array: values}
+}
+
+// ------------------------------
+// HDEL コマンド
+// ------------------------------
+
+// hdel: HDEL key field [field ...]
+// フィールドを削除し、削除できたフィールドの数を返します。
+// 最後のフィールドを削除した場合は、ハッシュのキー自体も削除します。
+func hdel(c *Client, args []Value) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hdel' command"}
+	}
+
+	hash := args[0].bulk
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupHashWrite(hash, false)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+
+	fields := o.hash()
+	deleted := 0
+	for _, field := range args[1:] {
+		if _, ok := fields[field.bulk]; ok {
+			delete(fields, field.bulk)
+			deleted++
+			db.dirty++
+		}
+	}
+
+	// 空になったハッシュは削除します。
+	if len(fields) == 0 {
+		db.deleteKey(hash)
+	}
+	return Value{typ: "integer", num: deleted}
+}
+
+// ------------------------------
+// HEXISTS / HLEN / HSTRLEN コマンド
+// ------------------------------
+
+// hexists: HEXISTS key field
+// フィールドが存在すれば 1、存在しなければ 0 を返します。
+func hexists(c *Client, args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hexists' command"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupHashRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	if _, ok := o.hash()[args[1].bulk]; ok {
+		return Value{typ: "integer", num: 1}
+	}
+	return Value{typ: "integer", num: 0}
+}
+
+// hlen: HLEN key
+// フィールドの数を返します。
+func hlen(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hlen' command"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupHashRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	return Value{typ: "integer", num: len(o.hash())}
+}
+
+// hstrlen: HSTRLEN key field
+// フィールドの値の長さを返します。フィールドが存在しない場合は 0 です。
+func hstrlen(c *Client, args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hstrlen' command"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupHashRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	return Value{typ: "integer", num: len(o.hash()[args[1].bulk])}
+}
+
+// ------------------------------
+// HGETALL / HKEYS / HVALS コマンド
+// ------------------------------
+
+// hgetall コマンドの処理関数です。指定されたハッシュのすべてのフィールドと値を返します。
+// RESP3 のクライアントには Map（%）として、RESP2 のクライアントには
+// 「フィールド, 値, フィールド, 値, ...」という配列として送られます（変換は Writer が行います）。
+func hgetall(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hgetall' command"}
+	}
+	return hgetallGeneric(args[0].bulk, true, true)
+}
+
+// hkeys: HKEYS key
+// すべてのフィールドを配列で返します。
+func hkeys(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hkeys' command"}
+	}
+	return hgetallGeneric(args[0].bulk, true, false)
+}
+
+// hvals: HVALS key
+// すべての値を配列で返します。
+func hvals(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hvals' command"}
+	}
+	return hgetallGeneric(args[0].bulk, false, true)
+}
+
+// hgetallGeneric: HGETALL / HKEYS / HVALS の共通処理です。
+// withFields と withValues で、フィールドと値のどちらを（または両方を）返すかを指定します。
+func hgetallGeneric(hash string, withFields, withValues bool) Value {
+	// 有効期限が切れていれば、読み取る前に削除します（遅延削除）。
+	db.expireIfNeeded(hash)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// フィールドと値の両方を返す場合はマップ、片方だけの場合は配列として返します。
+	// ハッシュが存在しない場合は、空のマップ（空の配列）を返します。
+	values := Value{typ: "array", array: []Value{}}
+	if withFields && withValues {
+		values.typ = "map"
+	}
+
+	o, errValue := lookupHashRead(hash)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return values
+	}
+	for k, v := range o.hash() {
+		if withFields {
+			values.array = append(values.array, Value{typ: "bulk", bulk: k})
+		}
+		if withValues {
+			values.array = append(values.array, Value{typ: "bulk", bulk: v})
+		}
+	}
+
+	return values
+}
+
+// ------------------------------
+// HINCRBY / HINCRBYFLOAT コマンド
+// ------------------------------
+
+// hincrby: HINCRBY key field increment
+// フィールドの値に整数を足し、結果を返します。フィールドが存在しない場合は 0 とみなします。
+func hincrby(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hincrby' command"}
+	}
+
+	incr, ok := parseInteger(args[2].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 計算に失敗したときに空のハッシュが残らないよう、ハッシュの作成は最後に行います。
+	o, errValue := lookupHashWrite(args[0].bulk, false)
+	if errValue != nil {
+		return *errValue
+	}
+
+	var fields map[string]string
+	if o != nil {
+		fields = o.hash()
+	}
+	var value int64
+	if current, exists := fields[args[1].bulk]; exists {
+		if value, ok = parseInteger(current); !ok {
+			return Value{typ: "error", str: "ERR hash value is not an integer"}
+		}
+	}
+
+	if (incr < 0 && value < 0 && incr < math.MinInt64-value) ||
+		(incr > 0 && value > 0 && incr > math.MaxInt64-value) {
+		return Value{typ: "error", str: "ERR increment or decrement would overflow"}
+	}
+	value += incr
+
+	if o == nil {
+		o, _ = lookupHashWrite(args[0].bulk, true)
+		fields = o.hash()
+	}
+	fields[args[1].bulk] = strconv.FormatInt(value, 10)
+	db.dirty++
+	return Value{typ: "integer", num: int(value)}
+}
+
+// hincrbyfloat: HINCRBYFLOAT key field increment
+// フィールドの値に浮動小数点数を足し、結果を Bulk String で返します。
+func hincrbyfloat(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hincrbyfloat' command"}
+	}
+
+	incr, ok := parseFloat(args[2].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR value is not a valid float"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 計算に失敗したときに空のハッシュが残らないよう、ハッシュの作成は最後に行います。
+	o, errValue := lookupHashWrite(args[0].bulk, false)
+	if errValue != nil {
+		return *errValue
+	}
+
+	var fields map[string]string
+	if o != nil {
+		fields = o.hash()
+	}
+	var value float64
+	if current, exists := fields[args[1].bulk]; exists {
+		if value, ok = parseFloat(current); !ok {
+			return Value{typ: "error", str: "ERR hash value is not a float"}
+		}
+	}
+
+	value += incr
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return Value{typ: "error", str: "ERR increment would produce NaN or Infinity"}
+	}
+
+	result := strconv.FormatFloat(value, 'f', -1, 64)
+	if o == nil {
+		o, _ = lookupHashWrite(args[0].bulk, true)
+		fields = o.hash()
+	}
+	fields[args[1].bulk] = result
+	db.dirty++
+
+	// INCRBYFLOAT と同じく、AOFには計算した結果を HSET key field result として記録します。
+	rewriteCommand(c, "HSET", args[0].bulk, args[1].bulk, result)
+	return Value{typ: "bulk", bulk: result}
+}
+
+// ------------------------------
+// HSCAN コマンド
+// ------------------------------
+
+// hscan: HSCAN key cursor [MATCH pattern] [COUNT count]
+// フィールドと値を少しずつ返します。応答は [次のカーソル, [フィールド, 値, ...]] です（scan.go を参照）。
+func hscan(c *Client, args []Value) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hscan' command"}
+	}
+
+	opts, errValue := parseScanOptions(args[1:])
+	if errValue != nil {
+		return *errValue
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupHashRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+
+	items := []Value{}
+	cursor := uint64(0)
+	if o != nil {
+		fields := o.hash()
+		names := make([]string, 0, len(fields))
+		for field := range fields {
+			names = append(names, field)
+		}
+
+		var selected []string
+		cursor, selected = scanMembers(names, opts)
+		for _, field := range selected {
+			items = append(items, Value{typ: "bulk", bulk: field}, Value{typ: "bulk", bulk: fields[field]})
+		}
+	}
+
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: strconv.FormatUint(cursor, 10)},
+		{typ: "array", array: items},
+	}}
+}
+
+// ------------------------------
+// HRANDFIELD コマンド
+// ------------------------------
+
+// hrandfield: HRANDFIELD key [count [WITHVALUES]]
+// ランダムなフィールドを返します。
+//   - count なし: フィールドを1つ返す（ハッシュが存在しなければ null）
+//   - count が正: 重複しないフィールドを最大 count 個返す
+//   - count が負: 重複を許して、ちょうど -count 個のフィールドを返す
+//
+// WITHVALUES を指定すると、フィールドと値を組にして返します。
+func hrandfield(c *Client, args []Value) Value {
+	if len(args) < 1 || len(args) > 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hrandfield' command"}
+	}
+
+	withCount := len(args) >= 2
+	var count int64
+	if withCount {
+		var ok bool
+		if count, ok = parseInteger(args[1].bulk); !ok {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		// 負の count は符号を反転して使うため、反転できない math.MinInt64 などは受け付けません（Redisと同じ範囲です）。
+		if count < -math.MaxInt64/2 {
+			return Value{typ: "error", str: "ERR value is out of range"}
+		}
+	}
+	withValues := false
+	if len(args) == 3 {
+		if !strings.EqualFold(args[2].bulk, "WITHVALUES") {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		withValues = true
+		// 値と組にすると応答の要素数が2倍になるため、オーバーフローしないように制限します。
+		if count > math.MaxInt64/2 {
+			return Value{typ: "error", str: "ERR value is out of range"}
+		}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupHashRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		if withCount {
+			return Value{typ: "array", array: []Value{}}
+		}
+		return Value{typ: "null"}
+	}
+
+	fields := o.hash()
+
+	// count なしの場合は、フィールドを1つだけ Bulk String で返します。
+	if !withCount {
+		return Value{typ: "bulk", bulk: randomMapKey(fields)}
+	}
+
+	// すべてのフィールドを並べるのは、count が要素数に近い場合だけにします。
+	// 要素数の多いハッシュから少しだけ選ぶ呼び出しも、count に比例する時間で終わります。
+	var picked []string
+	switch {
+	case count < 0:
+		// 重複あり: -count 回、ランダムに選びます。
+		for range -count {
+			picked = append(picked, randomMapKey(fields))
+		}
+	case count >= int64(len(fields)):
+		// 重複なしで要素数以上: すべてのフィールドを返します。
+		for field := range fields {
+			picked = append(picked, field)
+		}
+	default:
+		picked = sampleDistinct(len(fields), int(count),
+			func() string { return randomMapKey(fields) },
+			func() []string {
+				names := make([]string, 0, len(fields))
+				for field := range fields {
+					names = append(names, field)
+				}
+				return names
+			})
+	}
+
+	items := make([]Value, 0, len(picked))
+	for _, field := range picked {
+		switch {
+		case !withValues:
+			items = append(items, Value{typ: "bulk", bulk: field})
+		case c.proto == 3:
+			// RESP3 では、フィールドと値の組を2要素の配列として返します。
+			items = append(items, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: field}, {typ: "bulk", bulk: fields[field]},
+			}})
+		default:
+			items = append(items, Value{typ: "bulk", bulk: field}, Value{typ: "bulk", bulk: fields[field]})
+		}
+	}
+	return Value{typ: "array", array: items}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+)
+
+// ====================================================================
+// 整数集合（intset）
+// ====================================================================
+//
+// Redisのセット型は、要素がすべて整数で数が少ない間は intset というデータ構造で保存されます。
+// intset は整数を小さい順に並べた配列で、要素の検索は二分探索で行います。
+//
+//	[-5 3 10 42 1000]
+//
+//   - ハッシュテーブルは要素ごとにエントリーとポインタを持つため、小さなセットにはメモリの無駄が多くなります。
+//   - intset は整数をそのまま隙間なく並べるだけなので、メモリを節約できます。
+//     ただし追加・削除のたびに要素をずらす必要があるため、要素が増えると遅くなります。
+//
+// そのため、要素数が set-max-intset-entries を超えるか、整数でない要素が追加されたときに
+// ハッシュテーブルへ変換します（set.go を参照）。一度変換したら、intset には戻しません。
+//
+// Redisの intset は、すべての要素が収まる最小の幅（16/32/64ビット）で整数を保存し、
+// 範囲外の整数が追加されたときに全体の幅を広げます（アップグレード）。
+// ここでは値は []int64 で持ちますが、幅の変化が見えるように encoding として記録します。
+
+// intsetの整数の幅（バイト数）です。
+const (
+	intsetEncInt16 = 2
+	intsetEncInt32 = 4
+	intsetEncInt64 = 8
+)
+
+// intset構造体: 小さい順に並んだ、重複のない整数の配列です。
+type intset struct {
+	encoding int     // 要素の整数の幅（intsetEncInt16 など）
+	contents []int64 // 小さい順に並んだ要素
+}
+
+// newIntset: 空の intset を作成します。
+func newIntset() *intset {
+	return &intset{encoding: intsetEncInt16}
+}
+
+// intsetValueEncoding: 整数を保存するのに必要な幅を返します。
+func intsetValueEncoding(v int64) int {
+	switch {
+	case v < math.MinInt32 || v > math.MaxInt32:
+		return intsetEncInt64
+	case v < math.MinInt16 || v > math.MaxInt16:
+		return intsetEncInt32
+	default:
+		return intsetEncInt16
+	}
+}
+
+// Len: 要素数を返します。
+func (is *intset) Len() int {
+	return len(is.contents)
+}
+
+// Dup: すべての要素をコピーした新しい intset を返します。
+func (is *intset) Dup() *intset {
+	return &intset{encoding: is.encoding, contents: slices.Clone(is.contents)}
+}
+
+// search: 要素の位置を二分探索で探します。見つからない場合は、挿入すべき位置と false を返します。
+func (is *intset) search(v int64) (int, bool) {
+	i := sort.Search(len(is.contents), func(i int) bool { return is.contents[i] >= v })
+	return i, i < len(is.contents) && is.contents[i] == v
+}
+
+// Add: 要素を追加します。すでに存在する場合は false を返します。
+func (is *intset) Add(v int64) bool {
+	// 現在の幅に収まらない整数は、全体の幅を広げてから追加します（Redisの intsetUpgradeAndAdd）。
+	if enc := intsetValueEncoding(v); enc > is.encoding {
+		is.encoding = enc
+	}
+
+	i, found := is.search(v)
+	if found {
+		return false
+	}
+	is.contents = append(is.contents, 0)
+	copy(is.contents[i+1:], is.contents[i:])
+	is.contents[i] = v
+	return true
+}
+
+// Remove: 要素を削除します。存在しない場合は false を返します。
+// Redisと同じく、要素を削除しても幅は狭めません。
+func (is *intset) Remove(v int64) bool {
+	i, found := is.search(v)
+	if !found {
+		return false
+	}
+	is.contents = append(is.contents[:i], is.contents[i+1:]...)
+	return true
+}
+
+// Find: 要素が存在するかを返します。
+func (is *intset) Find(v int64) bool {
+	_, found := is.search(v)
+	return found
+}
+
+// Get: i 番目（小さい方から）の要素を返します。
+func (is *intset) Get(i int) int64 {
+	return is.contents[i]
+}
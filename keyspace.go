@@ -0,0 +1,296 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ====================================================================
+// キースペースの汎用コマンド（DEL, EXISTS, TYPE, RENAME, COPY など）
+// ====================================================================
+//
+// これらのコマンドは値の型に関係なく、キーそのものを操作します。
+// 値のオブジェクト（db.go の Object）をそのまま付け替えるだけなので、どの型のキーにも使えます。
+//   - RENAME は値のオブジェクトを新しいキーに付け替えるだけで、値はコピーしません（O(1)）
+//   - COPY は値を深くコピー（Object の dup）するため、コピー後に片方を変更してももう片方には影響しません
+//   - UNLINK は DEL と同じくキーを削除しますが、大きな値の解放はバックグラウンドで行います（lazyfree.go を参照）
+
+// ------------------------------
+// DEL / UNLINK コマンド
+// ------------------------------
+
+// del: DEL key [key ...]
+// 指定したキーを削除し、削除したキーの数を返します。存在しないキーは無視します。
+func del(c *Client, args []Value) Value {
+	return delGeneric(args, false, "del")
+}
+
+// unlink: UNLINK key [key ...]
+// DEL と同じですが、解放の手間が大きい値はバックグラウンドのゴルーチンで解放します。
+func unlink(c *Client, args []Value) Value {
+	return delGeneric(args, true, "unlink")
+}
+
+// delGeneric: DEL と UNLINK の共通処理です。lazy が true なら、値を遅延解放します。
+func delGeneric(args []Value, lazy bool, name string) Value {
+	if len(args) < 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	deleted := 0
+	for _, arg := range args {
+		key := arg.bulk
+		// 期限切れのキーは、すでに存在しないものとして数えません。
+		if db.lookupKeyWrite(key) == nil {
+			continue
+		}
+		if lazy {
+			db.asyncDeleteKey(key)
+		} else {
+			db.deleteKey(key)
+		}
+		deleted++
+		db.dirty++
+	}
+	return Value{typ: "integer", num: deleted}
+}
+
+// ------------------------------
+// EXISTS コマンド
+// ------------------------------
+
+// exists: EXISTS key [key ...]
+// 存在するキーの数を返します。同じキーを複数回指定した場合は、その回数だけ数えます。
+func exists(c *Client, args []Value) Value {
+	if len(args) < 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'exists' command"}
+	}
+
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.bulk
+	}
+	db.expireIfNeeded(keys...)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// Redisと同じく、存在の確認だけでは最終アクセス時刻を更新しません。
+	count := 0
+	for _, key := range keys {
+		if _, ok := db.dict[key]; ok && !db.isExpired(key) {
+			count++
+		}
+	}
+	return Value{typ: "integer", num: count}
+}
+
+// ------------------------------
+// TYPE コマンド
+// ------------------------------
+
+// typeCommand: TYPE key
+// キーの値の型（string, list, set, zset, hash, stream）を返します。キーが存在しない場合は none を返します。
+func typeCommand(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'type' command"}
+	}
+
+	key := args[0].bulk
+	db.expireIfNeeded(key)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, ok := db.dict[key]
+	if !ok || db.isExpired(key) {
+		return Value{typ: "string", str: "none"}
+	}
+	return Value{typ: "string", str: o.typ}
+}
+
+// ------------------------------
+// RENAME / RENAMENX コマンド
+// ------------------------------
+
+// rename: RENAME key newkey
+// キーの名前を変更します。newkey が存在する場合は、型に関係なく上書きします。
+func rename(c *Client, args []Value) Value {
+	return renameGeneric(args, false, "rename")
+}
+
+// renamenx: RENAMENX key newkey
+// newkey が存在しない場合だけキーの名前を変更し、変更した場合は 1、しなかった場合は 0 を返します。
+func renamenx(c *Client, args []Value) Value {
+	return renameGeneric(args, true, "renamenx")
+}
+
+// renameGeneric: RENAME と RENAMENX の共通処理です。
+// 値のオブジェクトを新しいキーに付け替え、有効期限も引き継ぎます。
+func renameGeneric(args []Value, nx bool, name string) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	key, newkey := args[0].bulk, args[1].bulk
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o := db.lookupKeyWrite(key)
+	if o == nil {
+		return Value{typ: "error", str: "ERR no such key"}
+	}
+
+	// 同じキーへの変更は何もしません（RENAMENX では newkey が存在するので 0 になります）。
+	if key == newkey {
+		if nx {
+			return Value{typ: "integer", num: 0}
+		}
+		return Value{typ: "string", str: "OK"}
+	}
+
+	if db.lookupKeyWrite(newkey) != nil && nx {
+		return Value{typ: "integer", num: 0}
+	}
+
+	when, hasTTL := db.expires[key]
+	db.deleteKey(key)
+	db.setKey(newkey, o, false)
+	if hasTTL {
+		db.setExpire(newkey, when)
+	}
+	db.dirty++
+	// newkey を待っているクライアント（BLPOP など）がいれば、付け替えた値で応答できるか調べます。
+	db.signalKeyAsReady(newkey)
+
+	if nx {
+		return Value{typ: "integer", num: 1}
+	}
+	return Value{typ: "string", str: "OK"}
+}
+
+// ------------------------------
+// COPY コマンド
+// ------------------------------
+
+// copyCommand: COPY source destination [DB destination-db] [REPLACE]
+// source の値を深くコピーして destination に保存し、コピーした場合は 1、destination が存在する場合は 0 を返します。
+//   - DB:      コピー先のデータベースの番号（このサーバーのデータベースは 0 番だけです）
+//   - REPLACE: destination が存在する場合も上書きする
+//
+// 有効期限もコピーします。
+func copyCommand(c *Client, args []Value) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'copy' command"}
+	}
+
+	source, destination := args[0].bulk, args[1].bulk
+	replace := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 >= len(args) {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			i++
+			dbid, err := strconv.Atoi(args[i].bulk)
+			if err != nil {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			if dbid != 0 {
+				return Value{typ: "error", str: "ERR DB index is out of range"}
+			}
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	if source == destination {
+		return Value{typ: "error", str: "ERR source and destination objects are the same"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o := db.lookupKeyWrite(source)
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	if db.lookupKeyWrite(destination) != nil && !replace {
+		return Value{typ: "integer", num: 0}
+	}
+
+	when, hasTTL := db.expires[source]
+	db.setKey(destination, o.dup(), false)
+	if hasTTL {
+		db.setExpire(destination, when)
+	}
+	db.dirty++
+	db.signalKeyAsReady(destination)
+	return Value{typ: "integer", num: 1}
+}
+
+// ------------------------------
+// RANDOMKEY コマンド
+// ------------------------------
+
+// randomkey: RANDOMKEY
+// ランダムなキーを1つ返します。キースペースが空の場合は null を返します。
+// キーは randomMapKey で選ぶので、キーの数に関係なく短い時間で終わります（Redisの dbRandomKey）。
+// 選んだキーの期限が切れていた場合だけ、そのキーを削除して（DEL をAOFに記録して）選び直します。
+func randomkey(c *Client, args []Value) Value {
+	if len(args) != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'randomkey' command"}
+	}
+
+	for {
+		db.mu.RLock()
+		if len(db.dict) == 0 {
+			db.mu.RUnlock()
+			return Value{typ: "null"}
+		}
+		key := randomMapKey(db.dict)
+		expired := db.isExpired(key)
+		db.mu.RUnlock()
+
+		if !expired {
+			return Value{typ: "bulk", bulk: key}
+		}
+		db.expireIfNeeded(key)
+	}
+}
+
+// ------------------------------
+// TOUCH コマンド
+// ------------------------------
+
+// touch: TOUCH key [key ...]
+// キーの最終アクセス時刻（OBJECT IDLETIME）を更新し、存在するキーの数を返します。
+func touch(c *Client, args []Value) Value {
+	if len(args) < 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'touch' command"}
+	}
+
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.bulk
+	}
+	db.expireIfNeeded(keys...)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	count := 0
+	for _, key := range keys {
+		if db.lookupKeyRead(key) != nil {
+			count++
+		}
+	}
+	return Value{typ: "integer", num: count}
+}
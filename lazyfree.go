@@ -0,0 +1,77 @@
+package main
+
+// ====================================================================
+// 遅延解放（lazy free）
+// ====================================================================
+//
+// Redisでは、要素数の多い値を削除すると、そのメモリの解放だけでメインスレッドが長い時間止まることがあります。
+// そこで UNLINK コマンドは、キーをキースペースから取り除く（O(1)）だけにして、
+// 値の解放はバックグラウンドのスレッドに任せます（lazy free。Redisの lazyfree.c）。
+//
+// Goではメモリの解放はGCが行いますが、ここでもRedisと同じ流れにしています。
+// 解放の手間（freeEffort）が lazyfreeThreshold を超える値は、キースペースから取り除いた後に
+// バックグラウンドのゴルーチンで中身を破棄します（マップを空にし、ノードのつながりを切ります）。
+// キースペースから取り除いた値は、どのクライアントからも参照されないため、ロックなしで破棄できます。
+
+// lazyfreeThreshold: 解放の手間がこれを超える値を、バックグラウンドで解放します（Redisの LAZYFREE_THRESHOLD）。
+const lazyfreeThreshold = 64
+
+// freeEffort: 値の解放の手間（おおよそのメモリ確保の回数）を返します（Redisの lazyfreeGetFreeEffort）。
+// 1つの配列にまとめて保存するエンコーディング（intset や listpack）は、要素数に関係なく 1 です。
+func freeEffort(o *Object) int {
+	switch v := o.value.(type) {
+	case map[string]string:
+		return len(v)
+	case map[string]struct{}:
+		return len(v)
+	case *quicklist:
+		return v.NodeCount()
+	case *zset:
+		return v.zsl.length
+	case *stream:
+		effort := v.index.NodeCount()
+		for _, cg := range v.groups {
+			effort += cg.pel.Len()
+		}
+		return effort
+	default:
+		return 1
+	}
+}
+
+// freeObject: 値の中身を破棄します。キースペースから取り除いた後の値に対してだけ呼び出します。
+func freeObject(o *Object) {
+	switch v := o.value.(type) {
+	case map[string]string:
+		clear(v)
+	case map[string]struct{}:
+		clear(v)
+	case *quicklist:
+		for node := v.head; node != nil; {
+			next := node.next
+			node.prev, node.next, node.entries = nil, nil, nil
+			node = next
+		}
+		v.head, v.tail, v.count, v.nodes = nil, nil, 0, 0
+	case *zset:
+		clear(v.dict)
+		v.zsl = newZskiplist()
+	case *stream:
+		v.index = streamIndex{}
+		clear(v.groups)
+	}
+}
+
+// asyncDeleteKey: キーを削除し、値の解放の手間が大きければバックグラウンドのゴルーチンで解放します
+// （Redisの dbAsyncDelete）。削除した場合は true を返します。書き込みロックを取得した状態で呼び出します。
+func (d *DB) asyncDeleteKey(key string) bool {
+	o, ok := d.dict[key]
+	if !ok {
+		return false
+	}
+	d.deleteKey(key)
+	if freeEffort(o) > lazyfreeThreshold {
+		go freeObject(o)
+	}
+	return true
+}
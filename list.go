@@ -0,0 +1,640 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// ====================================================================
+// リスト型のコマンド
+// ====================================================================
+//
+// リストの値は Object の value に *quicklist として保存されます（quicklist.go を参照）。
+// Redisと同じく、空のリストは存在できません。最後の要素を取り出したときは、キーごと削除します。
+
+// lookupListRead: 読み取りのためにリストのキーを検索します。
+// キーが存在しない場合は nil、リスト以外の型の場合は WRONGTYPE のエラー応答を返します。
+// 読み取りロックを取得した状態で呼び出します。
+func lookupListRead(key string) (*Object, *Value) {
+	o := db.lookupKeyRead(key)
+	if o != nil && o.typ != ObjList {
+		return nil, &wrongTypeError
+	}
+	return o, nil
+}
+
+// lookupListWrite: 書き込みのためにリストのキーを検索します。
+// create が true でキーが存在しない場合は、空のリストを作成します。
+// 書き込みロックを取得した状態で呼び出します。
+func lookupListWrite(key string, create bool) (*Object, *Value) {
+	o := db.lookupKeyWrite(key)
+	if o == nil {
+		if !create {
+			return nil, nil
+		}
+		o = newListObject()
+		db.setKey(key, o, false)
+	} else if o.typ != ObjList {
+		return nil, &wrongTypeError
+	}
+	return o, nil
+}
+
+// deleteListIfEmpty: リストが空になっていれば、キーを削除します。書き込みロックを取得した状態で呼び出します。
+func deleteListIfEmpty(key string, o *Object) {
+	if o.list().Len() == 0 {
+		db.deleteKey(key)
+	}
+}
+
+// listRange: LRANGE や LTRIM の start と end（負の値は末尾からの位置）を、0 以上 length 未満の範囲に変換します。
+// 範囲が空の場合は ok に false を返します。
+func listRange(start, end int64, length int) (int, int, bool) {
+	n := int64(length)
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > end || start >= n {
+		return 0, 0, false
+	}
+	if end >= n {
+		end = n - 1
+	}
+	return int(start), int(end), true
+}
+
+// ------------------------------
+// LPUSH / RPUSH コマンド
+// ------------------------------
+
+// lpush: LPUSH key element [element ...]
+// 要素を先頭に追加し、追加後の長さを返します。複数の要素は1つずつ先頭に追加されるため、逆順に並びます。
+func lpush(c *Client, args []Value) Value {
+	return pushGeneric(args, "lpush", true)
+}
+
+// rpush: RPUSH key element [element ...]
+// 要素を末尾に追加し、追加後の長さを返します。
+func rpush(c *Client, args []Value) Value {
+	return pushGeneric(args, "rpush", false)
+}
+
+// pushGeneric: LPUSH と RPUSH の共通処理です。
+func pushGeneric(args []Value, name string, head bool) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupListWrite(args[0].bulk, true)
+	if errValue != nil {
+		return *errValue
+	}
+
+	ql := o.list()
+	for _, element := range args[1:] {
+		if head {
+			ql.PushHead(element.bulk)
+		} else {
+			ql.PushTail(element.bulk)
+		}
+		db.dirty++
+	}
+	length := ql.Len()
+
+	// このキーを BLPOP などで待っているクライアントがいれば、要素を渡します（blocking.go を参照）。
+	// 応答は、待っているクライアントに渡す前の長さです（Redisと同じ動作です）。
+	db.signalKeyAsReady(args[0].bulk)
+	return Value{typ: "integer", num: length}
+}
+
+// ------------------------------
+// LPOP / RPOP コマンド
+// ------------------------------
+
+// lpop: LPOP key [count]
+// 先頭の要素を取り出します。count を指定すると、最大 count 個を配列で返します。
+func lpop(c *Client, args []Value) Value {
+	return popGeneric(args, "lpop", true)
+}
+
+// rpop: RPOP key [count]
+// 末尾の要素を取り出します。
+func rpop(c *Client, args []Value) Value {
+	return popGeneric(args, "rpop", false)
+}
+
+// popGeneric: LPOP と RPOP の共通処理です。
+// キーが存在しない場合、count なしなら null、count ありなら null の配列を返します。
+func popGeneric(args []Value, name string, head bool) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	key := args[0].bulk
+	withCount := len(args) == 2
+	count := int64(1)
+	if withCount {
+		var ok bool
+		if count, ok = parseInteger(args[1].bulk); !ok || count < 0 {
+			return Value{typ: "error", str: "ERR value is out of range, must be positive"}
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupListWrite(key, false)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		if withCount {
+			return Value{typ: "nullarray"}
+		}
+		return Value{typ: "null"}
+	}
+
+	ql := o.list()
+	if !withCount {
+		value, _ := popListElement(ql, head)
+		deleteListIfEmpty(key, o)
+		db.dirty++
+		return Value{typ: "bulk", bulk: value}
+	}
+
+	values := []Value{}
+	for range min(count, int64(ql.Len())) {
+		value, _ := popListElement(ql, head)
+		values = append(values, Value{typ: "bulk", bulk: value})
+		db.dirty++
+	}
+	deleteListIfEmpty(key, o)
+	return Value{typ: "array", array: values}
+}
+
+// popListElement: リストの先頭（head が true の場合）または末尾から要素を取り出します。
+func popListElement(ql *quicklist, head bool) (string, bool) {
+	if head {
+		return ql.PopHead()
+	}
+	return ql.PopTail()
+}
+
+// ------------------------------
+// LLEN / LINDEX / LRANGE コマンド
+// ------------------------------
+
+// llen: LLEN key
+// リストの長さを返します。キーが存在しない場合は 0 です。
+func llen(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'llen' command"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupListRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	return Value{typ: "integer", num: o.list().Len()}
+}
+
+// lindex: LINDEX key index
+// index 番目の要素を返します。負の index は末尾からの位置です。範囲外の場合は null を返します。
+func lindex(c *Client, args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'lindex' command"}
+	}
+
+	index, ok := parseInteger(args[1].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupListRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "null"}
+	}
+
+	ql := o.list()
+	if index < -int64(ql.Len()) || index >= int64(ql.Len()) {
+		return Value{typ: "null"}
+	}
+	value, _ := ql.Index(int(index))
+	return Value{typ: "bulk", bulk: value}
+}
+
+// lrange: LRANGE key start stop
+// start 番目から stop 番目まで（両端を含む）の要素を返します。負のインデックスは末尾からの位置です。
+func lrange(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'lrange' command"}
+	}
+
+	start, ok1 := parseInteger(args[1].bulk)
+	end, ok2 := parseInteger(args[2].bulk)
+	if !ok1 || !ok2 {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupListRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+
+	values := []Value{}
+	if o == nil {
+		return Value{typ: "array", array: values}
+	}
+
+	ql := o.list()
+	from, to, ok := listRange(start, end, ql.Len())
+	if !ok {
+		return Value{typ: "array", array: values}
+	}
+	for _, element := range ql.Range(from, to) {
+		values = append(values, Value{typ: "bulk", bulk: element})
+	}
+	return Value{typ: "array", array: values}
+}
+
+// ------------------------------
+// LSET / LINSERT コマンド
+// ------------------------------
+
+// lset: LSET key index element
+// index 番目の要素を置き換えます。
+func lset(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'lset' command"}
+	}
+
+	index, ok := parseInteger(args[1].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupListWrite(args[0].bulk, false)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "error", str: "ERR no such key"}
+	}
+
+	ql := o.list()
+	if index < -int64(ql.Len()) || index >= int64(ql.Len()) || !ql.Set(int(index), args[2].bulk) {
+		return Value{typ: "error", str: "ERR index out of range"}
+	}
+	db.dirty++
+	return Value{typ: "string", str: "OK"}
+}
+
+// linsert: LINSERT key BEFORE|AFTER pivot element
+// 最初に見つかった pivot の前（または後ろ）に要素を挿入し、挿入後の長さを返します。
+// pivot が見つからない場合は -1、キーが存在しない場合は 0 を返します。
+func linsert(c *Client, args []Value) Value {
+	if len(args) != 4 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'linsert' command"}
+	}
+
+	var after bool
+	switch strings.ToUpper(args[1].bulk) {
+	case "BEFORE":
+		after = false
+	case "AFTER":
+		after = true
+	default:
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	pivot, element := args[2].bulk, args[3].bulk
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupListWrite(args[0].bulk, false)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+
+	// 先頭から pivot を探します。
+	ql := o.list()
+	it := ql.Iterator(0, true)
+	for index := 0; ; index++ {
+		value, ok := it.Next()
+		if !ok {
+			return Value{typ: "integer", num: -1}
+		}
+		if value == pivot {
+			if after {
+				index++
+			}
+			ql.Insert(index, element)
+			db.dirty++
+			return Value{typ: "integer", num: ql.Len()}
+		}
+	}
+}
+
+// ------------------------------
+// LREM / LTRIM コマンド
+// ------------------------------
+
+// lrem: LREM key count element
+// element と等しい要素を削除し、削除した数を返します。
+//   - count > 0: 先頭から count 個を削除する
+//   - count < 0: 末尾から -count 個を削除する
+//   - count = 0: すべて削除する
+func lrem(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'lrem' command"}
+	}
+
+	count, ok := parseInteger(args[1].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+	// 件数の上限として使うだけなので、int に収まるように丸めます。
+	count = max(min(count, math.MaxInt32), math.MinInt32)
+
+	key := args[0].bulk
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupListWrite(key, false)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+
+	removed := o.list().Remove(args[2].bulk, int(count))
+	deleteListIfEmpty(key, o)
+	db.dirty += int64(removed)
+	return Value{typ: "integer", num: removed}
+}
+
+// ltrim: LTRIM key start stop
+// start 番目から stop 番目まで（両端を含む）の要素だけを残し、それ以外を削除します。
+func ltrim(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'ltrim' command"}
+	}
+
+	start, ok1 := parseInteger(args[1].bulk)
+	end, ok2 := parseInteger(args[2].bulk)
+	if !ok1 || !ok2 {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	key := args[0].bulk
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupListWrite(key, false)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "string", str: "OK"}
+	}
+
+	ql := o.list()
+	length := ql.Len()
+	from, to, ok := listRange(start, end, length)
+	if !ok {
+		// 範囲が空の場合は、すべての要素を削除します。
+		ql.DeleteRange(0, ql.Len())
+	} else {
+		// 後ろ側を先に削除すると、前側のインデックスがずれません。
+		ql.DeleteRange(to+1, ql.Len()-to-1)
+		ql.DeleteRange(0, from)
+	}
+	db.dirty += int64(length - ql.Len())
+	deleteListIfEmpty(key, o)
+	return Value{typ: "string", str: "OK"}
+}
+
+// ------------------------------
+// LPOS コマンド
+// ------------------------------
+
+// lpos: LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+// element と等しい要素の位置（先頭からのインデックス）を返します。
+//   - RANK: rank 番目に一致した要素から返す（負の場合は末尾から探す）
+//   - COUNT: 最大 num-matches 個の位置を配列で返す（0 はすべて）
+//   - MAXLEN: 比較する要素の数の上限（0 は無制限）
+func lpos(c *Client, args []Value) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'lpos' command"}
+	}
+
+	rank, count, maxlen := int64(1), int64(0), int64(0)
+	withCount := false
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		n, ok := parseInteger(args[i+1].bulk)
+		if !ok {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		switch strings.ToUpper(args[i].bulk) {
+		case "RANK":
+			if n == 0 {
+				return Value{typ: "error", str: "ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the last match"}
+			}
+			if n == math.MinInt64 {
+				return Value{typ: "error", str: "ERR value is out of range"}
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				return Value{typ: "error", str: "ERR COUNT can't be negative"}
+			}
+			count, withCount = n, true
+		case "MAXLEN":
+			if n < 0 {
+				return Value{typ: "error", str: "ERR MAXLEN can't be negative"}
+			}
+			maxlen = n
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupListRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		if withCount {
+			return Value{typ: "array", array: []Value{}}
+		}
+		return Value{typ: "null"}
+	}
+
+	// rank が負なら末尾から先頭に向かって探します。
+	ql := o.list()
+	forward := rank > 0
+	skip := rank - 1
+	start := 0
+	if !forward {
+		skip = -rank - 1
+		start = ql.Len() - 1
+	}
+
+	matches := []Value{}
+	it := ql.Iterator(start, forward)
+	for compared := int64(0); maxlen == 0 || compared < maxlen; compared++ {
+		value, ok := it.Next()
+		if !ok {
+			break
+		}
+		if value != args[1].bulk {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		index := int64(compared)
+		if !forward {
+			index = int64(ql.Len()) - 1 - compared
+		}
+		matches = append(matches, Value{typ: "integer", num: int(index)})
+		// COUNT なしの場合は最初の1つ、COUNT ありの場合は count 個（0 ならすべて）で終了します。
+		if !withCount || (count > 0 && int64(len(matches)) >= count) {
+			break
+		}
+	}
+
+	if withCount {
+		return Value{typ: "array", array: matches}
+	}
+	if len(matches) == 0 {
+		return Value{typ: "null"}
+	}
+	return matches[0]
+}
+
+// ------------------------------
+// LMOVE / RPOPLPUSH コマンド
+// ------------------------------
+
+// lmove: LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+// source の先頭（LEFT）または末尾（RIGHT）から要素を取り出し、destination の先頭または末尾に追加します。
+// 取り出した要素を返します。source が存在しない場合は null を返します。
+func lmove(c *Client, args []Value) Value {
+	if len(args) != 4 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'lmove' command"}
+	}
+
+	fromHead, ok1 := parseListSide(args[2].bulk)
+	toHead, ok2 := parseListSide(args[3].bulk)
+	if !ok1 || !ok2 {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return lmoveGeneric(args[0].bulk, args[1].bulk, fromHead, toHead)
+}
+
+// rpoplpush: RPOPLPUSH source destination
+// LMOVE source destination RIGHT LEFT と同じです。
+func rpoplpush(c *Client, args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'rpoplpush' command"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return lmoveGeneric(args[0].bulk, args[1].bulk, false, true)
+}
+
+// parseListSide: LEFT / RIGHT を解析します。LEFT なら true を返します。
+func parseListSide(s string) (bool, bool) {
+	switch strings.ToUpper(s) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// lmoveGeneric: LMOVE の共通処理です。書き込みロックを取得した状態で呼び出します。
+// source と destination が同じキーの場合は、リストを回転させることになります。
+func lmoveGeneric(source, destination string, fromHead, toHead bool) Value {
+	src, errValue := lookupListWrite(source, false)
+	if errValue != nil {
+		return *errValue
+	}
+	if src == nil {
+		return Value{typ: "null"}
+	}
+	// 要素を取り出す前に、destination の型を確認します（型が違う場合は何も変更しません）。
+	if dst := db.lookupKeyWrite(destination); dst != nil && dst.typ != ObjList {
+		return wrongTypeError
+	}
+
+	value, _ := popListElement(src.list(), fromHead)
+	deleteListIfEmpty(source, src)
+
+	dst, _ := lookupListWrite(destination, true)
+	if toHead {
+		dst.list().PushHead(value)
+	} else {
+		dst.list().PushTail(value)
+	}
+	db.dirty++
+	db.signalKeyAsReady(destination)
+	return Value{typ: "bulk", bulk: value}
+}
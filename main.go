@@ -1,148 +1,154 @@
 package main // プログラムの実行を開始するメインパッケージを宣言します。
 
 import (
-	"fmt"     // フォーマットされたI/O（主にメッセージ出力）を行うためのパッケージです。
-	"net"     // ネットワークI/O（TCP通信など）を扱うためのパッケージです。
-	"strings" // 文字列操作（コマンド名を大文字に変換するなど）のためのパッケージです。
+	"fmt"       // フォーマットされたI/O（主にメッセージ出力）を行うためのパッケージです。
+	"net"       // ネットワークI/O（TCP通信など）を扱うためのパッケージです。
+	"os"        // OSのシグナル（os.Signal）を扱うためのパッケージです。
+	"os/signal" // SIGINT/SIGTERM などのシグナルを受け取るためのパッケージです。
+	"strings"   // 文字列操作（コマンド名を大文字に変換するなど）のためのパッケージです。
+	"syscall"   // シグナルの種類（SIGTERMなど）の定数を提供します。
 )
 
 // main関数は、プログラムが実行されたときに最初に呼び出される特別な関数です。
 func main() {
-	// サーバーが待ち受けを開始することをコンソールに出力します。
-	fmt.Println("Listening on port :6379")
-
 	// ----------------------------------------------------
-	// 1. AOFファイルの初期化とデータ復元
+	// 0. 設定の読み込み
 	// ----------------------------------------------------
 
-	// AOF構造体を初期化し、ファイル（database.aof）を開きます。
-	aof, err := NewAof("database.aof")
+	// check-aof で起動された場合は、サーバーを起動せずにAOFのファイルを検査して終了します（aofcheck.go を参照）。
+	// 例: go run . check-aof --fix appendonlydir/database.aof.manifest
+	if len(os.Args) > 1 && os.Args[1] == "check-aof" {
+		os.Exit(checkAofMain(os.Args[2:]))
+	}
+
+	// 設定ファイル（redis.conf 形式）とコマンドラインフラグから設定を読み込みます。
+	// 例: go run . redis.conf --port 6380
+	cfg, err := loadConfig(os.Args[1:])
 	if err != nil {
-		fmt.Println("Error initializing AOF:", err)
-		return
+		// 設定に誤りがある場合は、起動せずに終了します。
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	defer aof.Close() // サーバー終了時にAOFファイルを閉じることを保証
+	config = cfg
 
-	// AOFファイルを読み込み、保存されているコマンドを再実行してメモリにデータを復元します。
-	aof.Read(func(value Value) {
-		// AOFから読み込んだコマンドを抽出し、大文字に変換
-		command := strings.ToUpper(value.array[0].bulk)
-		args := value.array[1:]
+	// ----------------------------------------------------
+	// 1. AOFファイルの初期化とデータ復元
+	// ----------------------------------------------------
 
-		// ハンドラーを検索
-		handler, ok := Handlers[command]
-		if !ok {
-			fmt.Printf("AOF Read: Invalid command '%s' found. Skipping.\n", command)
+	// appendonly yes の場合のみ、AOFファイルを開いてデータを復元します。
+	var aof *Aof
+	if config.appendOnly {
+		// AOF構造体を初期化し、ディレクトリ（デフォルトは appendonlydir/）のマニフェストと追記先のファイルを開きます。
+		aof, err = NewAof(config.appendDirname, config.appendFilename)
+		if err != nil {
+			fmt.Println("Error initializing AOF:", err)
 			return
 		}
+		defer aof.Close() // サーバー終了時にAOFファイルを閉じることを保証
 
-		// ハンドラーを実行し、メモリ上のデータストアを再構築します。
-		// この処理ではクライアントへの応答は不要なので結果は無視します。
-		handler(args)
-	})
+		// AOFが壊れている場合は、一部だけ復元したデータでサーバーを始めないよう、起動を中止します。
+		if err := loadAof(aof); err != nil {
+			fmt.Println(err)
+			aof.Close()
+			os.Exit(1)
+		}
+	}
 
 	// ----------------------------------------------------
 	// 2. サーバーソケットの作成と接続の待機
 	// ----------------------------------------------------
 
-	// net.Listenを使って、TCPプロトコルで ":6379"（全てのネットワークインターフェースの6379番ポート）で
-	// 新しい接続を待ち受けるリスナー（待ち受けソケット）を作成します。
-	l, err := net.Listen("tcp", ":6379")
-	if err != nil {
-		// リスナーの作成に失敗した場合（例: ポートが既に使用されている）は、エラーを出力してプログラムを終了します。
-		fmt.Println(err)
-		return
+	// net.Listenを使って、TCPプロトコルで待ち受けるリスナー（待ち受けソケット）を作成します。
+	// bind で複数のアドレスが指定されている場合は、アドレスごとにリスナーを作成します。
+	// デフォルトは ":6379"（全てのネットワークインターフェースの6379番ポート）です。
+	var listeners []net.Listener
+	for _, addr := range config.listenAddrs() {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			// リスナーの作成に失敗した場合（例: ポートが既に使用されている）は、エラーを出力してプログラムを終了します。
+			fmt.Println(err)
+			for _, l := range listeners {
+				l.Close()
+			}
+			return
+		}
+		// サーバーが待ち受けを開始することをコンソールに出力します。
+		fmt.Println("Listening on", l.Addr())
+		listeners = append(listeners, l)
 	}
 
 	// ----------------------------------------------------
-	// 3. クライアントからの接続を待つ
+	// 3. クライアントからの接続を待ち、並行して処理する
 	// ----------------------------------------------------
 
-	// l.Accept() は、新しいクライアント接続が来るまで処理をブロック（停止）します。
-	// 接続が確立されると、その接続を表す `conn`（net.Connインターフェース）が返されます。
-	conn, err := l.Accept()
-	if err != nil {
-		// 接続の受け入れ中にエラーが発生した場合、エラーを出力してプログラムを終了します。
-		fmt.Println(err)
-		return
-	}
+	// Server は接続ごとにゴルーチンを起動し、
+	// それぞれのゴルーチンが「読み取り → コマンド実行 → 応答」のループを回します（server.go を参照）。
+	// そのため、1人目のクライアントが切断してもサーバーは終了せず、次の接続を受け付け続けます。
+	server = NewServer(listeners, aof)
 
-	// defer conn.Close()
-	// defer は、この関数 (main) の処理が終了する直前に conn.Close() を実行するように予約します。
-	// これにより、プログラムが正常終了してもエラーで終了しても、必ず接続が閉じられることが保証されます。
-	defer conn.Close()
+	// 有効期限が切れたキーを定期的に削除するゴルーチンを開始します（expire.go を参照）。
+	// 削除したキーは server を通してAOFに記録するので、server を作成してから開始します。
+	// defer は登録と逆の順に実行されるため、AOFを閉じる前に停止します。
+	stopActiveExpire := startActiveExpireCycle()
+	defer stopActiveExpire()
+
+	go func() {
+		if err := server.Serve(); err != nil {
+			fmt.Println(err)
+		}
+	}()
 
 	// ----------------------------------------------------
-	// 4. 通信ループ：リクエスト処理とAOFへの追記
+	// 4. シャットダウン（SIGINT/SIGTERM または SHUTDOWN コマンド）
 	// ----------------------------------------------------
 
-	// クライアントとの接続が確立された後、データを継続的に処理するための無限ループに入ります。
-	for {
-		// --- リクエストの読み取りとパース ---
-
-		// 接続 (conn) を使って新しい RESP パーサー（リーダー）を作成します。
-		resp := NewResp(conn)
+	// Ctrl+C（SIGINT）や kill（SIGTERM）を受け取るためのチャネルを登録します。
+	// 登録しないと、シグナルを受けた時点でプロセスが即座に終了し、defer も実行されません。
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	// シグナルか SHUTDOWN コマンドのどちらかが来るまで待ちます。
+	select {
+	case sig := <-sigCh:
+		fmt.Printf("Received %s, scheduling shutdown...\n", sig)
+	case <-server.ShutdownRequested():
+		fmt.Println("User requested shutdown...")
+	}
 
-		// クライアントから送られてきたRESP形式のデータを読み取り、Value構造体にパースします。
-		value, err := resp.Read()
-		if err != nil {
-			// データ読み取り中にエラーが発生した場合（クライアント切断など）は、ループを終了します。
-			fmt.Println(err)
-			return
-		}
+	// 2回目の Ctrl+C では即座に終了できるように、シグナルの受け取りを元に戻します。
+	signal.Stop(sigCh)
 
-		// --- リクエストの検証 ---
+	// 新しい接続の受け付けを止め、実行中のコマンドが終わるのを待ちます。
+	server.Shutdown(shutdownTimeout)
 
-		// Redisコマンドは必ずRESP Array（配列）である必要があります。
-		if value.typ != "array" {
-			fmt.Println("Invalid request, expected array")
-			// 処理をスキップして次のリクエストを待ちます。
-			continue
-		}
+	// この後 defer により aof.Close() が実行され、AOFがディスクに同期されてから閉じられます。
+	fmt.Println("Redis is now ready to exit, bye bye...")
+}
 
-		// 配列が空であってはなりません（最低でもコマンド名が必要です）。
-		if len(value.array) == 0 {
-			fmt.Println("Invalid request, expected array length > 0")
-			continue
-		}
+// loadAof: AOFファイルを読み込み、保存されているコマンドを再実行してメモリにデータを復元します。
+// AOFが壊れていて最後まで読み込めなかった場合は、エラーを返します（Aof.Read を参照）。
+func loadAof(aof *Aof) error {
+	// AOFのコマンドは、ネットワーク接続を持たない疑似クライアントとして実行します（Redisの AOF client と同じ考え方です）。
+	fakeClient := &Client{authenticated: true, proto: 2}
 
-		// --- コマンド名と引数の抽出 ---
+	// 読み込み中は、期限が過ぎたキーも期限切れとはみなしません（DB.isExpired を参照）。
+	db.loading = true
+	defer func() { db.loading = false }()
 
-		// 配列の最初の要素がコマンド名です。それを大文字に変換します（Redisはコマンド名で大文字小文字を区別しません）。
-		// .bulk を使うのは、コマンド名が常に Bulk String（例: $3\r\nSET\r\n）として送られてくるためです。
+	return aof.Read(func(value Value) {
+		// AOFから読み込んだコマンドを抽出し、大文字に変換
 		command := strings.ToUpper(value.array[0].bulk)
-
-		// 配列の2番目以降の要素すべてを引数（args）としてスライスします。
 		args := value.array[1:]
 
-		// --- コマンドの実行と応答 ---
-
-		// 接続 (conn) を使って新しい RESP Writer（書き出し側）を作成します。
-		writer := NewWriter(conn)
-
-		// Handlersマップから、コマンド名に対応するハンドラー関数を検索します。
-		handler, ok := Handlers[command]
+		// コマンドを検索
+		cmd, ok := commandTable[command]
 		if !ok {
-			// コマンドが見つからなかった場合
-			fmt.Println("Invalid command: ", command)
-			// エラー応答をクライアントに返します。
-			writer.Write(Value{typ: "error", str: fmt.Sprintf("ERR unknown command '%s'", command)})
-			continue
-		}
-
-		// 書き込みコマンド（SET, HSETなど）の場合、AOFファイルにRESP形式で追記します。
-		if command == "SET" || command == "HSET" {
-			// 永続化が必要なコマンドのみを書き込みます。
-			if err := aof.Write(value); err != nil {
-				fmt.Println("AOF Write error:", err)
-				// AOFへの書き込み失敗時も、コマンド自体は実行されたものとして進めます。
-			}
+			fmt.Printf("AOF Read: Invalid command '%s' found. Skipping.\n", command)
+			return
 		}
 
-		// ハンドラー関数を実行し、引数（args）を渡して、結果（RESP Value）を受け取ります。
-		result := handler(args)
-
-		// 実行結果（Value）を Writer.Write() で RESP バイト列に変換し、クライアントに送信します。
-		writer.Write(result)
-	}
+		// ハンドラーを実行し、メモリ上のデータストアを再構築します。
+		// この処理ではクライアントへの応答は不要なので結果は無視します。
+		cmd.proc(fakeClient, args)
+	})
 }
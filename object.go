@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// ------------------------------
+// OBJECT コマンド
+// ------------------------------
+
+// objectCommand: OBJECT ENCODING key / OBJECT IDLETIME key
+// キーの値のオブジェクト（db.go の Object）の内部情報を返します。
+//   - ENCODING: エンコーディング（内部表現）の名前。セットが intset かハッシュテーブルか、などを確認できます
+//   - IDLETIME: 最後にアクセスされてからの秒数
+//
+// どちらもキーの最終アクセス時刻を更新しないよう、lookupKeyRead ではなく直接キースペースを参照します。
+func objectCommand(c *Client, args []Value) Value {
+	if len(args) < 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'object' command"}
+	}
+
+	subcommand := strings.ToUpper(args[0].bulk)
+	if (subcommand != "ENCODING" && subcommand != "IDLETIME") || len(args) != 2 {
+		return Value{typ: "error", str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.", args[0].bulk)}
+	}
+
+	key := args[1].bulk
+	db.expireIfNeeded(key)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, ok := db.dict[key]
+	if !ok || db.isExpired(key) {
+		return Value{typ: "null"}
+	}
+
+	if subcommand == "ENCODING" {
+		return Value{typ: "bulk", bulk: o.encoding}
+	}
+	return Value{typ: "integer", num: int((mstime() - atomic.LoadInt64(&o.lru)) / 1000)}
+}
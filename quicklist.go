@@ -0,0 +1,347 @@
+package main
+
+import "slices"
+
+// ====================================================================
+// クイックリスト（quicklist）
+// ====================================================================
+//
+// Redisのリスト型は、quicklist というデータ構造で保存されます。
+// quicklist は「小さな配列（Redisでは listpack）」をノードとする双方向連結リストです。
+//
+//	head                                                  tail
+//	[a b c d] <-> [e f g h] <-> [i j k l] <-> [m n]
+//
+//   - 単純な連結リスト（要素ごとにノードを作る）は、要素ごとにポインタを2つ持つためメモリの無駄が多く、
+//     要素がメモリ上に散らばるのでキャッシュの効率も悪くなります。
+//   - 1つの大きな配列は、先頭への追加や途中への挿入のたびに全要素をコピーする必要があります。
+//
+// quicklist は両者の中間で、ノードごとの配列を小さく（最大 quicklistFill 個）保つことで、
+// 先頭・末尾への追加は O(1)、途中への挿入・削除も1つのノードの中のコピーだけで済むようにしています。
+
+// quicklistFill: 1つのノードに入れる要素の最大数です（Redisの list-max-listpack-size に相当します）。
+const quicklistFill = 128
+
+// quicklistNode構造体: quicklist の1つのノードです。要素は entries にまとめて保存されます。
+type quicklistNode struct {
+	prev, next *quicklistNode
+	entries    []string
+}
+
+// quicklist構造体: リスト型の値です。
+type quicklist struct {
+	head, tail *quicklistNode
+	count      int // 全ノードの要素数の合計
+	nodes      int // ノードの数（Redisの ql->len）。insertNodeAfter と unlinkNode で更新します
+}
+
+// newQuicklist: 空の quicklist を作成します。
+func newQuicklist() *quicklist {
+	return &quicklist{}
+}
+
+// Len: 要素数を返します。
+func (ql *quicklist) Len() int {
+	return ql.count
+}
+
+// NodeCount: ノードの数を返します。
+func (ql *quicklist) NodeCount() int {
+	return ql.nodes
+}
+
+// Dup: すべての要素をコピーした新しい quicklist を返します（ノードの分け方も元と同じになります）。
+func (ql *quicklist) Dup() *quicklist {
+	dup := newQuicklist()
+	for node := ql.head; node != nil; node = node.next {
+		dup.insertNodeAfter(dup.tail, &quicklistNode{entries: slices.Clone(node.entries)})
+	}
+	dup.count = ql.count
+	return dup
+}
+
+// PushHead: 先頭に要素を追加します。
+func (ql *quicklist) PushHead(value string) {
+	if ql.head == nil || len(ql.head.entries) >= quicklistFill {
+		ql.insertNodeAfter(nil, &quicklistNode{})
+	}
+	// 先頭への追加はノードの中でのコピーが必要ですが、ノードは小さいので高速です。
+	ql.head.entries = append(ql.head.entries, "")
+	copy(ql.head.entries[1:], ql.head.entries)
+	ql.head.entries[0] = value
+	ql.count++
+}
+
+// PushTail: 末尾に要素を追加します。
+func (ql *quicklist) PushTail(value string) {
+	if ql.tail == nil || len(ql.tail.entries) >= quicklistFill {
+		ql.insertNodeAfter(ql.tail, &quicklistNode{})
+	}
+	ql.tail.entries = append(ql.tail.entries, value)
+	ql.count++
+}
+
+// PopHead: 先頭の要素を取り出します。リストが空の場合は false を返します。
+func (ql *quicklist) PopHead() (string, bool) {
+	if ql.head == nil {
+		return "", false
+	}
+	value := ql.head.entries[0]
+	ql.deleteEntry(ql.head, 0)
+	return value, true
+}
+
+// PopTail: 末尾の要素を取り出します。リストが空の場合は false を返します。
+func (ql *quicklist) PopTail() (string, bool) {
+	if ql.tail == nil {
+		return "", false
+	}
+	value := ql.tail.entries[len(ql.tail.entries)-1]
+	ql.deleteEntry(ql.tail, len(ql.tail.entries)-1)
+	return value, true
+}
+
+// Index: index 番目の要素を返します。負の index は末尾からの位置です（-1 が最後の要素）。
+func (ql *quicklist) Index(index int) (string, bool) {
+	node, offset := ql.locate(index)
+	if node == nil {
+		return "", false
+	}
+	return node.entries[offset], true
+}
+
+// Set: index 番目の要素を置き換えます。範囲外の場合は false を返します。
+func (ql *quicklist) Set(index int, value string) bool {
+	node, offset := ql.locate(index)
+	if node == nil {
+		return false
+	}
+	node.entries[offset] = value
+	return true
+}
+
+// Insert: index 番目の位置に要素を挿入します（index == Len() なら末尾に追加します）。
+// 挿入先のノードが満杯の場合は、ノードを2つに分割してから挿入します。
+func (ql *quicklist) Insert(index int, value string) {
+	if index >= ql.count {
+		ql.PushTail(value)
+		return
+	}
+	if index <= 0 {
+		ql.PushHead(value)
+		return
+	}
+
+	node, offset := ql.locate(index)
+	if len(node.entries) >= quicklistFill {
+		// ノードの後半を新しいノードに移します。
+		half := len(node.entries) / 2
+		newNode := &quicklistNode{entries: append([]string(nil), node.entries[half:]...)}
+		node.entries = node.entries[:half:half]
+		ql.insertNodeAfter(node, newNode)
+		if offset >= half {
+			node, offset = newNode, offset-half
+		}
+	}
+
+	node.entries = append(node.entries, "")
+	copy(node.entries[offset+1:], node.entries[offset:])
+	node.entries[offset] = value
+	ql.count++
+}
+
+// Range: start 番目から end 番目まで（両端を含む）の要素を返します。
+// インデックスは 0 以上 Len() 未満に収まっている必要があります。
+func (ql *quicklist) Range(start, end int) []string {
+	result := make([]string, 0, end-start+1)
+	node, offset := ql.locate(start)
+	for node != nil && len(result) < end-start+1 {
+		result = append(result, node.entries[offset])
+		offset++
+		if offset >= len(node.entries) {
+			node, offset = node.next, 0
+		}
+	}
+	return result
+}
+
+// DeleteRange: start 番目から count 個の要素を削除します。
+// ノード単位でまとめて切り詰めるので、1つずつ削除するより高速です。
+func (ql *quicklist) DeleteRange(start, count int) {
+	node, offset := ql.locate(start)
+	for node != nil && count > 0 {
+		next := node.next
+		n := min(count, len(node.entries)-offset)
+		node.entries = append(node.entries[:offset], node.entries[offset+n:]...)
+		ql.count -= n
+		count -= n
+		if len(node.entries) == 0 {
+			ql.unlinkNode(node)
+		}
+		node, offset = next, 0
+	}
+}
+
+// Remove: value と等しい要素を最大 count 個削除し、削除した数を返します。
+// count が 0 なら等しい要素をすべて、正なら先頭から、負なら末尾から |count| 個を削除します（LREM の動作です）。
+func (ql *quicklist) Remove(value string, count int) int {
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	removed := 0
+	fromTail := count < 0
+
+	node := ql.head
+	if fromTail {
+		node = ql.tail
+	}
+	for node != nil && (limit == 0 || removed < limit) {
+		next := node.next
+		if fromTail {
+			next = node.prev
+		}
+
+		// ノードの中の配列を、等しい要素を取り除いた形に詰め直します。
+		kept := node.entries[:0]
+		if fromTail {
+			// 末尾から削除する場合は、ノードの中も後ろから調べます。
+			keep := make([]bool, len(node.entries))
+			for i := len(node.entries) - 1; i >= 0; i-- {
+				keep[i] = node.entries[i] != value || (limit != 0 && removed >= limit)
+				if !keep[i] {
+					removed++
+				}
+			}
+			for i, e := range node.entries {
+				if keep[i] {
+					kept = append(kept, e)
+				}
+			}
+		} else {
+			for _, e := range node.entries {
+				if e == value && (limit == 0 || removed < limit) {
+					removed++
+					continue
+				}
+				kept = append(kept, e)
+			}
+		}
+		ql.count -= len(node.entries) - len(kept)
+		node.entries = kept
+		if len(node.entries) == 0 {
+			ql.unlinkNode(node)
+		}
+		node = next
+	}
+	return removed
+}
+
+// Iterator: index 番目の要素から始まるイテレーターを返します。forward が false の場合は先頭に向かって進みます。
+func (ql *quicklist) Iterator(index int, forward bool) *quicklistIter {
+	node, offset := ql.locate(index)
+	return &quicklistIter{node: node, offset: offset, forward: forward}
+}
+
+// quicklistIter構造体: quicklist の要素を順番に読むためのイテレーターです。
+type quicklistIter struct {
+	node    *quicklistNode
+	offset  int
+	forward bool
+}
+
+// Next: 次の要素を返します。要素がなくなった場合は false を返します。
+func (it *quicklistIter) Next() (string, bool) {
+	if it.node == nil {
+		return "", false
+	}
+	value := it.node.entries[it.offset]
+	if it.forward {
+		it.offset++
+		if it.offset >= len(it.node.entries) {
+			it.node, it.offset = it.node.next, 0
+		}
+	} else {
+		it.offset--
+		if it.offset < 0 {
+			it.node = it.node.prev
+			if it.node != nil {
+				it.offset = len(it.node.entries) - 1
+			}
+		}
+	}
+	return value, true
+}
+
+// locate: index 番目の要素があるノードと、ノードの中の位置を返します。範囲外の場合は nil を返します。
+// 先頭と末尾の近い方からノードをたどるので、ノードの数を N とすると O(N/2) で見つかります。
+func (ql *quicklist) locate(index int) (*quicklistNode, int) {
+	if index < 0 {
+		index += ql.count
+	}
+	if index < 0 || index >= ql.count {
+		return nil, 0
+	}
+
+	if index < ql.count/2 {
+		for node := ql.head; node != nil; node = node.next {
+			if index < len(node.entries) {
+				return node, index
+			}
+			index -= len(node.entries)
+		}
+	} else {
+		// 末尾からの位置（0 が最後の要素）に変換してたどります。
+		rindex := ql.count - 1 - index
+		for node := ql.tail; node != nil; node = node.prev {
+			if rindex < len(node.entries) {
+				return node, len(node.entries) - 1 - rindex
+			}
+			rindex -= len(node.entries)
+		}
+	}
+	return nil, 0
+}
+
+// deleteEntry: ノードの offset 番目の要素を削除します。ノードが空になった場合はノードも取り除きます。
+func (ql *quicklist) deleteEntry(node *quicklistNode, offset int) {
+	node.entries = append(node.entries[:offset], node.entries[offset+1:]...)
+	ql.count--
+	if len(node.entries) == 0 {
+		ql.unlinkNode(node)
+	}
+}
+
+// insertNodeAfter: prev の後ろに新しいノードをつなぎます。prev が nil の場合は先頭につなぎます。
+func (ql *quicklist) insertNodeAfter(prev, node *quicklistNode) {
+	node.prev = prev
+	if prev == nil {
+		node.next = ql.head
+		ql.head = node
+	} else {
+		node.next = prev.next
+		prev.next = node
+	}
+	if node.next != nil {
+		node.next.prev = node
+	} else {
+		ql.tail = node
+	}
+	ql.nodes++
+}
+
+// unlinkNode: ノードを連結リストから取り除きます。
+func (ql *quicklist) unlinkNode(node *quicklistNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		ql.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		ql.tail = node.prev
+	}
+	node.prev, node.next = nil, nil
+	ql.nodes--
+}
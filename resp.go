@@ -2,9 +2,13 @@ package main // プログラムは「main」パッケージから実行されま
 
 import (
 	"bufio"   // バッファリングされたI/O（入出力）を提供します。効率的な読み取りのために使われます。
+	"bytes"   // バイト列のバッファ（bytes.Buffer）を提供します。バルク文字列の読み取りに使います。
+	"errors"  // エラーの種類を判定する（errors.As）ために使います。
 	"fmt"     // フォーマットされたI/O、主にデバッグやエラーメッセージの出力に使われます。
 	"io"      // I/Oプリミティブ（基本的な入出力操作）を提供します。`io.Reader`などで使います。
+	"math"    // 浮動小数点数の無限大（inf）や NaN を扱うために使います。
 	"strconv" // 文字列と基本的なデータ型（数値など）の間で変換を行います。
+	"strings" // 文字列操作（インラインコマンドの行末の除去など）に使います。
 )
 
 // RESPプロトコルで使用される型を示す定数です。
@@ -17,47 +21,105 @@ const (
 	ARRAY   = '*' // Arrays（配列）のプレフィックス
 )
 
+// RESP3 で追加された型を示す定数です。
+// これらは HELLO 3 でプロトコルを切り替えたクライアントとの間でのみ使われます。
+const (
+	NULL      = '_' // Null（RESP2 の $-1 と *-1 を1つにまとめたもの）
+	BOOLEAN   = '#' // Booleans（#t または #f）
+	DOUBLE    = ',' // Doubles（浮動小数点数）
+	BIGNUMBER = '(' // Big numbers（64ビットに収まらない整数）
+	BLOBERROR = '!' // Blob errors（長さ情報を持つエラー）
+	VERBATIM  = '=' // Verbatim strings（"txt:" のような形式情報付きの文字列）
+	MAP       = '%' // Maps（キーと値のペアの集まり）
+	SET       = '~' // Sets（重複のない要素の集まり）
+	ATTRIBUTE = '|' // Attributes（応答に付随する補足情報のマップ）
+	PUSH      = '>' // Push（サーバーから一方的に送られるデータ）
+)
+
 // RESPでやり取りされるデータを格納するための構造体（struct）です。
 // RESPは様々なデータ型を持つため、それらをまとめて保持できるようにしています。
+// RESP3 の型は次のように格納します。
+//   - "map": array にキーと値を交互に格納（[k1, v1, k2, v2, ...]）
+//   - "set", "push": array に要素を格納
+//   - "double": dbl に値を格納
+//   - "boolean": num に 1（true）または 0（false）を格納
+//   - "bignum": str に10進数の文字列を格納
+//   - "verbatim": str に形式（"txt" など3文字）、bulk に本文を格納
 type Value struct {
-	typ   string  // データの種類（例: "array", "bulk" など）
+	typ   string  // データの種類（"string", "error", "integer", "bulk", "array", "null", "nullarray" と RESP3 の型）
 	str   string  // Simple StringやErrorなどの文字列データ用
 	num   int     // Integerなどの数値データ用
 	bulk  string  // Bulk Stringなどのバルクデータ用
 	array []Value // Array型の場合、要素（Valueの配列）を格納
+	dbl   float64 // Double型の数値データ用
+	attrs []Value // この値に付随する属性（Attribute型、キーと値を交互に格納）
 }
 
 // RESPのパーサー全体を管理するための構造体です。（リーダー側）
 type Resp struct {
 	reader *bufio.Reader // データを効率的に読み取るためのバッファリングされたリーダー
+
+	// 悪意のある（または壊れた）入力から身を守るための上限値です。
+	maxBulkLen      int // バルク文字列の最大バイト数（proto-max-bulk-len）
+	maxMultibulkLen int // 配列などの集約型の最大要素数
+}
+
+// パーサーの上限値のデフォルトです（Redisのデフォルト値に合わせています）。
+const (
+	defaultMaxBulkLen      = 512 * 1024 * 1024 // 512MB
+	defaultMaxMultibulkLen = math.MaxInt32     // 約21億要素
+	maxInlineLen           = 64 * 1024         // インラインコマンドや1行の最大バイト数（64KB）
+)
+
+// ProtocolError: 受信したデータがRESPの形式に従っていない場合のエラーです。
+// サーバーはこのエラーを受け取ると、クライアントに "-ERR Protocol error: ..." を返して接続を閉じます。
+// 形式が崩れたデータの後ろは、どこからが次のコマンドなのか判断できないためです。
+type ProtocolError struct {
+	msg string // エラーの内容（例: "invalid bulk length"）
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.msg
 }
 
 // Resp構造体の新しいインスタンスを作成し、初期化するコンストラクタ関数です。
 // rd (io.Reader) は、データが流れてくる元（例: ネットワーク接続）です。
 func NewResp(rd io.Reader) *Resp {
 	// io.Readerを bufio.NewReader でラップ（包んで）し、バッファリングされたリーダーを作成します。
-	return &Resp{reader: bufio.NewReader(rd)}
+	return &Resp{
+		reader:          bufio.NewReader(rd),
+		maxBulkLen:      defaultMaxBulkLen,
+		maxMultibulkLen: defaultMaxMultibulkLen,
+	}
 }
 
 // 一行全体を読み取るためのメソッド（Respに紐づいた関数）です。
-// RESPプロトコルでは、データは通常 '\r\n' (CRLF) で区切られています。
+// RESPプロトコルでは、行は必ず '\r\n' (CRLF) で終わります。
+// 行末が '\n' だけの場合や、行が長すぎる（maxInlineLen を超える）場合は ProtocolError を返します。
 func (r *Resp) readLine() (line []byte, n int, err error) {
-	// 無限ループでバイトを一つずつ読み込みます。
 	for {
-		b, err := r.reader.ReadByte() // リーダーから次の1バイトを読み込みます。
-		if err != nil {
-			return nil, 0, err // エラーがあれば即座に返します。
+		// '\n' が見つかるまで読み込みます。ReadSlice はバッファが一杯になると ErrBufferFull を返すので、
+		// その場合はここまでの内容を保存して続きを読みます。
+		chunk, err := r.reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		n += len(chunk)
+		if len(line) > maxInlineLen {
+			return nil, n, &ProtocolError{msg: "too big line"}
 		}
-		n += 1                 // 読み込んだバイト数をカウントします。
-		line = append(line, b) // 読み込んだバイトを行の末尾に追加します。
-		// 行の末尾2バイトが '\r' (CR) であれば、CRLF（'\r\n'）の読み込みが完了したと判断し、ループを抜けます。
-		// 注意: このコードは '\r' をチェックしていますが、実際には '\r\n' をチェックすべきです。
-		// この実装では、'\r' の次に ReadByte で '\n' が読み込まれることを期待しています（ただし、この関数内では '\r' しかチェックしていません）。
-		// 正確には行の末尾が '\r\n' であるかをチェックする必要がありますが、このコードのロジックに従います。
-		if len(line) >= 2 && line[len(line)-2] == '\r' {
-			break
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, n, err
 		}
+		break
 	}
+
+	// '\n' の直前が '\r' であることを確認します。
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, n, &ProtocolError{msg: "expected CRLF at end of line"}
+	}
+
 	// 行全体から末尾の '\r\n' (CRLF) を除くために、最後の2バイト（'\r'と'\n'）を除去して返します。
 	return line[:len(line)-2], n, nil
 }
@@ -75,15 +137,150 @@ func (r *Resp) readInteger() (x int, n int, err error) {
 	i64, err := strconv.ParseInt(string(line), 10, 64)
 	if err != nil {
 		// 変換に失敗した場合（例: 数字以外の文字が含まれていた場合）
-		return 0, n, err
+		return 0, n, &ProtocolError{msg: fmt.Sprintf("invalid integer '%s'", line)}
 	}
 	// 64ビット整数を int 型に変換して返します。
 	return int(i64), n, nil
 }
 
-// RESPデータの最初のバイトを読み取り、対応する型に応じてパース（解析）関数を呼び出します。
-// これがデータの読み取りを開始するメインのエントリポイントです。
+// 配列やバルク文字列の「長さ」を読み取り、-1（Null）以上 max 以下であることを検証します。
+// 範囲外の値や数値でない値の場合は、msg を内容とする ProtocolError を返します。
+// これにより、クライアントが巨大な長さや負の長さを送ってきても、メモリを大量に確保することはありません。
+func (r *Resp) readLength(max int, msg string) (int, error) {
+	n, _, err := r.readInteger()
+	if err != nil {
+		var perr *ProtocolError
+		if errors.As(err, &perr) {
+			return 0, &ProtocolError{msg: msg}
+		}
+		return 0, err
+	}
+	if n < -1 || n > max {
+		return 0, &ProtocolError{msg: msg}
+	}
+	return n, nil
+}
+
+// ReadCommand: クライアントから送られてきたコマンドを1つ読み取ります（Redisの processMultibulkBuffer / processInlineBuffer）。
+// Redisと同じく、最初のバイトが '*' であれば Bulk String の配列として、そうでなければインラインコマンドとして解析します。
+// そのため、+PING\r\n や :1\r\n のような配列以外のRESPデータも、1行のインラインコマンド（"+PING" など）として扱われ、
+// 存在しないコマンドとしてエラーが返ります。
+// 配列の要素は Bulk String だけを受け付けます（readMultibulk を参照）。要素の中に配列を入れ子にすることはできないので、
+// 入れ子を深くしたデータを送られても、スタックを使い果たすことはありません。
+// 要素数が 0 以下の配列（*0\r\n や *-1\r\n）は、要素が空の配列として返します（呼び出し側で無視します）。
+func (r *Resp) ReadCommand() (Value, error) {
+	b, err := r.reader.Peek(1)
+	if err != nil {
+		return Value{}, err
+	}
+	if b[0] != ARRAY {
+		return r.readInline()
+	}
+	r.reader.ReadByte()
+	return r.readMultibulk()
+}
+
+// readMultibulk: '*' の後に続く、Bulk String だけを要素とする配列を読み取ります。
+// クライアントのコマンドとAOFのコマンドは必ずこの形なので、readArray と違って要素を再帰的には読み取りません。
+// '$' 以外で始まる要素や、Null Bulk String（$-1\r\n）の要素は ProtocolError になります。
+func (r *Resp) readMultibulk() (Value, error) {
+	count, err := r.readLength(r.maxMultibulkLen, "invalid multibulk length")
+	if err != nil {
+		return Value{}, err
+	}
+
+	v := Value{typ: "array", array: make([]Value, 0)}
+	for i := 0; i < count; i++ {
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return v, err
+		}
+		if b != BULK {
+			return v, &ProtocolError{msg: fmt.Sprintf("expected '$', got '%s'", string(b))}
+		}
+		val, err := r.readBulk()
+		if err != nil {
+			return v, err
+		}
+		if val.typ == "null" {
+			return v, &ProtocolError{msg: "invalid bulk length"}
+		}
+		v.array = append(v.array, val)
+	}
+	return v, nil
+}
+
+// Read: RESPのデータを1つ読み取ります。サーバーからの応答のような、任意の型のデータを読み取るためのものです。
+// 最初のバイトがRESPの型を示す文字であればRESPとして解析し、そうでなければインラインコマンドとして解析します。
+// インラインコマンドとは、telnet や nc で「SET key value」のように直接入力された1行のコマンドのことです。
+// 配列などの要素を再帰的に読み取るため、入れ子の深さに制限がありません。クライアントからのコマンドは ReadCommand で読み取ります。
 func (r *Resp) Read() (Value, error) {
+	// 最初の1バイトを、読み進めずに覗き見します（Peek）。
+	b, err := r.reader.Peek(1)
+	if err != nil {
+		return Value{}, err
+	}
+
+	if !isRespType(b[0]) {
+		return r.readInline()
+	}
+	return r.readValue()
+}
+
+// isRespType: RESP2/RESP3 の型を示す先頭の文字かどうかを判定します。
+func isRespType(b byte) bool {
+	switch b {
+	case STRING, ERROR, INTEGER, BULK, ARRAY,
+		NULL, BOOLEAN, DOUBLE, BIGNUMBER, BLOBERROR, VERBATIM, MAP, SET, ATTRIBUTE, PUSH:
+		return true
+	}
+	return false
+}
+
+// インラインコマンドを1行読み取り、RESPの配列と同じ形（Bulk String の配列）に変換します。
+// 引数は空白で区切り、ダブルクォート・シングルクォートとエスケープも扱います（規則は splitArgs と同じです）。
+// 例: SET name "hello world"\r\n → ["SET", "name", "hello world"]
+func (r *Resp) readInline() (Value, error) {
+	for {
+		// '\n' までを1行として読み取ります。nc では '\n' だけ、telnet では "\r\n" が行末になります。
+		var buf []byte
+		for {
+			chunk, err := r.reader.ReadSlice('\n')
+			buf = append(buf, chunk...)
+			if len(buf) > maxInlineLen {
+				return Value{}, &ProtocolError{msg: "too big inline request"}
+			}
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil {
+				return Value{}, err
+			}
+			break
+		}
+		line := strings.TrimRight(string(buf), "\r\n")
+
+		args, err := splitArgs(line)
+		if err != nil {
+			return Value{}, &ProtocolError{msg: "unbalanced quotes in request"}
+		}
+
+		// 空行は（Redisと同様に）無視して次の行を読みます。
+		if len(args) == 0 {
+			continue
+		}
+
+		v := Value{typ: "array", array: make([]Value, 0, len(args))}
+		for _, arg := range args {
+			v.array = append(v.array, Value{typ: "bulk", bulk: arg})
+		}
+		return v, nil
+	}
+}
+
+// RESPデータの最初のバイトを読み取り、対応する型に応じてパース（解析）関数を呼び出します。
+// 配列などの要素も、このメソッドで再帰的に読み取ります。
+func (r *Resp) readValue() (Value, error) {
 	// データの型を示す最初の1バイトを読み取ります（例: '*'、'$' など）。
 	_type, err := r.reader.ReadByte()
 	if err != nil {
@@ -97,31 +294,178 @@ func (r *Resp) Read() (Value, error) {
 		return r.readArray() // '*' の場合、配列のパース関数を呼び出します。
 	case BULK:
 		return r.readBulk() // '$' の場合、バルク文字列のパース関数を呼び出します。
+	case STRING:
+		return r.readSimple("string") // '+' の場合、シンプル文字列として1行を読み取ります。
+	case ERROR:
+		return r.readSimple("error") // '-' の場合、エラーメッセージとして1行を読み取ります。
+	case INTEGER:
+		return r.readIntegerValue() // ':' の場合、整数として1行を読み取ります。
+
+	// --- ここから RESP3 の型 ---
+	case NULL:
+		// '_' の後は CRLF だけが続きます。
+		if _, _, err := r.readLine(); err != nil {
+			return Value{}, err
+		}
+		return Value{typ: "null"}, nil
+	case BOOLEAN:
+		return r.readBoolean()
+	case DOUBLE:
+		return r.readDouble()
+	case BIGNUMBER:
+		return r.readSimple("bignum")
+	case BLOBERROR:
+		// Blob Error は長さ付きのエラーです。読み取った後は通常のエラーと同じように扱います。
+		v, err := r.readBulk()
+		return Value{typ: "error", str: v.bulk}, err
+	case VERBATIM:
+		return r.readVerbatim()
+	case MAP:
+		return r.readAggregate("map", 2)
+	case SET:
+		return r.readAggregate("set", 1)
+	case PUSH:
+		return r.readAggregate("push", 1)
+	case ATTRIBUTE:
+		// 属性は、その直後に続く本来の応答に付随する情報です。
+		// 属性を読み取った後に続けて本来の値を読み取り、その値の attrs に格納します。
+		attrs, err := r.readAggregate("attribute", 2)
+		if err != nil {
+			return Value{}, err
+		}
+		v, err := r.readValue()
+		v.attrs = attrs.array
+		return v, err
 	default:
-		// 未知の型が来た場合は、エラーメッセージを出力し、空のValueを返します。
-		fmt.Printf("Unknown type: %v", string(_type))
-		return Value{}, nil
+		// 未知の型が来た場合は、エラーを返します。
+		// 空のValueを返して処理を続けると、残りのバイト列の区切りがずれてしまい、以降のデータを正しく読めなくなるためです。
+		return Value{}, &ProtocolError{msg: fmt.Sprintf("unknown RESP type '%s'", string(_type))}
 	}
 }
 
+// Buffered: まだ読み取られずにバッファに残っているバイト数を返します。
+// 0 より大きい場合は、クライアントが次のコマンドをすでに送ってきている（パイプライン）ことを意味します。
+func (r *Resp) Buffered() int {
+	return r.reader.Buffered()
+}
+
+// RESPシンプル文字列（+）またはエラー（-）を読み取るためのメソッドです。
+// どちらも型を示す1バイトの後に、CRLF で終わる1行の文字列が続きます。
+// 例: +OK\r\n, -ERR unknown command\r\n
+func (r *Resp) readSimple(typ string) (Value, error) {
+	line, _, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Value{typ: typ, str: string(line)}, nil
+}
+
+// RESP整数（:）を読み取るためのメソッドです。
+// 例: :1000\r\n
+func (r *Resp) readIntegerValue() (Value, error) {
+	n, _, err := r.readInteger()
+	if err != nil {
+		return Value{}, err
+	}
+
+	return Value{typ: "integer", num: n}, nil
+}
+
+// RESP3の真偽値（#）を読み取るためのメソッドです。
+// 例: #t\r\n（true）, #f\r\n（false）
+func (r *Resp) readBoolean() (Value, error) {
+	line, _, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch string(line) {
+	case "t":
+		return Value{typ: "boolean", num: 1}, nil
+	case "f":
+		return Value{typ: "boolean", num: 0}, nil
+	}
+	return Value{}, &ProtocolError{msg: fmt.Sprintf("invalid boolean '%s'", line)}
+}
+
+// RESP3の浮動小数点数（,）を読み取るためのメソッドです。
+// 例: ,3.14\r\n, ,inf\r\n, ,-inf\r\n, ,nan\r\n
+func (r *Resp) readDouble() (Value, error) {
+	line, _, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	// strconv.ParseFloat は "inf" や "nan" も解釈できます。
+	f, err := strconv.ParseFloat(string(line), 64)
+	if err != nil {
+		return Value{}, &ProtocolError{msg: fmt.Sprintf("invalid double '%s'", line)}
+	}
+	return Value{typ: "double", dbl: f}, nil
+}
+
+// RESP3のVerbatim String（=）を読み取るためのメソッドです。
+// データ本体の先頭4バイトは「形式（3文字）+ ':'」です。例: =15\r\ntxt:Some string\r\n
+func (r *Resp) readVerbatim() (Value, error) {
+	v, err := r.readBulk()
+	if err != nil {
+		return Value{}, err
+	}
+	if len(v.bulk) < 4 || v.bulk[3] != ':' {
+		return Value{}, &ProtocolError{msg: "invalid verbatim string"}
+	}
+
+	return Value{typ: "verbatim", str: v.bulk[:3], bulk: v.bulk[4:]}, nil
+}
+
+// RESP3の集約型（Map, Set, Push, Attribute）を読み取るためのメソッドです。
+// 配列と同じく、型を示す1バイトの後に「要素数」が続きます。
+// Map と Attribute の要素数はキーと値のペアの数なので、実際に読み取る値の数は perEntry（=2）倍になります。
+func (r *Resp) readAggregate(typ string, perEntry int) (Value, error) {
+	count, err := r.readLength(r.maxMultibulkLen/perEntry, "invalid multibulk length")
+	if err != nil {
+		return Value{}, err
+	}
+	if count < 0 {
+		return Value{}, &ProtocolError{msg: "invalid multibulk length"}
+	}
+
+	v := Value{typ: typ, array: make([]Value, 0)}
+	for i := 0; i < count*perEntry; i++ {
+		val, err := r.readValue()
+		if err != nil {
+			return v, err
+		}
+		v.array = append(v.array, val)
+	}
+
+	return v, nil
+}
+
 // RESP配列（Array）を読み取るためのメソッドです。
 // 配列は '*' の後に要素数、そして各要素のデータが続きます。
 func (r *Resp) readArray() (Value, error) {
 	v := Value{}
 	v.typ = "array" // Valueの型を "array" に設定します。
 
-	// 配列の要素数（長さ）を読み取ります。これは readInteger でパースされます。
-	len, _, err := r.readInteger()
+	// 配列の要素数（長さ）を読み取ります。要素数は -1 から maxMultibulkLen までに制限します。
+	len, err := r.readLength(r.maxMultibulkLen, "invalid multibulk length")
 	if err != nil {
 		return v, err
 	}
 
+	// 要素数が -1 の場合は Null Array（*-1\r\n）です。要素は続きません。
+	if len < 0 {
+		return Value{typ: "nullarray"}, nil
+	}
+
 	// 配列の要素を格納するためのスライスを、容量0で作成します。
 	v.array = make([]Value, 0)
 	// 要素数分だけループし、各要素を再帰的に読み取ります。
 	for i := 0; i < len; i++ {
 		// 配列の各要素は、再び Read() メソッドでパースされます（再帰的な処理）。
-		val, err := r.Read()
+		val, err := r.readValue()
 		if err != nil {
 			return v, err
 		}
@@ -139,25 +483,39 @@ func (r *Resp) readBulk() (Value, error) {
 	v := Value{}
 	v.typ = "bulk" // Valueの型を "bulk" に設定します。
 
-	// バルク文字列のデータの長さ（バイト数）を読み取ります。
-	len, _, err := r.readInteger()
+	// バルク文字列のデータの長さ（バイト数）を読み取ります。長さは -1 から maxBulkLen までに制限します。
+	len, err := r.readLength(r.maxBulkLen, "invalid bulk length")
 	if err != nil {
 		return v, err
 	}
 
-	// 読み込む長さ分のバイトスライスを作成します。
-	bulk := make([]byte, len)
-
-	// リーダーから、指定された長さ（len）のデータを直接読み込みます。
-	// この読み込みで、データ本体（文字列）が bulk スライスに格納されます。
-	r.reader.Read(bulk)
+	// 長さが -1 の場合は Null Bulk String（$-1\r\n）です。データ本体も末尾の CRLF も続きません。
+	if len < 0 {
+		return Value{typ: "null"}, nil
+	}
 
-	// バイトスライスを文字列に変換し、Valueに格納します。
-	v.bulk = string(bulk)
+	// データ本体と末尾の CRLF をまとめて読み込みます。
+	// io.CopyN は、データが実際に届いた分だけバッファを広げていきます。
+	// 最初に make([]byte, len) で確保すると、長さだけを送ってデータを送らないクライアントに
+	// 巨大なメモリを確保させられてしまうためです。
+	// また、r.reader.Read と違い、指定したバイト数をすべて読み終えるまで読み込みを続けます
+	// （Read は届いている分だけを返すことがあり、値が途中で切れてしまいます）。
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r.reader, int64(len)+2); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return v, err
+	}
+	bulk := buf.Bytes()
 
 	// Bulk String のデータ本体の後には、必ず末尾の CRLF が続きます。
-	// この readLine() は、その CRLF を読み捨て（スキップ）するために呼び出されます。
-	r.readLine()
+	if bulk[len] != '\r' || bulk[len+1] != '\n' {
+		return v, &ProtocolError{msg: "expected CRLF after bulk string"}
+	}
+
+	// バイトスライスを文字列に変換し、Valueに格納します。
+	v.bulk = string(bulk[:len])
 
 	return v, nil
 }
@@ -166,20 +524,87 @@ func (r *Resp) readBulk() (Value, error) {
 // RESPシリアライザー (Marshal/Writer)
 // ====================================================================
 
-// Value構造体をRESP形式のバイト列（[]byte）に変換（シリアライズ）するメインメソッドです。
+// Value構造体をRESP2形式のバイト列（[]byte）に変換（シリアライズ）するメインメソッドです。
+// AOFファイルへの書き込みや、RESP2 を使うクライアントへの応答に使います。
 func (v Value) Marshal() []byte {
+	return v.marshal(2)
+}
+
+// Value構造体をRESP3形式のバイト列に変換します（HELLO 3 を送ったクライアントへの応答に使います）。
+func (v Value) MarshalResp3() []byte {
+	return v.marshal(3)
+}
+
+// 指定されたプロトコルバージョン（2 または 3）でValueをシリアライズします。
+// RESP3 の型（Map や Double など）は、RESP2 では Redis と同じ規則で RESP2 の型に置き換えて送ります。
+//   - Map, Set, Push → Array（Map はキーと値を交互に並べた配列）
+//   - Double, Big number, Verbatim string → Bulk String
+//   - Boolean → Integer（1 または 0）
+func (v Value) marshal(proto int) []byte {
+	var bytes []byte
+
+	// 属性は RESP3 でのみ送信します（RESP2 には対応する型がないため省略します）。
+	if proto == 3 && len(v.attrs) > 0 {
+		bytes = append(bytes, Value{typ: "attribute", array: v.attrs}.marshalAggregate(ATTRIBUTE, len(v.attrs)/2, proto)...)
+	}
+
 	// Valueの型に応じて、適切なマーシャリング関数を呼び出します。
 	switch v.typ {
 	case "array":
-		return v.marshalArray()
+		return append(bytes, v.marshalArray(proto)...)
 	case "bulk":
-		return v.marshalBulk()
+		return append(bytes, v.marshalBulk()...)
 	case "string":
-		return v.marshalString()
+		return append(bytes, v.marshalString()...)
+	case "integer":
+		return append(bytes, v.marshalInteger()...)
 	case "null":
-		return v.marshallNull()
+		if proto == 3 {
+			return append(bytes, "_\r\n"...)
+		}
+		return append(bytes, v.marshallNull()...)
+	case "nullarray":
+		if proto == 3 {
+			return append(bytes, "_\r\n"...)
+		}
+		return append(bytes, v.marshalNullArray()...)
 	case "error":
-		return v.marshallError()
+		return append(bytes, v.marshallError()...)
+	case "map":
+		if proto == 3 {
+			return append(bytes, v.marshalAggregate(MAP, len(v.array)/2, proto)...)
+		}
+		return append(bytes, v.marshalAggregate(ARRAY, len(v.array), proto)...)
+	case "set":
+		if proto == 3 {
+			return append(bytes, v.marshalAggregate(SET, len(v.array), proto)...)
+		}
+		return append(bytes, v.marshalAggregate(ARRAY, len(v.array), proto)...)
+	case "push":
+		if proto == 3 {
+			return append(bytes, v.marshalAggregate(PUSH, len(v.array), proto)...)
+		}
+		return append(bytes, v.marshalAggregate(ARRAY, len(v.array), proto)...)
+	case "double":
+		if proto == 3 {
+			return append(bytes, v.marshalDouble()...)
+		}
+		return append(bytes, Value{typ: "bulk", bulk: formatDouble(v.dbl)}.marshalBulk()...)
+	case "boolean":
+		if proto == 3 {
+			return append(bytes, v.marshalBoolean()...)
+		}
+		return append(bytes, Value{typ: "integer", num: v.num}.marshalInteger()...)
+	case "bignum":
+		if proto == 3 {
+			return append(bytes, v.marshalBigNumber()...)
+		}
+		return append(bytes, Value{typ: "bulk", bulk: v.str}.marshalBulk()...)
+	case "verbatim":
+		if proto == 3 {
+			return append(bytes, v.marshalVerbatim()...)
+		}
+		return append(bytes, Value{typ: "bulk", bulk: v.bulk}.marshalBulk()...)
 	default:
 		// 未知の型の場合は空のバイト列を返します。
 		return []byte{}
@@ -200,6 +625,20 @@ func (v Value) marshalString() []byte {
 	return bytes
 }
 
+// Integer（:）をRESP形式に変換します。
+// 形式: :数値\r\n （例: :1000\r\n, :-1\r\n）
+func (v Value) marshalInteger() []byte {
+	var bytes []byte
+	// 1. プレフィックス ':' を追加
+	bytes = append(bytes, INTEGER)
+	// 2. 数値を10進数の文字列に変換して追加
+	bytes = strconv.AppendInt(bytes, int64(v.num), 10)
+	// 3. 終端の CRLF (\r\n) を追加
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
 // Bulk String（$）をRESP形式に変換します。
 // 形式: $バイト数\r\nデータ本体\r\n
 func (v Value) marshalBulk() []byte {
@@ -220,7 +659,7 @@ func (v Value) marshalBulk() []byte {
 
 // Array（*）をRESP形式に変換します。
 // 形式: *要素数\r\n[要素1のRESP表現][要素2のRESP表現]...
-func (v Value) marshalArray() []byte {
+func (v Value) marshalArray(proto int) []byte {
 	len := len(v.array) // 配列の要素数を取得
 	var bytes []byte
 	// 1. プレフィックス '*' を追加
@@ -234,12 +673,96 @@ func (v Value) marshalArray() []byte {
 	for i := 0; i < len; i++ {
 		// 各要素（Value）に対して再帰的に Marshal() を呼び出し、RESPバイト列を取得
 		// その結果を全体のバイト列に追加します。
-		bytes = append(bytes, v.array[i].Marshal()...)
+		bytes = append(bytes, v.array[i].marshal(proto)...)
+	}
+
+	return bytes
+}
+
+// RESP3の集約型（Map, Set, Push, Attribute）をRESP形式に変換します。
+// 形式: <prefix>要素数\r\n[要素1][要素2]...
+// Map と Attribute の場合、count はキーと値のペアの数です（v.array の長さの半分）。
+func (v Value) marshalAggregate(prefix byte, count int, proto int) []byte {
+	var bytes []byte
+	bytes = append(bytes, prefix)
+	bytes = strconv.AppendInt(bytes, int64(count), 10)
+	bytes = append(bytes, '\r', '\n')
+
+	for _, elem := range v.array {
+		bytes = append(bytes, elem.marshal(proto)...)
+	}
+
+	return bytes
+}
+
+// RESP3のDouble（,）をRESP形式に変換します。
+// 形式: ,数値\r\n （例: ,3.14\r\n, ,inf\r\n）
+func (v Value) marshalDouble() []byte {
+	var bytes []byte
+	bytes = append(bytes, DOUBLE)
+	bytes = append(bytes, formatDouble(v.dbl)...)
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
+// RESP3のBoolean（#）をRESP形式に変換します。
+// 形式: #t\r\n または #f\r\n
+func (v Value) marshalBoolean() []byte {
+	if v.num != 0 {
+		return []byte("#t\r\n")
 	}
+	return []byte("#f\r\n")
+}
+
+// RESP3のBig number（(）をRESP形式に変換します。
+// 形式: (10進数の数字列\r\n
+func (v Value) marshalBigNumber() []byte {
+	var bytes []byte
+	bytes = append(bytes, BIGNUMBER)
+	bytes = append(bytes, v.str...)
+	bytes = append(bytes, '\r', '\n')
 
 	return bytes
 }
 
+// RESP3のVerbatim string（=）をRESP形式に変換します。
+// 形式: =バイト数\r\n形式:データ本体\r\n （バイト数には「形式:」の4バイトも含みます）
+func (v Value) marshalVerbatim() []byte {
+	format := v.str
+	if format == "" {
+		format = "txt"
+	}
+
+	var bytes []byte
+	bytes = append(bytes, VERBATIM)
+	bytes = strconv.AppendInt(bytes, int64(len(v.bulk)+4), 10)
+	bytes = append(bytes, '\r', '\n')
+	bytes = append(bytes, format...)
+	bytes = append(bytes, ':')
+	bytes = append(bytes, v.bulk...)
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
+// formatDouble: 浮動小数点数を、元の値を復元できる最短の10進数表現に変換します。
+// 無限大と NaN は Redis と同じく "inf", "-inf", "nan" と表します。
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	case f == math.Trunc(f) && math.Abs(f) < 1e17:
+		// 整数の値は、Redisと同じく指数表記にせずに表します（1000000 を "1e+06" としないため）。
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
 // Error（-）をRESP形式に変換します。
 // 形式: -エラーメッセージ\r\n
 func (v Value) marshallError() []byte {
@@ -261,28 +784,43 @@ func (v Value) marshallNull() []byte {
 	return []byte("$-1\r\n")
 }
 
+// Null Array をRESP形式に変換します。
+// 形式: *-1\r\n （要素数が -1 の配列で、値が存在しないことを表します）
+func (v Value) marshalNullArray() []byte {
+	return []byte("*-1\r\n")
+}
+
 // ====================================================================
 // Writer 構造体とメソッド
 // ====================================================================
 
 // RESP応答をネットワーク接続に書き込むための構造体です。
+// 書き込みはバッファリングされます。Write はバッファに溜めるだけなので、
+// 実際に送信するには Flush を呼ぶ必要があります。
+// パイプライン（複数のコマンドをまとめて送る使い方）では、複数の応答を1回の送信（システムコール）にまとめられます。
 type Writer struct {
-	writer io.Writer // 実際にデータを書き込むターゲット（例: net.Conn）
+	writer *bufio.Writer // 実際にデータを書き込むターゲット（例: net.Conn）をバッファリングしたもの
+	proto  int           // 応答に使うプロトコルのバージョン（2 または 3、HELLO で切り替わります）
 }
 
 // Writer構造体の新しいインスタンスを作成するコンストラクタ関数です。
 // w (io.Writer) は、書き出し先のネットワーク接続などです。
 func NewWriter(w io.Writer) *Writer {
-	// io.Writer を保持する Writer オブジェクトを返します。
-	return &Writer{writer: w}
+	// io.Writer をバッファ付きのライターで包んだ Writer オブジェクトを返します。最初は RESP2 で応答します。
+	return &Writer{writer: bufio.NewWriter(w), proto: 2}
+}
+
+// Flush: バッファに溜まっている応答を、すべて書き出し先に送信します。
+func (w *Writer) Flush() error {
+	return w.writer.Flush()
 }
 
-// Value構造体をRESPバイト列に変換し、io.Writerを通じて書き込みます。
+// Value構造体をRESPバイト列に変換し、バッファに書き込みます。
 func (w *Writer) Write(v Value) error {
-	// 1. ValueオブジェクトをRESP形式のバイト列に変換します。
-	var bytes = v.Marshal()
+	// 1. ValueオブジェクトをRESP形式（RESP2 または RESP3）のバイト列に変換します。
+	var bytes = v.marshal(w.proto)
 
-	// 2. io.Writer の Write メソッドを使って、変換したバイト列をネットワークなどに書き込みます。
+	// 2. 変換したバイト列をバッファに書き込みます（バッファが一杯になった場合は、その時点で送信されます）。
 	_, err := w.writer.Write(bytes)
 	if err != nil {
 		// 書き込みエラーが発生した場合はそれを返します。
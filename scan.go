@@ -0,0 +1,132 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ====================================================================
+// カーソルによる走査（HSCAN, SSCAN など）
+// ====================================================================
+//
+// SCAN 系のコマンドは、大きなコレクションを少しずつ返すために「カーソル」を使います。
+// Redisはハッシュテーブルのバケットの番号をカーソルにしていますが、Go のマップは内部のバケットを
+// 外から扱えないため、ここでは各要素のハッシュ値（FNV-1a 32ビット）の順に走査します。
+//   - カーソル 0 は走査の開始、応答のカーソルが 0 なら走査の終了を表します。
+//   - それ以外のカーソルは「ハッシュ値 + 1」で、そのハッシュ値以上の要素から続きを返します。
+//
+// 要素のハッシュ値は、他の要素を追加・削除しても変わりません。そのため Redis の SCAN と同じく、
+// 走査の開始から終了までずっと存在していた要素は、必ず1回以上返されます。
+//
+// ただし、Redisのようにバケットを順にたどるのではなく、呼び出しのたびにコレクションの全要素のハッシュ値を計算し、
+// カーソル以降の要素を並べ替えて選びます。そのため、1回の呼び出しに要素数 N に対して O(N log N) の時間がかかり、
+// 最後まで走査すると全体で O(N²/COUNT) になります（その間、キースペースの読み取りロックを取得したままです）。
+// 要素数の多いコレクションを走査する場合は、COUNT を大きくして呼び出しの回数を減らしてください。
+// 要素数が scanSmallCollection 以下のコレクションは、Redisが listpack や intset で保存した小さなコレクションを
+// 1回ですべて返すのと同じく、カーソルに関係なく1回ですべての要素を返します。
+
+// defaultScanCount: COUNT が指定されなかった場合に、1回で返す要素数の目安です。
+const defaultScanCount = 10
+
+// scanSmallCollection: 1回ですべての要素を返すコレクションの最大の要素数です。
+// Redisが小さなハッシュやセットを listpack で保存する上限（hash-max-listpack-entries と
+// set-max-listpack-entries のデフォルト）と同じ値にしています。
+const scanSmallCollection = 128
+
+// scanOptions構造体: SCAN 系コマンドの引数を解析した結果です。
+type scanOptions struct {
+	cursor  uint64 // 走査を再開する位置
+	pattern string // MATCH で指定されたパターン（指定がなければ空文字列）
+	count   int    // COUNT で指定された要素数の目安
+}
+
+// parseScanOptions: SCAN 系コマンドの cursor [MATCH pattern] [COUNT count] を解析します。
+func parseScanOptions(args []Value) (scanOptions, *Value) {
+	opts := scanOptions{count: defaultScanCount}
+
+	cursor, err := strconv.ParseUint(args[0].bulk, 10, 64)
+	if err != nil {
+		return opts, &Value{typ: "error", str: "ERR invalid cursor"}
+	}
+	opts.cursor = cursor
+
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return opts, &Value{typ: "error", str: "ERR syntax error"}
+		}
+		switch strings.ToUpper(args[i].bulk) {
+		case "MATCH":
+			opts.pattern = args[i+1].bulk
+		case "COUNT":
+			count, err := strconv.Atoi(args[i+1].bulk)
+			if err != nil {
+				return opts, &Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			if count < 1 {
+				return opts, &Value{typ: "error", str: "ERR syntax error"}
+			}
+			opts.count = count
+		default:
+			return opts, &Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	return opts, nil
+}
+
+// scanHash: 走査の順番を決める、要素のハッシュ値を返します。
+func scanHash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// scanMembers: members からカーソルの位置以降の要素を、ハッシュ値の順に count 個程度選びます。
+// 同じハッシュ値の要素は途中で分けずにまとめて返すため、count より多く返すことがあります。
+// MATCH のパターンに一致しない要素は、数には含めますが結果からは取り除きます（Redisと同じ動作です）。
+// 呼び出しのたびに全要素のハッシュ値を計算して並べ替えるため、O(N log N) の時間がかかります（このファイルの先頭を参照）。
+// 戻り値は、次のカーソルと、選んだ要素です。
+func scanMembers(members []string, opts scanOptions) (uint64, []string) {
+	// 小さなコレクションは、ハッシュ値を計算せずにすべての要素を返し、走査を終えます。
+	if len(members) <= scanSmallCollection {
+		var result []string
+		for _, m := range members {
+			if opts.pattern == "" || stringMatch(opts.pattern, m, false) {
+				result = append(result, m)
+			}
+		}
+		return 0, result
+	}
+
+	type entry struct {
+		hash   uint32
+		member string
+	}
+
+	// カーソルの位置以降の要素を、ハッシュ値の順に並べます。
+	var entries []entry
+	for _, m := range members {
+		h := scanHash(m)
+		if opts.cursor == 0 || uint64(h) >= opts.cursor-1 {
+			entries = append(entries, entry{h, m})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].hash < entries[j].hash })
+
+	var result []string
+	i := 0
+	for i < len(entries) && (i < opts.count || entries[i].hash == entries[i-1].hash) {
+		if opts.pattern == "" || stringMatch(opts.pattern, entries[i].member, false) {
+			result = append(result, entries[i].member)
+		}
+		i++
+	}
+
+	// すべての要素を返した場合は、走査の終了を表すカーソル 0 を返します。
+	if i == len(entries) {
+		return 0, result
+	}
+	return uint64(entries[i].hash) + 1, result
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ====================================================================
+// クライアント
+// ====================================================================
+
+// Client構造体: 接続中のクライアント1つ分の状態を保持します。
+type Client struct {
+	id            int64    // クライアントを識別するための連番ID（CLIENT ID に相当）
+	conn          net.Conn // クライアントとのTCP接続（AOF読み込み用の疑似クライアントでは nil）
+	authenticated bool     // AUTH による認証が済んでいるか（requirepass 未設定なら最初から true）
+	proto         int      // 応答に使うRESPのバージョン（2 または 3、HELLO で切り替わります）
+	name          string   // HELLO ... SETNAME で設定されたクライアント名
+
+	// リーダーとライターは接続ごとに1つだけ作り、接続が閉じるまで使い続けます。
+	// コマンドごとに作り直すと、リーダーのバッファに先読みされていた次のコマンド（パイプライン）が失われてしまうためです。
+	reader *Resp   // クライアントからのリクエストを読み取るRESPパーサー
+	writer *Writer // クライアントへの応答を書き込むバッファ付きライター
+
+	// 実行中のコマンドの情報です（call を参照）。
+	cmd         *redisCommand // 実行中のコマンド
+	argv        []Value       // 実行中のコマンド名と引数。AOFにはこれを記録します（rewriteCommand で書き換えられます）
+	preventProp bool          // true なら、実行中のコマンド自体はAOFに記録しません（preventPropagation を参照）
+}
+
+// ====================================================================
+// サーバー
+// ====================================================================
+
+// Server構造体: 待ち受けソケットと、接続中のクライアント一覧を管理します。
+type Server struct {
+	listeners []net.Listener // 新しい接続を受け付けるリスナー（bind で指定したアドレスごとに1つ）
+	aof       *Aof           // 書き込みコマンドを追記するAOF（appendonly no の場合は nil）
+
+	callMu     sync.Mutex // 書き込みコマンドを1つずつ実行するためのMutex（call を参照）
+	propagated []Value    // 実行中の書き込みコマンドが propagate で記録した操作（callMu で保護されます）
+	expired    []Value    // 遅延削除と定期削除が propagateExpire で記録した、期限切れのキーの DEL（callMu で保護されます）
+
+	mu      sync.Mutex        // clients, nextID, closing を保護するためのMutex
+	clients map[int64]*Client // 接続中のクライアント（ID -> Client）
+	nextID  int64             // 次に割り当てるクライアントID
+	closing bool              // シャットダウン処理中かどうか
+
+	wg sync.WaitGroup // クライアント処理ゴルーチンの終了を待つためのWaitGroup
+
+	shutdownCh chan struct{} // SHUTDOWN コマンドからのシャットダウン要求を main に伝えるチャネル
+	closingCh  chan struct{} // シャットダウンの開始時に閉じられるチャネル（BLPOP などで待っているクライアントを起こします）
+}
+
+// シャットダウン時に、処理中のコマンドが終わるのを待つ最大時間です。
+// この時間を過ぎても終わらないクライアントは、接続を強制的に閉じます。
+const shutdownTimeout = 10 * time.Second
+
+// server は現在動作中のサーバーです。SHUTDOWN などのコマンドハンドラーから参照されます。
+var server *Server
+
+// NewServer: リスナーとAOFを受け取り、Server構造体を初期化します。
+func NewServer(listeners []net.Listener, aof *Aof) *Server {
+	return &Server{
+		listeners:  listeners,
+		aof:        aof,
+		clients:    map[int64]*Client{},
+		nextID:     1,
+		shutdownCh: make(chan struct{}, 1),
+		closingCh:  make(chan struct{}),
+	}
+}
+
+// Serve: すべてのリスナーで接続の受け付けを開始し、リスナーが閉じられるまで待ちます。
+func (s *Server) Serve() error {
+	errCh := make(chan error, len(s.listeners))
+	for _, l := range s.listeners {
+		go func() {
+			errCh <- s.acceptLoop(l)
+		}()
+	}
+
+	// すべての accept ループの終了を待ち、最初に発生したエラーを返します。
+	var firstErr error
+	for range s.listeners {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// acceptLoop: 1つのリスナーで接続を受け付けるループ（accept ループ）です。
+// 接続ごとに新しいゴルーチンを起動するので、1つのクライアントの処理中でも次の接続を受け付けられます。
+func (s *Server) acceptLoop(l net.Listener) error {
+	for {
+		// 新しいクライアント接続が来るまでブロックします。
+		conn, err := l.Accept()
+		if err != nil {
+			// リスナーが閉じられた場合は、正常な終了としてループを抜けます。
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			// それ以外のエラー（ファイルディスクリプタの枯渇など）は一時的なものとみなし、
+			// 少し待ってから受け付けを続けます。
+			fmt.Println("Accept error:", err)
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		// 接続数が maxclients に達している場合は、エラーを返して接続を閉じます。
+		if s.NumClients() >= config.getMaxClients() {
+			w := NewWriter(conn)
+			w.Write(Value{typ: "error", str: "ERR max number of clients reached"})
+			w.Flush()
+			conn.Close()
+			continue
+		}
+
+		// クライアントを一覧に登録し、専用のゴルーチンで処理を開始します。
+		c := s.addClient(conn)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleClient(c)
+		}()
+	}
+}
+
+// addClient: 新しい接続にIDを割り当て、接続中クライアントの一覧に追加します。
+func (s *Server) addClient(conn net.Conn) *Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// requirepass が設定されていなければ、接続した時点で認証済みとして扱います。
+	c := &Client{
+		id:            s.nextID,
+		conn:          conn,
+		authenticated: config.getRequirePass() == "",
+		proto:         2,
+		reader:        NewResp(conn),
+		writer:        NewWriter(conn),
+	}
+	s.nextID++
+	s.clients[c.id] = c
+
+	// シャットダウンの開始と入れ違いで受け付けた接続は、最初の読み取りですぐに終了させます。
+	if s.closing {
+		conn.SetReadDeadline(time.Now())
+	}
+	return c
+}
+
+// removeClient: クライアントを一覧から取り除き、接続を閉じます。
+func (s *Server) removeClient(c *Client) {
+	s.mu.Lock()
+	delete(s.clients, c.id)
+	s.mu.Unlock()
+
+	c.conn.Close()
+}
+
+// NumClients: 現在接続中のクライアント数を返します。
+func (s *Server) NumClients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.clients)
+}
+
+// isClosing: シャットダウン処理中であれば true を返します。
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closing
+}
+
+// RequestShutdown: シャットダウンを要求します（SHUTDOWN コマンドから呼ばれます）。
+// 実際の停止処理は main ゴルーチンが ShutdownRequested を受け取ってから行います。
+// コマンドを実行中のゴルーチン自身が Shutdown を呼ぶと、自分自身の終了を待つことになり止まってしまうためです。
+func (s *Server) RequestShutdown() {
+	select {
+	case s.shutdownCh <- struct{}{}:
+	default:
+		// すでに要求済みの場合は何もしません。
+	}
+}
+
+// ShutdownRequested: SHUTDOWN コマンドによる停止要求を受け取るためのチャネルを返します。
+func (s *Server) ShutdownRequested() <-chan struct{} {
+	return s.shutdownCh
+}
+
+// Shutdown: サーバーを安全に停止します。
+//  1. 新しい接続の受け付けを止める（リスナーを閉じる）
+//  2. 各クライアントの読み取り待ちを解除し、実行中のコマンドが応答を返し終わるのを待つ
+//  3. timeout を過ぎても終わらない接続は強制的に閉じる
+func (s *Server) Shutdown(timeout time.Duration) {
+	s.mu.Lock()
+	s.closing = true
+	close(s.closingCh)
+	// 読み取り期限を「今」に設定すると、コマンド待ちでブロックしている Read がすぐにエラーで戻ります。
+	// コマンドを実行中のゴルーチンは、応答を書き終えてから次の Read で終了します。
+	for _, c := range s.clients {
+		c.conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	// 新しい接続の受け付けを止めます。これにより Serve のループが終了します。
+	for _, l := range s.listeners {
+		l.Close()
+	}
+
+	// すべてのクライアント処理ゴルーチンの終了を待つチャネルを用意します。
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		// すべてのクライアントが正常に終了しました。
+	case <-time.After(timeout):
+		// 期限内に終わらなかったクライアントの接続を強制的に閉じます。
+		fmt.Println("Shutdown timeout exceeded, closing remaining connections")
+		s.mu.Lock()
+		for _, c := range s.clients {
+			c.conn.Close()
+		}
+		s.mu.Unlock()
+		<-done
+	}
+}
+
+// handleClient: 1つのクライアント接続について「読み取り → コマンド実行 → 応答」を繰り返します。
+// クライアントが切断するか、読み取りエラーが発生するとループを抜けて接続を閉じます。
+func (s *Server) handleClient(c *Client) {
+	// この関数を抜けるときに、必ずクライアントを一覧から削除して接続を閉じます。
+	defer s.removeClient(c)
+
+	for {
+		// --- 溜まっている応答の送信 ---
+
+		// リーダーのバッファが空のとき（＝クライアントがまだ次のコマンドを送っていないとき）にだけ、
+		// それまでに溜めた応答をまとめて送信します。
+		// パイプラインで複数のコマンドが届いている間は応答をバッファに溜め続け、最後にまとめて送ることで
+		// 送信（システムコール）の回数を減らします。
+		if c.reader.Buffered() == 0 {
+			if err := c.writer.Flush(); err != nil {
+				return
+			}
+		}
+
+		// --- リクエストの読み取りとパース ---
+
+		// バルク文字列の最大長は proto-max-bulk-len の設定に従います（CONFIG SET で変更される場合があります）。
+		c.reader.maxBulkLen = config.getProtoMaxBulkLen()
+
+		// クライアントから送られてきたコマンドを読み取り、Bulk String の配列にパースします。
+		// 先頭が '*' でないデータ（+PING\r\n など）は、インラインコマンドとして読み取ります（ReadCommand を参照）。
+		value, err := c.reader.ReadCommand()
+		if err != nil {
+			// 形式が崩れたデータを受け取った場合は、Redisと同様にエラーを返してから接続を閉じます。
+			var perr *ProtocolError
+			if errors.As(err, &perr) {
+				fmt.Printf("Protocol error from client %s: %s\n", c.conn.RemoteAddr(), perr.msg)
+				c.writer.Write(Value{typ: "error", str: "ERR " + perr.Error()})
+				c.writer.Flush()
+				return
+			}
+
+			// クライアントが接続を閉じた場合（EOF）や、シャットダウンによる読み取りの中断は
+			// 正常な終了なので、何も出力しません。
+			if err != io.EOF && !s.isClosing() {
+				fmt.Println(err)
+			}
+			return
+		}
+
+		// --- リクエストの検証 ---
+
+		// 要素が空の配列（*0\r\n など）は、Redisと同様に何も応答せず無視します。
+		if len(value.array) == 0 {
+			continue
+		}
+
+		// --- コマンド名と引数の抽出 ---
+
+		// 配列の最初の要素がコマンド名です。それを大文字に変換します（Redisはコマンド名で大文字小文字を区別しません）。
+		command := strings.ToUpper(value.array[0].bulk)
+
+		// 配列の2番目以降の要素すべてを引数（args）としてスライスします。
+		args := value.array[1:]
+
+		// --- コマンドの実行と応答 ---
+
+		// 応答は、このクライアントが HELLO で選んだプロトコル（RESP2 または RESP3）で送ります。
+		writer := c.writer
+		writer.proto = c.proto
+
+		// コマンドテーブルから、コマンド名に対応するコマンドを検索します。
+		cmd, ok := commandTable[command]
+		if !ok {
+			fmt.Println("Invalid command: ", command)
+			writer.Write(Value{typ: "error", str: fmt.Sprintf("ERR unknown command '%s'", command)})
+			continue
+		}
+
+		// requirepass が設定されている場合、認証前に実行できるのは AUTH と HELLO だけです。
+		if !c.authenticated && command != "AUTH" && command != "HELLO" {
+			writer.Write(Value{typ: "error", str: "NOAUTH Authentication required."})
+			continue
+		}
+
+		// 相対時間で有効期限を指定するコマンド（EXPIRE key 10 など）は、絶対時刻を指定するコマンドに書き換えてから
+		// 実行します。AOFにも書き換えたコマンドが記録されるので、再生したときに有効期限がずれません。
+		argv := value.array
+		if newCommand, newArgs, ok := rewriteExpireCommand(command, args); ok {
+			cmd = commandTable[newCommand]
+			argv = append([]Value{{typ: "bulk", bulk: newCommand}}, newArgs...)
+		}
+
+		// コマンドを実行し、結果（RESP Value）をクライアントに送信します。
+		// データを変更した書き込みコマンドは、call の中でAOFに記録されます。
+		// HELLO でプロトコルが切り替わった場合は、その応答から新しいプロトコルで送ります。
+		result := s.call(c, cmd, argv)
+		writer.proto = c.proto
+		writer.Write(result)
+	}
+}
+
+// ====================================================================
+// コマンドの実行とAOFへの記録
+// ====================================================================
+
+// call: コマンドを実行し、書き込みコマンドがデータを変更した場合はAOFに記録します（Redisの call に相当します）。
+// argv はコマンド名を含むコマンド全体です。
+//
+// 書き込みコマンドは callMu を取得して1つずつ実行し、実行した順にAOFに記録します。
+// 書き込みコマンドが同時に実行されると、AOFに記録する順序が実行の順序と入れ替わり、
+// 再生したときに同じ状態にならないことがあるためです（読み取りコマンドは、これまでどおり並行して実行されます）。
+//
+// AOFには、次のものをこの順に記録します。
+//  0. 実行中に期限切れで削除したキーの DEL（propagateExpire を参照）。コマンドより前に削除したので、先に記録します
+//  1. コマンド自体。実行中に db.dirty が増えた（データを実際に変更した）場合だけ記録します。
+//     エラーになったコマンドや、存在しないキーの DEL のように何も変更しなかったコマンドは記録しません
+//  2. 実行中に propagate で記録した操作（データを追加したことで、待っていたクライアントに渡した LPOP など）
+//
+// appendfsync always の場合は、応答を返す前にAOFをディスクに同期します。
+// 同期は callMu を解放してから行うので、fsync を待っている間も次の書き込みコマンドを実行でき、
+// その間に追記されたコマンドは、次の1回の fsync でまとめて同期されます（aof.go の Fsync を参照）。
+func (s *Server) call(c *Client, cmd *redisCommand, argv []Value) Value {
+	c.cmd, c.argv, c.preventProp = cmd, argv, false
+	if cmd.flags&cmdWrite == 0 {
+		return cmd.proc(c, argv[1:])
+	}
+
+	s.callMu.Lock()
+	dirty := db.dirty
+	result := cmd.proc(c, argv[1:])
+	if s.aof != nil && db.dirty != dirty && !c.preventProp {
+		s.propagated = slices.Insert(s.propagated, 0, Value{typ: "array", array: c.argv})
+	}
+	s.flushPropagated()
+	s.rewriteAppendOnlyFileIfNeeded()
+	s.callMu.Unlock()
+
+	if s.aof != nil && config.getAppendFsync() == "always" {
+		if err := s.aof.Fsync(); err != nil {
+			fmt.Println("AOF fsync error:", err)
+		}
+	}
+	return result
+}
+
+// flushPropagated: 溜めておいた操作を、期限切れのキーの DEL、それ以外の操作の順にAOFに書き込みます。
+// callMu を取得した状態で呼び出します。
+func (s *Server) flushPropagated() {
+	for _, values := range [2][]Value{s.expired, s.propagated} {
+		for _, value := range values {
+			if err := s.aof.Write(value); err != nil {
+				fmt.Println("AOF Write error:", err)
+				// AOFへの書き込み失敗時も、コマンド自体は実行されたものとして進めます。
+			}
+		}
+	}
+	s.expired = s.expired[:0]
+	s.propagated = s.propagated[:0]
+}
+
+// propagate: コマンド自体とは別に、実際に行った操作をAOFに記録します（Redisの alsoPropagate に相当します）。
+// BLPOP のようにブロックするコマンドは、AOFに記録すると再生時に正しく動かないため、
+// 代わりに実際に行われた操作（LPOP key など）を記録します。
+// 記録は call の最後にまとめて行うので、書き込みコマンドの実行中に呼び出します。
+// AOFの再生中（server がまだない）や appendonly no の場合は何もしません。
+func propagate(args ...string) {
+	if server == nil || server.aof == nil {
+		return
+	}
+	server.propagated = append(server.propagated, commandValue(args))
+}
+
+// rewriteCommand: 実行中のコマンドを、AOFに記録する別のコマンドに書き換えます（Redisの rewriteClientCommandVector）。
+// 実行した時刻や乱数で結果が変わるコマンド（SPOP など）を、再生したときに同じ結果になる形
+// （実際に削除した要素の SREM など）で記録するために使います。
+func rewriteCommand(c *Client, args ...string) {
+	c.argv = commandValue(args).array
+}
+
+// preventPropagation: 実行中のコマンド自体を、AOFに記録しないようにします（Redisの preventCommandPropagation）。
+// 実際に行った操作をすべて propagate で記録するコマンド（BLPOP や XREADGROUP など）が呼び出します。
+func preventPropagation(c *Client) {
+	c.preventProp = true
+}
+
+// commandValue: コマンド名と引数を、RESP の配列（バルク文字列の配列）にします。
+func commandValue(args []string) Value {
+	value := Value{typ: "array", array: make([]Value, len(args))}
+	for i, arg := range args {
+		value.array[i] = Value{typ: "bulk", bulk: arg}
+	}
+	return value
+}
@@ -0,0 +1,786 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// ====================================================================
+// セット型のコマンド
+// ====================================================================
+//
+// セットの値は、Redisと同じく2種類のエンコーディングのどちらかで保存されます。
+//   - intset: 要素がすべて整数で、要素数が set-max-intset-entries 以下の場合（intset.go を参照）
+//   - hashtable: それ以外の場合。Object の value に map[string]struct{} として保存します
+//
+// コマンドはエンコーディングを直接扱わず、setType で始まる関数（Redisの t_set.c と同じ名前です）を通して
+// 要素を操作します。intset に整数でない要素を追加したときや、要素数が上限を超えたときは、
+// setTypeAdd の中でハッシュテーブルに変換します。
+// 空のセットは存在できません。最後の要素を削除したときは、キーごと削除します。
+
+// intset: intset エンコーディングのセットの値を返します。
+func (o *Object) intset() *intset {
+	return o.value.(*intset)
+}
+
+// setDict: hashtable エンコーディングのセットの値を返します。
+func (o *Object) setDict() map[string]struct{} {
+	return o.value.(map[string]struct{})
+}
+
+// setTypeAdd: セットに要素を追加します。すでに存在する場合は false を返します。
+func setTypeAdd(o *Object, member string) bool {
+	if o.encoding == EncodingIntset {
+		if v, ok := parseInteger(member); ok {
+			is := o.intset()
+			if !is.Add(v) {
+				return false
+			}
+			// 要素数が上限を超えたら、ハッシュテーブルに変換します。
+			if is.Len() > config.getSetMaxIntsetEntries() {
+				setTypeConvert(o)
+			}
+			return true
+		}
+		// 整数でない要素は intset に保存できないので、先にハッシュテーブルに変換します。
+		setTypeConvert(o)
+	}
+
+	dict := o.setDict()
+	if _, ok := dict[member]; ok {
+		return false
+	}
+	dict[member] = struct{}{}
+	return true
+}
+
+// setTypeRemove: セットから要素を削除します。存在しない場合は false を返します。
+func setTypeRemove(o *Object, member string) bool {
+	if o.encoding == EncodingIntset {
+		v, ok := parseInteger(member)
+		return ok && o.intset().Remove(v)
+	}
+
+	dict := o.setDict()
+	if _, ok := dict[member]; !ok {
+		return false
+	}
+	delete(dict, member)
+	return true
+}
+
+// setTypeIsMember: 要素がセットに含まれるかを返します。
+func setTypeIsMember(o *Object, member string) bool {
+	if o.encoding == EncodingIntset {
+		v, ok := parseInteger(member)
+		return ok && o.intset().Find(v)
+	}
+	_, ok := o.setDict()[member]
+	return ok
+}
+
+// setTypeSize: セットの要素数を返します。
+func setTypeSize(o *Object) int {
+	if o.encoding == EncodingIntset {
+		return o.intset().Len()
+	}
+	return len(o.setDict())
+}
+
+// setTypeMembers: セットの全要素を返します。intset の場合は小さい順に並びます。
+func setTypeMembers(o *Object) []string {
+	if o.encoding == EncodingIntset {
+		is := o.intset()
+		members := make([]string, is.Len())
+		for i := range members {
+			members[i] = strconv.FormatInt(is.Get(i), 10)
+		}
+		return members
+	}
+
+	dict := o.setDict()
+	members := make([]string, 0, len(dict))
+	for member := range dict {
+		members = append(members, member)
+	}
+	return members
+}
+
+// setTypeRandomElement: セットの要素を1つランダムに選びます（Redisの setTypeRandomElement）。空のセットには使えません。
+// intset は位置を指定して取り出せるので一様に選び、ハッシュテーブルは randomMapKey で選びます。
+func setTypeRandomElement(o *Object) string {
+	if o.encoding == EncodingIntset {
+		is := o.intset()
+		return strconv.FormatInt(is.Get(rand.IntN(is.Len())), 10)
+	}
+	return randomMapKey(o.setDict())
+}
+
+// setTypeConvert: intset のセットをハッシュテーブルに変換します。
+func setTypeConvert(o *Object) {
+	is := o.intset()
+	dict := make(map[string]struct{}, is.Len())
+	for i := range is.Len() {
+		dict[strconv.FormatInt(is.Get(i), 10)] = struct{}{}
+	}
+	o.encoding = EncodingHashtable
+	o.value = dict
+}
+
+// lookupSetRead: 読み取りのためにセットのキーを検索します。
+// キーが存在しない場合は nil、セット以外の型の場合は WRONGTYPE のエラー応答を返します。
+// 読み取りロックを取得した状態で呼び出します。
+func lookupSetRead(key string) (*Object, *Value) {
+	o := db.lookupKeyRead(key)
+	if o != nil && o.typ != ObjSet {
+		return nil, &wrongTypeError
+	}
+	return o, nil
+}
+
+// lookupSetWrite: 書き込みのためにセットのキーを検索します。
+// キーが存在しない場合は nil、セット以外の型の場合は WRONGTYPE のエラー応答を返します。
+// 書き込みロックを取得した状態で呼び出します。
+func lookupSetWrite(key string) (*Object, *Value) {
+	o := db.lookupKeyWrite(key)
+	if o != nil && o.typ != ObjSet {
+		return nil, &wrongTypeError
+	}
+	return o, nil
+}
+
+// deleteSetIfEmpty: セットが空になっていれば、キーを削除します。書き込みロックを取得した状態で呼び出します。
+func deleteSetIfEmpty(key string, o *Object) {
+	if setTypeSize(o) == 0 {
+		db.deleteKey(key)
+	}
+}
+
+// setReply: 要素の一覧を、セットの応答（RESP3 では Set 型、RESP2 では配列）にします。
+func setReply(members []string) Value {
+	items := make([]Value, len(members))
+	for i, member := range members {
+		items[i] = Value{typ: "bulk", bulk: member}
+	}
+	return Value{typ: "set", array: items}
+}
+
+// ------------------------------
+// SADD / SREM コマンド
+// ------------------------------
+
+// sadd: SADD key member [member ...]
+// 要素を追加し、新しく追加した要素の数を返します。
+func sadd(c *Client, args []Value) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'sadd' command"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key := args[0].bulk
+	o, errValue := lookupSetWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		o = newSetObject(args[1].bulk)
+		db.setKey(key, o, false)
+	}
+
+	added := 0
+	for _, member := range args[1:] {
+		if setTypeAdd(o, member.bulk) {
+			added++
+		}
+	}
+	db.dirty += int64(added)
+	return Value{typ: "integer", num: added}
+}
+
+// srem: SREM key member [member ...]
+// 要素を削除し、実際に削除した要素の数を返します。
+func srem(c *Client, args []Value) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'srem' command"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key := args[0].bulk
+	o, errValue := lookupSetWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+
+	removed := 0
+	for _, member := range args[1:] {
+		if setTypeRemove(o, member.bulk) {
+			removed++
+		}
+	}
+	deleteSetIfEmpty(key, o)
+	db.dirty += int64(removed)
+	return Value{typ: "integer", num: removed}
+}
+
+// ------------------------------
+// SMEMBERS / SISMEMBER / SMISMEMBER / SCARD コマンド
+// ------------------------------
+
+// smembers: SMEMBERS key
+// セットの全要素を返します。
+func smembers(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'smembers' command"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupSetRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return setReply(nil)
+	}
+	return setReply(setTypeMembers(o))
+}
+
+// sismember: SISMEMBER key member
+// 要素がセットに含まれていれば 1、含まれていなければ 0 を返します。
+func sismember(c *Client, args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'sismember' command"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupSetRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o != nil && setTypeIsMember(o, args[1].bulk) {
+		return Value{typ: "integer", num: 1}
+	}
+	return Value{typ: "integer", num: 0}
+}
+
+// smismember: SMISMEMBER key member [member ...]
+// 各要素がセットに含まれるかを、1 または 0 の配列で返します。
+func smismember(c *Client, args []Value) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'smismember' command"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupSetRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+
+	items := make([]Value, 0, len(args)-1)
+	for _, member := range args[1:] {
+		num := 0
+		if o != nil && setTypeIsMember(o, member.bulk) {
+			num = 1
+		}
+		items = append(items, Value{typ: "integer", num: num})
+	}
+	return Value{typ: "array", array: items}
+}
+
+// scard: SCARD key
+// セットの要素数を返します。キーが存在しない場合は 0 です。
+func scard(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'scard' command"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupSetRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	return Value{typ: "integer", num: setTypeSize(o)}
+}
+
+// ------------------------------
+// SPOP / SRANDMEMBER コマンド
+// ------------------------------
+
+// spop: SPOP key [count]
+// ランダムに選んだ要素を削除して返します。count を指定すると、最大 count 個をまとめて取り出します。
+// どの要素が選ばれるかは実行するたびに変わるため、AOFには SPOP ではなく、
+// 実際に削除した要素を SREM として記録します（Redisと同じ動作です）。
+func spop(c *Client, args []Value) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'spop' command"}
+	}
+
+	key := args[0].bulk
+	withCount := len(args) == 2
+	count := int64(1)
+	if withCount {
+		var ok bool
+		if count, ok = parseInteger(args[1].bulk); !ok || count < 0 {
+			return Value{typ: "error", str: "ERR value is out of range, must be positive"}
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupSetWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		if withCount {
+			return setReply(nil)
+		}
+		return Value{typ: "null"}
+	}
+
+	// count が要素数以上ならすべての要素を、そうでなければランダムに選んだ要素を1つずつ取り出します。
+	// 全要素を並べてシャッフルしないので、大きなセットに SPOP を繰り返しても、1回あたり取り出す数に比例する時間で済みます。
+	var popped []string
+	if count >= int64(setTypeSize(o)) {
+		popped = setTypeMembers(o)
+		for _, member := range popped {
+			setTypeRemove(o, member)
+		}
+	} else {
+		popped = make([]string, 0, count)
+		for range count {
+			member := setTypeRandomElement(o)
+			setTypeRemove(o, member)
+			popped = append(popped, member)
+		}
+	}
+	db.dirty += int64(len(popped))
+	deleteSetIfEmpty(key, o)
+	rewriteCommand(c, append([]string{"SREM", key}, popped...)...)
+	if !withCount {
+		return Value{typ: "bulk", bulk: popped[0]}
+	}
+	return setReply(popped)
+}
+
+// srandmember: SRANDMEMBER key [count]
+// ランダムに選んだ要素を返します（削除はしません）。
+// count が正の場合は重複なしで最大 count 個、負の場合は重複を許して |count| 個を返します。
+func srandmember(c *Client, args []Value) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'srandmember' command"}
+	}
+
+	withCount := len(args) == 2
+	var count int64
+	if withCount {
+		var ok bool
+		if count, ok = parseInteger(args[1].bulk); !ok {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		// 負の count は符号を反転して使うため、反転できない math.MinInt64 などは受け付けません（Redisと同じ範囲です）。
+		if count < -math.MaxInt64/2 {
+			return Value{typ: "error", str: "ERR value is out of range"}
+		}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupSetRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		if withCount {
+			return Value{typ: "array", array: []Value{}}
+		}
+		return Value{typ: "null"}
+	}
+
+	if !withCount {
+		return Value{typ: "bulk", bulk: setTypeRandomElement(o)}
+	}
+
+	// すべての要素を並べるのは、count が要素数に近い場合だけにします（sampleDistinct を参照）。
+	var picked []string
+	switch size := setTypeSize(o); {
+	case count < 0:
+		// 重複あり: -count 回、ランダムに選びます。
+		for range -count {
+			picked = append(picked, setTypeRandomElement(o))
+		}
+	case count >= int64(size):
+		// 重複なしで要素数以上: すべての要素を返します。
+		picked = setTypeMembers(o)
+	default:
+		picked = sampleDistinct(size, int(count),
+			func() string { return setTypeRandomElement(o) },
+			func() []string { return setTypeMembers(o) })
+	}
+
+	items := make([]Value, len(picked))
+	for i, member := range picked {
+		items[i] = Value{typ: "bulk", bulk: member}
+	}
+	return Value{typ: "array", array: items}
+}
+
+// ------------------------------
+// SMOVE コマンド
+// ------------------------------
+
+// smove: SMOVE source destination member
+// source から member を取り除いて destination に追加します。移動した場合は 1、source に member がない場合は 0 を返します。
+func smove(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'smove' command"}
+	}
+
+	source, destination, member := args[0].bulk, args[1].bulk, args[2].bulk
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	src, srcErr := lookupSetWrite(source)
+	dst, dstErr := lookupSetWrite(destination)
+	// Redisと同じく、source が存在しない場合は destination の型を確認せずに 0 を返します。
+	if src == nil && srcErr == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	if srcErr != nil {
+		return *srcErr
+	}
+	if dstErr != nil {
+		return *dstErr
+	}
+
+	// source と destination が同じキーなら、何も移動せずに要素があるかだけを返します。
+	if source == destination {
+		if setTypeIsMember(src, member) {
+			return Value{typ: "integer", num: 1}
+		}
+		return Value{typ: "integer", num: 0}
+	}
+
+	if !setTypeRemove(src, member) {
+		return Value{typ: "integer", num: 0}
+	}
+	deleteSetIfEmpty(source, src)
+
+	if dst == nil {
+		dst = newSetObject(member)
+		db.setKey(destination, dst, false)
+	}
+	setTypeAdd(dst, member)
+	db.dirty++
+	return Value{typ: "integer", num: 1}
+}
+
+// ------------------------------
+// SINTER / SUNION / SDIFF コマンド（集合演算）
+// ------------------------------
+
+// 集合演算の種類です。
+const (
+	setOpInter = iota // 積集合（SINTER）
+	setOpUnion        // 和集合（SUNION）
+	setOpDiff         // 差集合（SDIFF）
+)
+
+// setOperation: keys のセットに集合演算を行い、結果の要素を返します。
+// 存在しないキーは空のセットとして扱います。セット以外の型のキーが1つでもあれば WRONGTYPE を返します。
+// lookup には、読み取りなら lookupSetRead、書き込み（STORE）なら lookupSetWrite を渡します。
+// limit が 0 より大きい場合は、結果が limit 個に達したところで計算をやめます（SINTERCARD の LIMIT）。
+func setOperation(keys []string, op int, limit int, lookup func(key string) (*Object, *Value)) ([]string, *Value) {
+	sets := make([]*Object, len(keys))
+	for i, key := range keys {
+		o, errValue := lookup(key)
+		if errValue != nil {
+			return nil, errValue
+		}
+		sets[i] = o
+	}
+
+	result := []string{}
+	switch op {
+	case setOpInter:
+		// 空のセットが1つでもあれば、積集合は空です。
+		smallest := -1
+		for i, o := range sets {
+			if o == nil {
+				return result, nil
+			}
+			if smallest < 0 || setTypeSize(o) < setTypeSize(sets[smallest]) {
+				smallest = i
+			}
+		}
+		// 最も小さいセットの要素だけを、他のすべてのセットに含まれるか調べます（Redisと同じ最適化です）。
+	next:
+		for _, member := range setTypeMembers(sets[smallest]) {
+			for i, o := range sets {
+				if i != smallest && !setTypeIsMember(o, member) {
+					continue next
+				}
+			}
+			result = append(result, member)
+			if limit > 0 && len(result) >= limit {
+				break
+			}
+		}
+
+	case setOpUnion:
+		seen := map[string]struct{}{}
+		for _, o := range sets {
+			if o == nil {
+				continue
+			}
+			for _, member := range setTypeMembers(o) {
+				if _, ok := seen[member]; !ok {
+					seen[member] = struct{}{}
+					result = append(result, member)
+				}
+			}
+		}
+
+	case setOpDiff:
+		// 最初のセットの要素のうち、他のどのセットにも含まれない要素を残します。
+		if sets[0] == nil {
+			return result, nil
+		}
+	diff:
+		for _, member := range setTypeMembers(sets[0]) {
+			for _, o := range sets[1:] {
+				if o != nil && setTypeIsMember(o, member) {
+					continue diff
+				}
+			}
+			result = append(result, member)
+		}
+	}
+	return result, nil
+}
+
+// sinter: SINTER key [key ...]
+// すべてのセットに含まれる要素を返します。
+func sinter(c *Client, args []Value) Value {
+	return setOperationGeneric(args, "sinter", setOpInter)
+}
+
+// sunion: SUNION key [key ...]
+// いずれかのセットに含まれる要素を返します。
+func sunion(c *Client, args []Value) Value {
+	return setOperationGeneric(args, "sunion", setOpUnion)
+}
+
+// sdiff: SDIFF key [key ...]
+// 最初のセットの要素のうち、他のセットに含まれない要素を返します。
+func sdiff(c *Client, args []Value) Value {
+	return setOperationGeneric(args, "sdiff", setOpDiff)
+}
+
+// setOperationGeneric: SINTER, SUNION, SDIFF の共通処理です。
+func setOperationGeneric(args []Value, name string, op int) Value {
+	if len(args) < 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.bulk
+	}
+
+	db.expireIfNeeded(keys...)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	members, errValue := setOperation(keys, op, 0, lookupSetRead)
+	if errValue != nil {
+		return *errValue
+	}
+	return setReply(members)
+}
+
+// sinterstore: SINTERSTORE destination key [key ...]
+// SINTER の結果を destination に保存し、その要素数を返します。
+func sinterstore(c *Client, args []Value) Value {
+	return setOperationStoreGeneric(args, "sinterstore", setOpInter)
+}
+
+// sunionstore: SUNIONSTORE destination key [key ...]
+// SUNION の結果を destination に保存し、その要素数を返します。
+func sunionstore(c *Client, args []Value) Value {
+	return setOperationStoreGeneric(args, "sunionstore", setOpUnion)
+}
+
+// sdiffstore: SDIFFSTORE destination key [key ...]
+// SDIFF の結果を destination に保存し、その要素数を返します。
+func sdiffstore(c *Client, args []Value) Value {
+	return setOperationStoreGeneric(args, "sdiffstore", setOpDiff)
+}
+
+// setOperationStoreGeneric: SINTERSTORE, SUNIONSTORE, SDIFFSTORE の共通処理です。
+// destination にすでに値があれば、型に関係なく上書きします（有効期限も消えます）。
+// 結果が空の場合は、destination を削除します。
+func setOperationStoreGeneric(args []Value, name string, op int) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	destination := args[0].bulk
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i] = arg.bulk
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	members, errValue := setOperation(keys, op, 0, lookupSetWrite)
+	if errValue != nil {
+		return *errValue
+	}
+
+	if len(members) == 0 {
+		if db.deleteKey(destination) {
+			db.dirty++
+		}
+		return Value{typ: "integer", num: 0}
+	}
+
+	// 結果のエンコーディングは、要素を1つずつ追加して決めます（整数だけで少なければ intset になります）。
+	o := newSetObject(members[0])
+	for _, member := range members {
+		setTypeAdd(o, member)
+	}
+	db.setKey(destination, o, false)
+	db.dirty++
+	return Value{typ: "integer", num: len(members)}
+}
+
+// sintercard: SINTERCARD numkeys key [key ...] [LIMIT limit]
+// 積集合の要素数を返します。LIMIT を指定すると、要素数が limit に達したところで計算をやめます（0 は無制限）。
+func sintercard(c *Client, args []Value) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'sintercard' command"}
+	}
+
+	numkeys, ok := parseInteger(args[0].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR numkeys should be greater than 0"}
+	}
+	if numkeys <= 0 {
+		return Value{typ: "error", str: "ERR numkeys should be greater than 0"}
+	}
+	if numkeys > int64(len(args)-1) {
+		return Value{typ: "error", str: "ERR Number of keys can't be greater than number of args"}
+	}
+
+	keys := make([]string, numkeys)
+	for i := range keys {
+		keys[i] = args[1+i].bulk
+	}
+
+	limit := int64(0)
+	for i := 1 + int(numkeys); i < len(args); i += 2 {
+		if !strings.EqualFold(args[i].bulk, "LIMIT") || i+1 >= len(args) {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		if limit, ok = parseInteger(args[i+1].bulk); !ok {
+			return Value{typ: "error", str: "ERR LIMIT can't be negative"}
+		}
+		if limit < 0 {
+			return Value{typ: "error", str: "ERR LIMIT can't be negative"}
+		}
+	}
+
+	db.expireIfNeeded(keys...)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	members, errValue := setOperation(keys, setOpInter, int(limit), lookupSetRead)
+	if errValue != nil {
+		return *errValue
+	}
+	return Value{typ: "integer", num: len(members)}
+}
+
+// ------------------------------
+// SSCAN コマンド
+// ------------------------------
+
+// sscan: SSCAN key cursor [MATCH pattern] [COUNT count]
+// セットの要素を、カーソルを使って少しずつ返します（scan.go を参照）。
+// intset エンコーディングの小さなセットは、Redisと同じく COUNT に関係なく1回で全要素を返します。
+func sscan(c *Client, args []Value) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'sscan' command"}
+	}
+
+	opts, errValue := parseScanOptions(args[1:])
+	if errValue != nil {
+		return *errValue
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupSetRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+
+	items := []Value{}
+	cursor := uint64(0)
+	if o != nil {
+		members := setTypeMembers(o)
+		if o.encoding == EncodingIntset {
+			opts.cursor, opts.count = 0, len(members)
+		}
+
+		var selected []string
+		cursor, selected = scanMembers(members, opts)
+		for _, member := range selected {
+			items = append(items, Value{typ: "bulk", bulk: member})
+		}
+	}
+
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: strconv.FormatUint(cursor, 10)},
+		{typ: "array", array: items},
+	}}
+}
@@ -0,0 +1,230 @@
+package main
+
+import "math/rand/v2"
+
+// ====================================================================
+// スキップリスト（zskiplist）
+// ====================================================================
+//
+// Redisのソート済みセットは、要素が多い場合に「スキップリスト + 辞書」で保存されます（zset.go を参照）。
+// スキップリストは、スコアの順に並んだ連結リストに、何段かの「急行レーン」を重ねたデータ構造です。
+//
+//	level 2: head ------------------------> 30 ----------------> nil
+//	level 1: head --------> 10 -----------> 30 --------> 50 ---> nil
+//	level 0: head --> 5 --> 10 --> 20 ----> 30 --> 40 --> 50 --> nil
+//
+// 上の段から順に「次のノードが探している値より小さい間は進む」を繰り返し、進めなくなったら1段下りることで、
+// 平衡二分木と同じく平均 O(log N) で要素を探せます。各ノードの段数は乱数で決めるため（確率 1/4 で1段ずつ増えます）、
+// 木の回転のような複雑な再平衡の処理が要りません。
+//
+// Redisと同じく、各段の forward ポインタには span（そのポインタで飛び越す要素の数）を持たせています。
+// たどりながら span を足していくと、その要素の順位（ZRANK）が O(log N) で求まります。
+// また、level 0 には backward ポインタがあり、末尾から逆順にたどれます（ZRANGE の REV など）。
+//
+// 要素は (スコア, メンバー) の順に並びます。同じスコアの要素は、メンバーの辞書順に並びます。
+
+const (
+	zskiplistMaxLevel = 32   // 段数の上限（2^64 個の要素にも十分です）
+	zskiplistP        = 0.25 // ノードの段数を1つ増やす確率
+)
+
+// zskiplistNode構造体: スキップリストの1つのノード（ソート済みセットの1つの要素）です。
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode   // level 0 で1つ前のノード（先頭のノードでは nil）
+	level    []zskiplistLevel // 各段の次のノードへのポインタ
+}
+
+// zskiplistLevel構造体: ノードのある段の、次のノードへのポインタと、そのポインタで飛び越す要素の数です。
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int
+}
+
+// zskiplist構造体: スキップリストです。header は要素を持たない番兵のノードです。
+type zskiplist struct {
+	header, tail *zskiplistNode
+	length       int // 要素数
+	level        int // 現在いちばん高いノードの段数
+}
+
+// newZskiplist: 空のスキップリストを作成します。
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+// zslRandomLevel: 新しいノードの段数を乱数で決めます。段数 k になる確率は (1-P) * P^(k-1) です。
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+// zslLess: (score1, member1) が (score2, member2) より前に並ぶかを返します。
+func zslLess(score1 float64, member1 string, score2 float64, member2 string) bool {
+	return score1 < score2 || (score1 == score2 && member1 < member2)
+}
+
+// Insert: 要素を追加します。同じメンバーがすでにないことは、呼び出し側（辞書）で確認しておきます。
+func (zsl *zskiplist) Insert(score float64, member string) *zskiplistNode {
+	// update[i]: 段 i で、新しいノードの直前になるノード
+	// rank[i]:   update[i] の順位（header を 0 とします）
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && zslLess(x.level[i].forward.score, x.level[i].forward.member, score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		// 新しい段は、header から末尾（nil）までを飛び越すポインタとして初期化します。
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	node := &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := range level {
+		node.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = node
+
+		// 直前のノードの span を、新しいノードの前後に分けます。
+		node.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+	// 新しいノードより高い段では、新しいノードを飛び越す分だけ span が1つ増えます。
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		node.backward = update[0]
+	}
+	if node.level[0].forward != nil {
+		node.level[0].forward.backward = node
+	} else {
+		zsl.tail = node
+	}
+	zsl.length++
+	return node
+}
+
+// Delete: 要素を削除します。見つからない場合は false を返します。
+func (zsl *zskiplist) Delete(score float64, member string) bool {
+	var update [zskiplistMaxLevel]*zskiplistNode
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && zslLess(x.level[i].forward.score, x.level[i].forward.member, score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	zsl.deleteNode(x, update[:])
+	return true
+}
+
+// deleteNode: ノードを取り除き、前後のポインタと span をつなぎ直します。
+func (zsl *zskiplist) deleteNode(x *zskiplistNode, update []*zskiplistNode) {
+	for i := range zsl.level {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	// いちばん上の段が空になったら、段数を減らします。
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+// Rank: 要素の順位（先頭の要素が 1）を返します。見つからない場合は 0 を返します。
+func (zsl *zskiplist) Rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !zslLess(score, member, x.level[i].forward.score, x.level[i].forward.member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.score == score && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// ByRank: 順位 rank（先頭の要素が 1）のノードを返します。範囲外の場合は nil を返します。
+func (zsl *zskiplist) ByRank(rank int) *zskiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank && x != zsl.header {
+			return x
+		}
+	}
+	return nil
+}
+
+// CountLess: スコアが score より小さい要素の数を返します。inclusive が true なら score と等しい要素も数えます。
+// ZRANGE BYSCORE や ZCOUNT は、範囲の両端をこの関数で順位に変換して求めます。
+func (zsl *zskiplist) CountLess(score float64, inclusive bool) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for next := x.level[i].forward; next != nil && (next.score < score || (inclusive && next.score == score)); next = x.level[i].forward {
+			rank += x.level[i].span
+			x = next
+		}
+	}
+	return rank
+}
+
+// CountLessLex: メンバーが member より辞書順で前の要素の数を返します。inclusive が true なら等しい要素も数えます。
+// Redisと同じく、すべての要素のスコアが同じ場合にだけ意味のある結果になります（ZRANGE BYLEX など）。
+func (zsl *zskiplist) CountLessLex(member string, inclusive bool) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for next := x.level[i].forward; next != nil && (next.member < member || (inclusive && next.member == member)); next = x.level[i].forward {
+			rank += x.level[i].span
+			x = next
+		}
+	}
+	return rank
+}
@@ -0,0 +1,1079 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ====================================================================
+// ストリーム型のコマンド
+// ====================================================================
+//
+// ストリームは、IDの順に並んだエントリー（フィールドと値の組の集まり）を追記していくログのようなデータ型です。
+// 各エントリーのIDは "<ミリ秒のUnix時間>-<シーケンス番号>" の形で、ストリームの中で常に増えていきます。
+//
+//	XADD mystream * sensor 1 temp 20.5   → "1700000000000-0"
+//	XADD mystream * sensor 2 temp 21.0   → "1700000000000-1"（同じミリ秒ならシーケンス番号を増やします）
+//
+// エントリーは streamIndex（streamindex.go）に保存し、IDから O(log N) で探せるようにしています。
+// 他の型と違い、ストリームはエントリーがなくなってもキーを削除しません。
+// 最後のIDや消費者グループ（streamgroup.go）の情報を失わないようにするためです（Redisと同じ動作です）。
+//
+// AOFには、再生したときに同じ状態になるよう、実行結果に合わせて書き換えたコマンドを記録します
+// （server.go の rewriteCommand を参照）。
+//   - XADD の "*" は、実際に採番したIDに置き換えます
+//   - "~" による大まかなトリミングは、実際に残ったエントリーの数を指定した正確なトリミングに置き換えます
+
+// streamID構造体: ストリームのエントリーのIDです。ms, seq の順に比較します。
+type streamID struct {
+	ms  uint64 // ミリ秒のUnix時間
+	seq uint64 // 同じミリ秒の中での通し番号
+}
+
+// 最小と最大のIDです（XRANGE の "-" と "+" に相当します）。
+var (
+	minStreamID = streamID{0, 0}
+	maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+)
+
+// Less: id が other より小さいかを返します。
+func (id streamID) Less(other streamID) bool {
+	return id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq)
+}
+
+// Incr: 1つ後のIDを返します。id が最大のIDの場合は false を返します。
+func (id streamID) Incr() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	default:
+		return id, false
+	}
+}
+
+// Decr: 1つ前のIDを返します。id が最小のIDの場合は false を返します。
+func (id streamID) Decr() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	default:
+		return id, false
+	}
+}
+
+// String: "ms-seq" の形式の文字列を返します。
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+// invalidStreamIDError: ストリームのIDとして解析できない引数が指定された場合のエラー応答です。
+var invalidStreamIDError = Value{typ: "error", str: "ERR Invalid stream ID specified as stream command argument"}
+
+// parseStreamID: "ms-seq" か "ms" の形式のIDを解析します。"ms" だけの場合、seq は missingSeq になります。
+func parseStreamID(s string, missingSeq uint64) (streamID, bool) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	if !hasSeq {
+		return streamID{ms, missingSeq}, true
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	return streamID{ms, seq}, true
+}
+
+// parseStreamRangeID: XRANGE などの範囲の端のIDを解析します。
+// "-" と "+" は最小と最大のIDで、"(" で始まる場合はそのIDを含まない範囲になります（Redis 6.2 以降と同じです）。
+// "ms" だけの場合、seq は missingSeq になります（範囲の始まりなら 0、終わりなら最大値を指定します）。
+func parseStreamRangeID(s string, missingSeq uint64, isStart bool) (streamID, *Value) {
+	exclusive := len(s) > 1 && s[0] == '('
+	if exclusive {
+		s = s[1:]
+	}
+
+	var id streamID
+	switch s {
+	case "-":
+		id = minStreamID
+	case "+":
+		id = maxStreamID
+	default:
+		var ok bool
+		if id, ok = parseStreamID(s, missingSeq); !ok {
+			return streamID{}, &invalidStreamIDError
+		}
+	}
+
+	if exclusive {
+		var ok bool
+		if isStart {
+			if id, ok = id.Incr(); !ok {
+				return streamID{}, &Value{typ: "error", str: "ERR invalid start ID for the interval"}
+			}
+		} else {
+			if id, ok = id.Decr(); !ok {
+				return streamID{}, &Value{typ: "error", str: "ERR invalid end ID for the interval"}
+			}
+		}
+	}
+	return id, nil
+}
+
+// stream構造体: ストリームの値です（Redisの stream 構造体に相当します）。
+type stream struct {
+	index        streamIndex          // エントリーの索引
+	length       int                  // 削除されていないエントリーの数
+	lastID       streamID             // 最後に追加したエントリーのID（エントリーを削除しても戻りません）
+	maxDeletedID streamID             // XDEL で削除した最大のID（消費者グループの lag の計算に使います）
+	entriesAdded int64                // これまでに追加したエントリーの総数（削除しても減りません）
+	groups       map[string]*streamCG // 消費者グループ（streamgroup.go を参照）
+}
+
+// newStream: 空のストリームを作成します。
+func newStream() *stream {
+	return &stream{groups: map[string]*streamCG{}}
+}
+
+// stream: ストリームのオブジェクトの値を返します。
+func (o *Object) stream() *stream {
+	return o.value.(*stream)
+}
+
+// dup: エントリーと消費者グループをすべてコピーした新しいストリームを返します。
+func (s *stream) dup() *stream {
+	dup := &stream{
+		index:        s.index.Dup(),
+		length:       s.length,
+		lastID:       s.lastID,
+		maxDeletedID: s.maxDeletedID,
+		entriesAdded: s.entriesAdded,
+		groups:       make(map[string]*streamCG, len(s.groups)),
+	}
+	for name, cg := range s.groups {
+		dup.groups[name] = cg.dup()
+	}
+	return dup
+}
+
+// append: エントリーを末尾に追加します。id は lastID より大きい必要があります。
+func (s *stream) append(id streamID, fields []string) {
+	s.index.Append(streamEntry{id: id, fields: fields}, config.getStreamNodeMaxEntries())
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// delete: エントリーを削除します。存在しない場合は false を返します。
+func (s *stream) delete(id streamID) bool {
+	if !s.index.Delete(id) {
+		return false
+	}
+	s.length--
+	if s.maxDeletedID.Less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+// firstID: 最初のエントリーのIDを返します。空の場合は 0-0 を返します。
+func (s *stream) firstID() streamID {
+	if e := s.index.First(); e != nil {
+		return e.id
+	}
+	return minStreamID
+}
+
+// nextID: XADD の "*" で採番するIDを返します。
+// 現在時刻と lastID の大きい方のミリ秒を使い、lastID と同じミリ秒ならシーケンス番号を1つ増やします。
+// 時計が戻っても、IDは必ず増えていきます。
+func (s *stream) nextID() (streamID, bool) {
+	ms := uint64(mstime())
+	if ms > s.lastID.ms {
+		return streamID{ms, 0}, true
+	}
+	return s.lastID.Incr()
+}
+
+// lookupStreamRead: 読み取りのためにストリームのキーを検索します。
+// キーが存在しない場合は nil、ストリーム以外の型の場合は WRONGTYPE のエラー応答を返します。
+// 読み取りロックを取得した状態で呼び出します。
+func lookupStreamRead(key string) (*Object, *Value) {
+	o := db.lookupKeyRead(key)
+	if o != nil && o.typ != ObjStream {
+		return nil, &wrongTypeError
+	}
+	return o, nil
+}
+
+// lookupStreamWrite: 書き込みのためにストリームのキーを検索します。
+// キーが存在しない場合は nil、ストリーム以外の型の場合は WRONGTYPE のエラー応答を返します。
+// 書き込みロックを取得した状態で呼び出します。
+func lookupStreamWrite(key string) (*Object, *Value) {
+	o := db.lookupKeyWrite(key)
+	if o != nil && o.typ != ObjStream {
+		return nil, &wrongTypeError
+	}
+	return o, nil
+}
+
+// streamEntryReply: エントリーを [ID, [フィールド, 値, ...]] の応答にします。
+func streamEntryReply(e *streamEntry) Value {
+	fields := make([]Value, len(e.fields))
+	for i, f := range e.fields {
+		fields[i] = Value{typ: "bulk", bulk: f}
+	}
+	return Value{typ: "array", array: []Value{{typ: "bulk", bulk: e.id.String()}, {typ: "array", array: fields}}}
+}
+
+// streamRange: start から end までのエントリーを最大 count 個（0 なら無制限）集め、応答の配列にします。
+func streamRange(s *stream, start, end streamID, reverse bool, count int) []Value {
+	entries := []Value{}
+	s.index.Range(start, end, reverse, func(e *streamEntry) bool {
+		entries = append(entries, streamEntryReply(e))
+		return count == 0 || len(entries) < count
+	})
+	return entries
+}
+
+// ------------------------------
+// トリミングの引数の解析
+// ------------------------------
+
+// トリミングの方法です。
+const (
+	streamTrimNone   = iota // トリミングしない
+	streamTrimMaxLen        // MAXLEN: エントリーの数を上限以下にする
+	streamTrimMinID         // MINID: 指定したIDより小さいエントリーを削除する
+)
+
+// streamAddTrimArgs構造体: XADD と XTRIM の引数の解析結果です。
+type streamAddTrimArgs struct {
+	// XADD のID。idGiven が false なら "*"、seqGiven が false なら "ms-*"（シーケンス番号だけ自動）です。
+	id         streamID
+	idGiven    bool
+	seqGiven   bool
+	noMkStream bool
+
+	trimStrategy int      // streamTrimNone など
+	maxLen       int64    // MAXLEN の上限
+	minID        streamID // MINID の基準
+	approx       bool     // "~" が指定されたか（ノード単位の大まかなトリミング）
+	limit        int      // 1回に削除するエントリーの上限（0 なら無制限）
+}
+
+// parseStreamAddTrimArgs: XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] id|* ...
+// と XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count] の、キーより後ろの引数を解析します。
+// XADD の場合は、フィールドと値の組が始まる位置も返します（Redisの streamParseAddOrTrimArgsOrReply に相当します）。
+func parseStreamAddTrimArgs(args []Value, xadd bool) (streamAddTrimArgs, int, *Value) {
+	var parsed streamAddTrimArgs
+	limitGiven := false
+
+	i := 1
+	for ; i < len(args); i++ {
+		moreArgs := len(args) - 1 - i
+		opt := args[i].bulk
+		switch upper := strings.ToUpper(opt); {
+		case xadd && opt == "*":
+			// IDを自動で採番します。
+		case (upper == "MAXLEN" || upper == "MINID") && moreArgs > 0:
+			strategy := streamTrimMaxLen
+			if upper == "MINID" {
+				strategy = streamTrimMinID
+			}
+			if parsed.trimStrategy != streamTrimNone && parsed.trimStrategy != strategy {
+				return parsed, 0, &Value{typ: "error", str: "ERR syntax error, MAXLEN and MINID options at the same time are not compatible"}
+			}
+			parsed.trimStrategy = strategy
+
+			i++
+			if next := args[i].bulk; (next == "~" || next == "=") && moreArgs > 1 {
+				parsed.approx = next == "~"
+				i++
+			}
+			if strategy == streamTrimMaxLen {
+				n, ok := parseInteger(args[i].bulk)
+				if !ok {
+					return parsed, 0, &Value{typ: "error", str: "ERR value is not an integer or out of range"}
+				}
+				if n < 0 {
+					return parsed, 0, &Value{typ: "error", str: "ERR The MAXLEN argument must be >= 0."}
+				}
+				parsed.maxLen = n
+			} else {
+				id, ok := parseStreamID(args[i].bulk, 0)
+				if !ok {
+					return parsed, 0, &invalidStreamIDError
+				}
+				parsed.minID = id
+			}
+			continue
+		case upper == "LIMIT" && moreArgs > 0:
+			i++
+			n, ok := parseInteger(args[i].bulk)
+			if !ok {
+				return parsed, 0, &Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			if n < 0 {
+				return parsed, 0, &Value{typ: "error", str: "ERR The LIMIT argument must be >= 0."}
+			}
+			parsed.limit = int(min(n, math.MaxInt32))
+			limitGiven = true
+			continue
+		case xadd && upper == "NOMKSTREAM":
+			parsed.noMkStream = true
+			continue
+		case xadd:
+			// オプションでなければ、明示されたIDです。"ms-*" はシーケンス番号だけを自動で採番します。
+			if ms, ok := strings.CutSuffix(opt, "-*"); ok {
+				id, ok := parseStreamID(ms, 0)
+				if !ok || strings.Contains(ms, "-") {
+					return parsed, 0, &invalidStreamIDError
+				}
+				parsed.id = id
+			} else {
+				id, ok := parseStreamID(opt, 0)
+				if !ok {
+					return parsed, 0, &invalidStreamIDError
+				}
+				parsed.id = id
+				parsed.seqGiven = true
+			}
+			parsed.idGiven = true
+		default:
+			return parsed, 0, &Value{typ: "error", str: "ERR syntax error"}
+		}
+		break
+	}
+
+	if limitGiven && parsed.trimStrategy == streamTrimNone {
+		return parsed, 0, &Value{typ: "error", str: "ERR syntax error, LIMIT cannot be used without specifying a trimming strategy"}
+	}
+	if !xadd && parsed.trimStrategy == streamTrimNone {
+		return parsed, 0, &Value{typ: "error", str: "ERR syntax error, XTRIM must be called with a trimming strategy"}
+	}
+	if limitGiven {
+		if !parsed.approx {
+			return parsed, 0, &Value{typ: "error", str: "ERR syntax error, LIMIT cannot be used without the special ~ option"}
+		}
+	} else if parsed.approx {
+		// "~" で LIMIT を省略した場合は、1回に削除する数を 100 ノード分に制限します（Redisと同じです）。
+		parsed.limit = 100 * config.getStreamNodeMaxEntries()
+		if parsed.limit <= 0 {
+			parsed.limit = 10000
+		}
+	}
+	return parsed, i + 1, nil
+}
+
+// trim: 引数に従って先頭のエントリーを削除し、削除した数を返します。
+func (s *stream) trim(args *streamAddTrimArgs) int {
+	var canRemove func(e *streamEntry, removedBefore int) bool
+	switch args.trimStrategy {
+	case streamTrimMaxLen:
+		canRemove = func(e *streamEntry, removedBefore int) bool {
+			return int64(s.length-removedBefore) > args.maxLen
+		}
+	case streamTrimMinID:
+		canRemove = func(e *streamEntry, removedBefore int) bool {
+			return e.id.Less(args.minID)
+		}
+	default:
+		return 0
+	}
+	removed := s.index.Trim(canRemove, args.approx, args.limit)
+	s.length -= removed
+	return removed
+}
+
+// ------------------------------
+// XADD コマンド
+// ------------------------------
+
+// xadd: XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+// ストリームにエントリーを追加し、そのIDを返します。
+//   - "*" ならIDを自動で採番し、"ms-*" ならシーケンス番号だけを自動で採番します
+//   - NOMKSTREAM: キーが存在しない場合はストリームを作成せず、null を返します
+//   - MAXLEN / MINID: 追加した後に、古いエントリーを削除します（XTRIM と同じです）
+func xadd(c *Client, args []Value) Value {
+	if len(args) < 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xadd' command"}
+	}
+
+	parsed, fieldPos, errValue := parseStreamAddTrimArgs(args, true)
+	if errValue != nil {
+		return *errValue
+	}
+	pairs := args[min(fieldPos, len(args)):]
+	if len(pairs) < 2 || len(pairs)%2 != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xadd' command"}
+	}
+	if parsed.idGiven && parsed.seqGiven && parsed.id == minStreamID {
+		return Value{typ: "error", str: "ERR The ID specified in XADD must be greater than 0-0"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key := args[0].bulk
+	o, errValue := lookupStreamWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		if parsed.noMkStream {
+			return Value{typ: "null"}
+		}
+		o = newStreamObject()
+		db.setKey(key, o, false)
+	}
+	s := o.stream()
+
+	// 追加するエントリーのIDを決めます。
+	var id streamID
+	switch {
+	case !parsed.idGiven:
+		var ok bool
+		if id, ok = s.nextID(); !ok {
+			return Value{typ: "error", str: "ERR The stream has exhausted the last possible ID, unable to add more items"}
+		}
+	case !parsed.seqGiven:
+		// "ms-*": 最後のIDと同じミリ秒ならシーケンス番号を1つ増やし、そうでなければ 0 から始めます。
+		id = streamID{parsed.id.ms, 0}
+		if parsed.id.ms == s.lastID.ms {
+			if s.lastID.seq == math.MaxUint64 {
+				return Value{typ: "error", str: "ERR The ID specified in XADD is equal or smaller than the target stream top item"}
+			}
+			id.seq = s.lastID.seq + 1
+		}
+	default:
+		id = parsed.id
+	}
+	if !s.lastID.Less(id) {
+		return Value{typ: "error", str: "ERR The ID specified in XADD is equal or smaller than the target stream top item"}
+	}
+
+	fields := make([]string, len(pairs))
+	for i, v := range pairs {
+		fields[i] = v.bulk
+	}
+	s.append(id, fields)
+	removed := s.trim(&parsed)
+
+	db.dirty++
+
+	// AOFには採番したIDを記録し、トリミングは実際に残った数を指定した正確な形に書き換えます。
+	logged := []string{"XADD", key}
+	if removed > 0 {
+		logged = append(logged, "MAXLEN", "=", strconv.Itoa(s.length))
+	}
+	logged = append(logged, id.String())
+	rewriteCommand(c, append(logged, fields...)...)
+
+	// このキーを XREAD BLOCK などで待っているクライアントがいれば、エントリーを渡します（blocking.go を参照）。
+	db.signalKeyAsReady(key)
+
+	return Value{typ: "bulk", bulk: id.String()}
+}
+
+// ------------------------------
+// XRANGE / XREVRANGE コマンド
+// ------------------------------
+
+// xrange: XRANGE key start end [COUNT count]
+// IDが start から end までのエントリーを、IDの順に最大 count 個返します。
+// "-" と "+" は最小と最大のID、"(" で始まるIDはそのIDを含まない範囲を表します。
+func xrange(c *Client, args []Value) Value {
+	return xrangeGeneric(args, "xrange", false)
+}
+
+// xrevrange: XREVRANGE key end start [COUNT count]
+// XRANGE と同じですが、IDの大きい方から逆順に返します（引数も end, start の順です）。
+func xrevrange(c *Client, args []Value) Value {
+	return xrangeGeneric(args, "xrevrange", true)
+}
+
+// xrangeGeneric: XRANGE と XREVRANGE の共通処理です。
+func xrangeGeneric(args []Value, name string, reverse bool) Value {
+	if len(args) != 3 && len(args) != 5 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	startArg, endArg := args[1].bulk, args[2].bulk
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+	start, errValue := parseStreamRangeID(startArg, 0, true)
+	if errValue != nil {
+		return *errValue
+	}
+	end, errValue := parseStreamRangeID(endArg, math.MaxUint64, false)
+	if errValue != nil {
+		return *errValue
+	}
+
+	count := -1
+	if len(args) == 5 {
+		if strings.ToUpper(args[3].bulk) != "COUNT" {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		n, ok := parseInteger(args[4].bulk)
+		if !ok {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		count = int(max(min(n, math.MaxInt32), 0))
+	}
+
+	key := args[0].bulk
+	db.expireIfNeeded(key)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupStreamRead(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil || count == 0 {
+		return Value{typ: "array", array: []Value{}}
+	}
+	return Value{typ: "array", array: streamRange(o.stream(), start, end, reverse, max(count, 0))}
+}
+
+// ------------------------------
+// XLEN コマンド
+// ------------------------------
+
+// xlen: XLEN key
+// ストリームのエントリーの数を返します。キーが存在しない場合は 0 を返します。
+func xlen(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xlen' command"}
+	}
+
+	key := args[0].bulk
+	db.expireIfNeeded(key)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupStreamRead(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	return Value{typ: "integer", num: o.stream().length}
+}
+
+// ------------------------------
+// XDEL / XTRIM コマンド
+// ------------------------------
+
+// xdel: XDEL key id [id ...]
+// 指定したIDのエントリーを削除し、削除した数を返します。
+func xdel(c *Client, args []Value) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xdel' command"}
+	}
+
+	// 途中で失敗して一部だけ削除されることがないよう、先にすべてのIDを解析します。
+	ids := make([]streamID, len(args)-1)
+	for i, arg := range args[1:] {
+		id, ok := parseStreamID(arg.bulk, 0)
+		if !ok {
+			return invalidStreamIDError
+		}
+		ids[i] = id
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupStreamWrite(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+
+	deleted := 0
+	for _, id := range ids {
+		if o.stream().delete(id) {
+			deleted++
+		}
+	}
+	db.dirty += int64(deleted)
+	return Value{typ: "integer", num: deleted}
+}
+
+// xtrim: XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+// 古いエントリーを削除し、削除した数を返します。
+//   - MAXLEN: エントリーの数が threshold 以下になるまで、先頭から削除します
+//   - MINID: IDが threshold より小さいエントリーを削除します
+//   - "~": ノード単位でまとめて削除できる分だけ削除します（残る数は threshold より多いことがありますが、高速です）
+func xtrim(c *Client, args []Value) Value {
+	if len(args) < 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xtrim' command"}
+	}
+
+	parsed, _, errValue := parseStreamAddTrimArgs(args, false)
+	if errValue != nil {
+		return *errValue
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key := args[0].bulk
+	o, errValue := lookupStreamWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+
+	removed := o.stream().trim(&parsed)
+	db.dirty += int64(removed)
+	// "~" の結果は実行時のノードの分け方で変わるため、AOFには残った数を指定した正確な形で記録します。
+	rewriteCommand(c, "XTRIM", key, "MAXLEN", "=", strconv.Itoa(o.stream().length))
+	return Value{typ: "integer", num: removed}
+}
+
+// ------------------------------
+// XSETID コマンド
+// ------------------------------
+
+// xsetid: XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+// ストリームの lastID（と、これまでに追加したエントリーの総数、XDEL で削除した最大のID）を設定します。
+// エントリーを追加せずにストリームの内部状態を復元できるため、AOFの書き換え（aofrewrite.go）で使います。
+// lastID は、ストリームの最後のエントリーより小さくはできません。
+func xsetid(c *Client, args []Value) Value {
+	if len(args) != 2 && len(args) != 4 && len(args) != 6 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xsetid' command"}
+	}
+
+	key := args[0].bulk
+	id, ok := parseStreamID(args[1].bulk, 0)
+	if !ok {
+		return invalidStreamIDError
+	}
+	entriesAdded := int64(-1)
+	maxDeletedID := minStreamID
+	hasMaxDeletedID := false
+	for i := 2; i < len(args); i += 2 {
+		switch strings.ToUpper(args[i].bulk) {
+		case "ENTRIESADDED":
+			n, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			if n < 0 {
+				return Value{typ: "error", str: "ERR entries_added must be positive"}
+			}
+			entriesAdded = n
+		case "MAXDELETEDID":
+			maxDeletedID, ok = parseStreamID(args[i+1].bulk, 0)
+			if !ok {
+				return invalidStreamIDError
+			}
+			if id.Less(maxDeletedID) {
+				return Value{typ: "error", str: "ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id"}
+			}
+			hasMaxDeletedID = true
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupStreamWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "error", str: "ERR no such key"}
+	}
+
+	s := o.stream()
+	if last := s.index.Last(); last != nil && id.Less(last.id) {
+		return Value{typ: "error", str: "ERR The ID specified in XSETID is smaller than the target stream top item"}
+	}
+	if entriesAdded != -1 && int64(s.length) > entriesAdded {
+		return Value{typ: "error", str: "ERR The entries_added specified in XSETID is smaller than the target stream length"}
+	}
+
+	s.lastID = id
+	if entriesAdded != -1 {
+		s.entriesAdded = entriesAdded
+	}
+	if hasMaxDeletedID {
+		s.maxDeletedID = maxDeletedID
+	}
+	db.dirty++
+	return Value{typ: "string", str: "OK"}
+}
+
+// ------------------------------
+// XREAD / XREADGROUP コマンド
+// ------------------------------
+
+// streamReadArgs構造体: XREAD と XREADGROUP の引数の解析結果です。
+type streamReadArgs struct {
+	count    int           // 1つのストリームから返すエントリーの最大数（0 なら無制限）
+	block    bool          // BLOCK が指定されたか
+	timeout  time.Duration // BLOCK の待ち時間（0 なら無期限）
+	group    string        // XREADGROUP の GROUP
+	consumer string        // XREADGROUP の消費者
+	noAck    bool          // XREADGROUP の NOACK
+	keys     []string
+	ids      []string // キーごとのID（"$" や ">" は、ロックを取得してから解決します）
+}
+
+// parseStreamReadArgs: XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...] と、
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS ... の引数を解析します。
+func parseStreamReadArgs(args []Value, name string, xreadgroup bool) (streamReadArgs, *Value) {
+	var parsed streamReadArgs
+	groupGiven := false
+
+	i := 0
+	for ; i < len(args); i++ {
+		moreArgs := len(args) - 1 - i
+		switch strings.ToUpper(args[i].bulk) {
+		case "COUNT":
+			if moreArgs < 1 {
+				return parsed, &Value{typ: "error", str: "ERR syntax error"}
+			}
+			i++
+			n, ok := parseInteger(args[i].bulk)
+			if !ok {
+				return parsed, &Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			parsed.count = int(max(min(n, math.MaxInt32), 0))
+		case "BLOCK":
+			if moreArgs < 1 {
+				return parsed, &Value{typ: "error", str: "ERR syntax error"}
+			}
+			i++
+			ms, ok := parseInteger(args[i].bulk)
+			if !ok || ms > math.MaxInt64/int64(time.Millisecond) {
+				return parsed, &Value{typ: "error", str: "ERR timeout is not an integer or out of range"}
+			}
+			if ms < 0 {
+				return parsed, &Value{typ: "error", str: "ERR timeout is negative"}
+			}
+			parsed.block = true
+			parsed.timeout = time.Duration(ms) * time.Millisecond
+		case "GROUP":
+			if moreArgs < 2 {
+				return parsed, &Value{typ: "error", str: "ERR syntax error"}
+			}
+			if !xreadgroup {
+				return parsed, &Value{typ: "error", str: "ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead."}
+			}
+			parsed.group, parsed.consumer = args[i+1].bulk, args[i+2].bulk
+			groupGiven = true
+			i += 2
+		case "NOACK":
+			if !xreadgroup {
+				return parsed, &Value{typ: "error", str: "ERR syntax error"}
+			}
+			parsed.noAck = true
+		case "STREAMS":
+			streams := args[i+1:]
+			if len(streams) == 0 || len(streams)%2 != 0 {
+				return parsed, &Value{typ: "error", str: "ERR Unbalanced '" + name + "' list of streams: for each stream key an ID or '$' must be specified."}
+			}
+			for _, v := range streams[:len(streams)/2] {
+				parsed.keys = append(parsed.keys, v.bulk)
+			}
+			for _, v := range streams[len(streams)/2:] {
+				parsed.ids = append(parsed.ids, v.bulk)
+			}
+			if xreadgroup && !groupGiven {
+				return parsed, &Value{typ: "error", str: "ERR Missing GROUP option for XREADGROUP"}
+			}
+			return parsed, nil
+		default:
+			return parsed, &Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+	return parsed, &Value{typ: "error", str: "ERR syntax error"}
+}
+
+// streamReadReply: XREAD と XREADGROUP の応答を作ります。
+// RESP2 では [[キー, エントリー], ...] の配列、RESP3 ではキーからエントリーへのマップで返します。
+func streamReadReply(c *Client, keys []string, results [][]Value) Value {
+	items := []Value{}
+	for i, key := range keys {
+		if results[i] == nil {
+			continue
+		}
+		k := Value{typ: "bulk", bulk: key}
+		entries := Value{typ: "array", array: results[i]}
+		if c.proto == 3 {
+			items = append(items, k, entries)
+		} else {
+			items = append(items, Value{typ: "array", array: []Value{k, entries}})
+		}
+	}
+	if c.proto == 3 {
+		return Value{typ: "map", array: items}
+	}
+	return Value{typ: "array", array: items}
+}
+
+// xread: XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+// 各ストリームから、指定したIDより大きいエントリーを返します。"$" はストリームの最後のIDを表し、
+// 「これから追加されるエントリー」だけを読みたい場合に使います。
+// BLOCK を指定すると、どのストリームにも該当するエントリーがない場合に、追加されるまで最大 milliseconds ミリ秒待ちます。
+// 該当するエントリーがない場合（タイムアウトした場合も）は null を返します。
+func xread(c *Client, args []Value) Value {
+	parsed, errValue := parseStreamReadArgs(args, "xread", false)
+	if errValue != nil {
+		return *errValue
+	}
+
+	db.mu.Lock()
+
+	// キーごとに、読み始めるID（このIDより大きいエントリーを返します）を決めます。
+	lastIDs := make([]streamID, len(parsed.keys))
+	for i, key := range parsed.keys {
+		o, errValue := lookupStreamWrite(key)
+		if errValue != nil {
+			db.mu.Unlock()
+			return *errValue
+		}
+		switch idArg := parsed.ids[i]; idArg {
+		case "$":
+			// "$" は、コマンドを実行した時点の最後のIDに解決します。
+			if o != nil {
+				lastIDs[i] = o.stream().lastID
+			}
+		case ">":
+			db.mu.Unlock()
+			return Value{typ: "error", str: "ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> option."}
+		default:
+			id, ok := parseStreamID(idArg, 0)
+			if !ok {
+				db.mu.Unlock()
+				return invalidStreamIDError
+			}
+			lastIDs[i] = id
+		}
+	}
+
+	// read: キーのストリームから、lastID より大きいエントリーを集めます。ない場合は nil を返します。
+	read := func(i int) []Value {
+		o, _ := lookupStreamWrite(parsed.keys[i])
+		if o == nil {
+			return nil
+		}
+		start, ok := lastIDs[i].Incr()
+		if !ok {
+			return nil
+		}
+		entries := streamRange(o.stream(), start, maxStreamID, false, parsed.count)
+		if len(entries) == 0 {
+			return nil
+		}
+		return entries
+	}
+
+	results := make([][]Value, len(parsed.keys))
+	found := false
+	for i := range parsed.keys {
+		if results[i] = read(i); results[i] != nil {
+			found = true
+		}
+	}
+	if found || !parsed.block || c.conn == nil {
+		defer db.mu.Unlock()
+		if !found {
+			return Value{typ: "nullarray"}
+		}
+		return streamReadReply(c, parsed.keys, results)
+	}
+
+	// どのストリームにもエントリーがないので、追加されるまで待ちます。
+	// エントリーが追加されたキーの分だけを応答します（Redisと同じです）。
+	bc := &blockedClient{keys: parsed.keys}
+	bc.tryServe = func(key string) (Value, bool) {
+		results := make([][]Value, len(parsed.keys))
+		found := false
+		for i, k := range parsed.keys {
+			if k == key {
+				if results[i] = read(i); results[i] != nil {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return Value{}, false
+		}
+		return streamReadReply(c, parsed.keys, results), true
+	}
+	return blockClient(c, bc, parsed.timeout, Value{typ: "nullarray"})
+}
+
+// ------------------------------
+// XINFO コマンド
+// ------------------------------
+
+// xinfo: XINFO STREAM key [FULL [COUNT count]] / XINFO GROUPS key / XINFO CONSUMERS key group / XINFO HELP
+// ストリーム、その消費者グループ、グループの消費者の情報を返します。
+func xinfo(c *Client, args []Value) Value {
+	if len(args) < 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xinfo' command"}
+	}
+
+	subcommand := strings.ToUpper(args[0].bulk)
+	if subcommand == "HELP" && len(args) == 1 {
+		lines := []string{
+			"XINFO <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"CONSUMERS <key> <groupname>",
+			"    Show consumers of <groupname>.",
+			"GROUPS <key>",
+			"    Show the stream consumer groups.",
+			"STREAM <key> [FULL [COUNT <count>]",
+			"    Show information about the stream.",
+			"HELP",
+			"    Print this help.",
+		}
+		reply := Value{typ: "array", array: make([]Value, len(lines))}
+		for i, line := range lines {
+			reply.array[i] = Value{typ: "string", str: line}
+		}
+		return reply
+	}
+
+	unknown := Value{typ: "error", str: "ERR unknown subcommand or wrong number of arguments for '" + args[0].bulk + "'. Try XINFO HELP."}
+	switch {
+	case subcommand == "STREAM" && len(args) >= 2:
+	case subcommand == "GROUPS" && len(args) == 2:
+	case subcommand == "CONSUMERS" && len(args) == 3:
+	default:
+		return unknown
+	}
+
+	// STREAM の FULL と COUNT を解析します。
+	full := false
+	count := 10
+	if subcommand == "STREAM" {
+		switch {
+		case len(args) == 2:
+		case len(args) == 3 && strings.ToUpper(args[2].bulk) == "FULL":
+			full = true
+		case len(args) == 5 && strings.ToUpper(args[2].bulk) == "FULL" && strings.ToUpper(args[3].bulk) == "COUNT":
+			full = true
+			n, ok := parseInteger(args[4].bulk)
+			if !ok {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			count = int(max(min(n, math.MaxInt32), 0))
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	key := args[1].bulk
+	db.expireIfNeeded(key)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupStreamRead(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "error", str: "ERR no such key"}
+	}
+	s := o.stream()
+
+	switch subcommand {
+	case "GROUPS":
+		groups := []Value{}
+		for _, cg := range s.sortedGroups() {
+			groups = append(groups, Value{typ: "map", array: []Value{
+				{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: cg.name},
+				{typ: "bulk", bulk: "consumers"}, {typ: "integer", num: len(cg.consumers)},
+				{typ: "bulk", bulk: "pending"}, {typ: "integer", num: cg.pel.Len()},
+				{typ: "bulk", bulk: "last-delivered-id"}, {typ: "bulk", bulk: cg.lastID.String()},
+				{typ: "bulk", bulk: "entries-read"}, entriesReadReply(cg.entriesRead),
+				{typ: "bulk", bulk: "lag"}, s.lagReply(cg),
+			}})
+		}
+		return Value{typ: "array", array: groups}
+	case "CONSUMERS":
+		cg := s.groups[args[2].bulk]
+		if cg == nil {
+			return noGroupError(key, args[2].bulk)
+		}
+		now := mstime()
+		consumers := []Value{}
+		for _, consumer := range cg.sortedConsumers() {
+			inactive := int64(-1)
+			if consumer.activeTime >= 0 {
+				inactive = now - consumer.activeTime
+			}
+			consumers = append(consumers, Value{typ: "map", array: []Value{
+				{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: consumer.name},
+				{typ: "bulk", bulk: "pending"}, {typ: "integer", num: consumer.pel.Len()},
+				{typ: "bulk", bulk: "idle"}, {typ: "integer", num: int(now - consumer.seenTime)},
+				{typ: "bulk", bulk: "inactive"}, {typ: "integer", num: int(inactive)},
+			}})
+		}
+		return Value{typ: "array", array: consumers}
+	}
+
+	// XINFO STREAM
+	// このサーバーの索引は1段の配列なので、radix-tree-keys と radix-tree-nodes にはどちらもノードの数を返します。
+	reply := []Value{
+		{typ: "bulk", bulk: "length"}, {typ: "integer", num: s.length},
+		{typ: "bulk", bulk: "radix-tree-keys"}, {typ: "integer", num: s.index.NodeCount()},
+		{typ: "bulk", bulk: "radix-tree-nodes"}, {typ: "integer", num: s.index.NodeCount()},
+		{typ: "bulk", bulk: "last-generated-id"}, {typ: "bulk", bulk: s.lastID.String()},
+		{typ: "bulk", bulk: "max-deleted-entry-id"}, {typ: "bulk", bulk: s.maxDeletedID.String()},
+		{typ: "bulk", bulk: "entries-added"}, {typ: "integer", num: int(s.entriesAdded)},
+		{typ: "bulk", bulk: "recorded-first-entry-id"}, {typ: "bulk", bulk: s.firstID().String()},
+	}
+
+	if !full {
+		first, last := Value{typ: "null"}, Value{typ: "null"}
+		if e := s.index.First(); e != nil {
+			first = streamEntryReply(e)
+		}
+		if e := s.index.Last(); e != nil {
+			last = streamEntryReply(e)
+		}
+		reply = append(reply,
+			Value{typ: "bulk", bulk: "groups"}, Value{typ: "integer", num: len(s.groups)},
+			Value{typ: "bulk", bulk: "first-entry"}, first,
+			Value{typ: "bulk", bulk: "last-entry"}, last,
+		)
+		return Value{typ: "map", array: reply}
+	}
+
+	// FULL: エントリー（最大 count 個。0 なら全部）と、グループごとの PEL と消費者の詳細も返します。
+	groups := []Value{}
+	for _, cg := range s.sortedGroups() {
+		groups = append(groups, cg.fullInfoReply(s, count))
+	}
+	reply = append(reply,
+		Value{typ: "bulk", bulk: "entries"}, Value{typ: "array", array: streamRange(s, minStreamID, maxStreamID, false, count)},
+		Value{typ: "bulk", bulk: "groups"}, Value{typ: "array", array: groups},
+	)
+	return Value{typ: "map", array: reply}
+}
@@ -0,0 +1,1173 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ====================================================================
+// ストリームの消費者グループ
+// ====================================================================
+//
+// 消費者グループを使うと、1つのストリームのエントリーを複数の消費者（consumer）で分担して処理できます。
+//
+//	XGROUP CREATE mystream workers $        ← グループを作成します（"$" はこれから追加されるエントリーから読みます）
+//	XREADGROUP GROUP workers alice STREAMS mystream >
+//	                                        ← まだグループのどの消費者にも渡していないエントリーを受け取ります
+//	XACK mystream workers 1700000000000-0   ← 処理が終わったことを知らせます
+//
+// グループは、最後に渡したエントリーのID（lastID）と、渡したがまだ XACK されていないエントリーの一覧
+// （PEL: Pending Entries List）を持ちます。PEL はグループ全体と消費者ごとの2つがあり、同じ streamNACK を共有します。
+// 消費者が処理の途中で落ちても、PEL に残ったエントリーを XPENDING で調べ、XCLAIM や XAUTOCLAIM で
+// 別の消費者に引き継げます。
+//
+// AOFには、実行した時刻や消費者に依存しない形で、PEL の変化をそのまま記録します（Redisと同じです）。
+//   - XREADGROUP で渡したエントリーは、"XCLAIM ... TIME <渡した時刻> RETRYCOUNT <回数> FORCE JUSTID" として記録します
+//   - グループの lastID の変化は、"XGROUP SETID ... ENTRIESREAD <読んだ数>" として記録します
+
+// streamNACK構造体: PEL の1つのエントリーです（Redisの streamNACK に相当します）。
+type streamNACK struct {
+	id            streamID
+	deliveryTime  int64           // 最後に消費者に渡した時刻（Unix時間のミリ秒）
+	deliveryCount int64           // 消費者に渡した回数
+	consumer      *streamConsumer // 現在このエントリーを持っている消費者
+}
+
+// pendingList構造体: IDの順に並んだ PEL です。
+// Redisは基数木（rax）で持ちますが、ここではIDの配列（範囲の検索用）とマップ（IDからの検索用）で持ちます。
+// XREADGROUP は大きいIDから順に追加するため、追加はほとんどの場合に配列の末尾で済みます。
+type pendingList struct {
+	ids   []streamID
+	nacks map[streamID]*streamNACK
+}
+
+// newPendingList: 空の PEL を作成します。
+func newPendingList() *pendingList {
+	return &pendingList{nacks: map[streamID]*streamNACK{}}
+}
+
+// Len: エントリーの数を返します。
+func (pl *pendingList) Len() int {
+	return len(pl.ids)
+}
+
+// Get: id のエントリーを返します。存在しない場合は nil を返します。
+func (pl *pendingList) Get(id streamID) *streamNACK {
+	return pl.nacks[id]
+}
+
+// search: id 以上の最初のエントリーの位置を返します。
+func (pl *pendingList) search(id streamID) int {
+	return sort.Search(len(pl.ids), func(i int) bool { return !pl.ids[i].Less(id) })
+}
+
+// Add: エントリーを追加します。すでに存在する場合は false を返します。
+func (pl *pendingList) Add(nack *streamNACK) bool {
+	if _, ok := pl.nacks[nack.id]; ok {
+		return false
+	}
+	pl.nacks[nack.id] = nack
+	if n := len(pl.ids); n == 0 || pl.ids[n-1].Less(nack.id) {
+		pl.ids = append(pl.ids, nack.id)
+	} else {
+		pl.ids = slices.Insert(pl.ids, pl.search(nack.id), nack.id)
+	}
+	return true
+}
+
+// Remove: id のエントリーを削除します。存在しない場合は false を返します。
+func (pl *pendingList) Remove(id streamID) bool {
+	if _, ok := pl.nacks[id]; !ok {
+		return false
+	}
+	delete(pl.nacks, id)
+	i := pl.search(id)
+	pl.ids = slices.Delete(pl.ids, i, i+1)
+	return true
+}
+
+// Range: IDが start から end まで（両端を含む）のエントリーを、IDの順に返します。
+// 呼び出し側がたどりながら PEL を変更できるよう、スライスにコピーして返します。
+func (pl *pendingList) Range(start, end streamID, count int) []*streamNACK {
+	nacks := []*streamNACK{}
+	for i := pl.search(start); i < len(pl.ids) && !end.Less(pl.ids[i]); i++ {
+		if count > 0 && len(nacks) >= count {
+			break
+		}
+		nacks = append(nacks, pl.nacks[pl.ids[i]])
+	}
+	return nacks
+}
+
+// streamConsumer構造体: 消費者グループの消費者です。
+type streamConsumer struct {
+	name       string
+	seenTime   int64        // 最後にコマンドを実行した時刻（XINFO CONSUMERS の idle）
+	activeTime int64        // 最後に実際にエントリーを受け取った時刻（XINFO CONSUMERS の inactive）。まだない場合は -1
+	pel        *pendingList // この消費者に渡した、まだ XACK されていないエントリー
+}
+
+// streamCG構造体: 消費者グループです（Redisの streamCG に相当します）。
+type streamCG struct {
+	name        string
+	lastID      streamID                   // 最後に渡したエントリーのID（XREADGROUP の ">" はこれより後から読みます）
+	entriesRead int64                      // これまでにグループが読んだエントリーの数（lag の計算用）。不明な場合は -1
+	pel         *pendingList               // グループ全体の PEL
+	consumers   map[string]*streamConsumer // 消費者の名前 -> 消費者
+}
+
+// streamInvalidEntriesRead: entriesRead が不明であることを表す値です（Redisの SCG_INVALID_ENTRIES_READ）。
+const streamInvalidEntriesRead = -1
+
+// createGroup: 消費者グループを作成します。同じ名前のグループがすでにある場合は nil を返します。
+func (s *stream) createGroup(name string, id streamID, entriesRead int64) *streamCG {
+	if _, ok := s.groups[name]; ok {
+		return nil
+	}
+	cg := &streamCG{name: name, lastID: id, entriesRead: entriesRead, pel: newPendingList(), consumers: map[string]*streamConsumer{}}
+	s.groups[name] = cg
+	return cg
+}
+
+// dup: 消費者と PEL をすべてコピーした新しい消費者グループを返します。
+// グループと消費者の PEL は同じ streamNACK を共有するため、コピーでも共有するようにつなぎ直します。
+func (cg *streamCG) dup() *streamCG {
+	dup := &streamCG{
+		name:        cg.name,
+		lastID:      cg.lastID,
+		entriesRead: cg.entriesRead,
+		pel:         newPendingList(),
+		consumers:   make(map[string]*streamConsumer, len(cg.consumers)),
+	}
+	for name, consumer := range cg.consumers {
+		dup.consumers[name] = &streamConsumer{name: name, seenTime: consumer.seenTime, activeTime: consumer.activeTime, pel: newPendingList()}
+	}
+	for _, id := range cg.pel.ids {
+		nack := *cg.pel.nacks[id]
+		if nack.consumer != nil {
+			nack.consumer = dup.consumers[nack.consumer.name]
+			nack.consumer.pel.Add(&nack)
+		}
+		dup.pel.Add(&nack)
+	}
+	return dup
+}
+
+// sortedGroups: 消費者グループを名前の順に返します（Redisの rax と同じ順序で応答するためです）。
+func (s *stream) sortedGroups() []*streamCG {
+	groups := make([]*streamCG, 0, len(s.groups))
+	for _, cg := range s.groups {
+		groups = append(groups, cg)
+	}
+	slices.SortFunc(groups, func(a, b *streamCG) int { return strings.Compare(a.name, b.name) })
+	return groups
+}
+
+// sortedConsumers: 消費者を名前の順に返します。
+func (cg *streamCG) sortedConsumers() []*streamConsumer {
+	consumers := make([]*streamConsumer, 0, len(cg.consumers))
+	for _, consumer := range cg.consumers {
+		consumers = append(consumers, consumer)
+	}
+	slices.SortFunc(consumers, func(a, b *streamConsumer) int { return strings.Compare(a.name, b.name) })
+	return consumers
+}
+
+// createConsumer: 消費者を作成します。すでに存在する場合は、その消費者と false を返します。
+func (cg *streamCG) createConsumer(name string) (*streamConsumer, bool) {
+	if consumer, ok := cg.consumers[name]; ok {
+		return consumer, false
+	}
+	consumer := &streamConsumer{name: name, seenTime: mstime(), activeTime: -1, pel: newPendingList()}
+	cg.consumers[name] = consumer
+	return consumer, true
+}
+
+// deleteConsumer: 消費者を削除し、その消費者の PEL にあったエントリーの数を返します。
+// 消費者の PEL にあったエントリーは、グループの PEL からも削除されます（他の消費者には引き継がれません）。
+func (cg *streamCG) deleteConsumer(consumer *streamConsumer) int {
+	pending := consumer.pel.Len()
+	for _, id := range consumer.pel.ids {
+		cg.pel.Remove(id)
+	}
+	delete(cg.consumers, consumer.name)
+	return pending
+}
+
+// assign: PEL のエントリーを consumer のものにします（XCLAIM などで別の消費者に引き継ぐ場合です）。
+func (nack *streamNACK) assign(consumer *streamConsumer) {
+	if nack.consumer == consumer {
+		return
+	}
+	if nack.consumer != nil {
+		nack.consumer.pel.Remove(nack.id)
+	}
+	nack.consumer = consumer
+	consumer.pel.Add(nack)
+}
+
+// ack: PEL のエントリーを、グループと消費者の両方の PEL から削除します。
+func (cg *streamCG) ack(nack *streamNACK) {
+	cg.pel.Remove(nack.id)
+	if nack.consumer != nil {
+		nack.consumer.pel.Remove(nack.id)
+	}
+}
+
+// ------------------------------
+// lag（未読のエントリーの数）の計算
+// ------------------------------
+//
+// lag は「グループがまだ読んでいないエントリーの数」で、entriesAdded - entriesRead で求めます。
+// ただし、XDEL でストリームの途中のエントリーが削除されていると、IDの差からは読んだ数が分からなくなるため、
+// Redisと同じく、確実に求められない場合は entriesRead を不明（-1）、lag を null にします。
+
+// rangeHasTombstones: start から最後のエントリーまでの間に、XDEL で削除されたエントリーがあるかを返します
+// （Redisの streamRangeHasTombstones に相当します）。
+func (s *stream) rangeHasTombstones(start streamID) bool {
+	if s.length == 0 || s.maxDeletedID == minStreamID {
+		return false
+	}
+	return !s.maxDeletedID.Less(start) && !s.lastID.Less(s.maxDeletedID)
+}
+
+// estimateEntriesRead: id まで読んだグループの entriesRead を推定します。求められない場合は -1 を返します
+// （Redisの streamEstimateDistanceFromFirstEverEntry に相当します）。
+func (s *stream) estimateEntriesRead(id streamID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+	if s.length == 0 && !s.lastID.Less(id) {
+		return s.entriesAdded
+	}
+	if id == s.lastID {
+		return s.entriesAdded
+	}
+	if s.lastID.Less(id) {
+		return streamInvalidEntriesRead
+	}
+
+	// 削除されたエントリーが最初のエントリーより前にしかなければ、最初のエントリーから数えられます。
+	first := s.firstID()
+	if s.maxDeletedID == minStreamID || s.maxDeletedID.Less(first) {
+		switch {
+		case id.Less(first):
+			return s.entriesAdded - int64(s.length)
+		case id == first:
+			return s.entriesAdded - int64(s.length) + 1
+		}
+	}
+	return streamInvalidEntriesRead
+}
+
+// lagReply: グループの lag を応答にします。求められない場合は null を返します。
+func (s *stream) lagReply(cg *streamCG) Value {
+	if s.entriesAdded == 0 {
+		return Value{typ: "integer", num: 0}
+	}
+	if cg.entriesRead != streamInvalidEntriesRead && !s.rangeHasTombstones(cg.lastID) {
+		return Value{typ: "integer", num: int(s.entriesAdded - cg.entriesRead)}
+	}
+	if entriesRead := s.estimateEntriesRead(cg.lastID); entriesRead != streamInvalidEntriesRead {
+		return Value{typ: "integer", num: int(s.entriesAdded - entriesRead)}
+	}
+	return Value{typ: "null"}
+}
+
+// entriesReadReply: entriesRead を応答にします。不明な場合は null を返します。
+func entriesReadReply(entriesRead int64) Value {
+	if entriesRead == streamInvalidEntriesRead {
+		return Value{typ: "null"}
+	}
+	return Value{typ: "integer", num: int(entriesRead)}
+}
+
+// advanceLastID: グループが id のエントリーを読んだものとして、lastID と entriesRead を進めます。
+func (s *stream) advanceLastID(cg *streamCG, id streamID) {
+	if !cg.lastID.Less(id) {
+		return
+	}
+	if cg.entriesRead != streamInvalidEntriesRead && !s.rangeHasTombstones(id) {
+		cg.entriesRead++
+	} else if s.entriesAdded > 0 {
+		cg.entriesRead = s.estimateEntriesRead(id)
+	}
+	cg.lastID = id
+}
+
+// ------------------------------
+// 共通の処理
+// ------------------------------
+
+// noGroupError: キーに消費者グループが存在しない場合のエラー応答です（XGROUP SETID や XINFO CONSUMERS など）。
+func noGroupError(key, group string) Value {
+	return Value{typ: "error", str: "NOGROUP No such consumer group '" + group + "' for key name '" + key + "'"}
+}
+
+// lookupStreamGroup: キーのストリームと消費者グループを検索します。
+// キーかグループが存在しない場合は、どちらも nil を返します（エラーの文言はコマンドごとに違うため、呼び出し側で作ります）。
+// 書き込みロックを取得した状態で呼び出します。
+func lookupStreamGroup(key, group string) (*stream, *streamCG, *Value) {
+	o, errValue := lookupStreamWrite(key)
+	if errValue != nil {
+		return nil, nil, errValue
+	}
+	if o == nil || o.stream().groups[group] == nil {
+		return nil, nil, nil
+	}
+	return o.stream(), o.stream().groups[group], nil
+}
+
+// lookupConsumerForCommand: コマンドを実行した消費者を検索し、存在しなければ作成します。
+// 作成した場合は、AOFに XGROUP CREATECONSUMER として記録します。
+func lookupConsumerForCommand(key string, cg *streamCG, name string) *streamConsumer {
+	consumer, created := cg.createConsumer(name)
+	if created {
+		propagate("XGROUP", "CREATECONSUMER", key, cg.name, name)
+	}
+	consumer.seenTime = mstime()
+	return consumer
+}
+
+// propagateClaim: PEL のエントリーの状態（持ち主、渡した時刻、回数）を、AOFに XCLAIM として記録します。
+// FORCE によって PEL になければ作成し、JUSTID によって回数を増やさずに RETRYCOUNT の値をそのまま設定します。
+func propagateClaim(key string, cg *streamCG, nack *streamNACK) {
+	propagate(claimCommand(key, cg, nack)...)
+}
+
+// claimCommand: PEL のエントリーの状態を再現する XCLAIM コマンドを返します（AOFの書き換えでも使います）。
+func claimCommand(key string, cg *streamCG, nack *streamNACK) []string {
+	return []string{"XCLAIM", key, cg.name, nack.consumer.name, "0", nack.id.String(),
+		"TIME", strconv.FormatInt(nack.deliveryTime, 10),
+		"RETRYCOUNT", strconv.FormatInt(nack.deliveryCount, 10),
+		"FORCE", "JUSTID"}
+}
+
+// propagateGroupID: グループの lastID と entriesRead を、AOFに XGROUP SETID として記録します。
+func propagateGroupID(key string, cg *streamCG) {
+	propagate("XGROUP", "SETID", key, cg.name, cg.lastID.String(), "ENTRIESREAD", strconv.FormatInt(cg.entriesRead, 10))
+}
+
+// ------------------------------
+// XGROUP コマンド
+// ------------------------------
+
+// xgroup: XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read]
+//
+//	XGROUP SETID key group id|$ [ENTRIESREAD entries-read]
+//	XGROUP DESTROY key group
+//	XGROUP CREATECONSUMER key group consumer
+//	XGROUP DELCONSUMER key group consumer
+//
+// 消費者グループと消費者を管理します。
+//   - CREATE: グループを作成します。id より後のエントリーが、XREADGROUP の ">" で読まれます
+//   - SETID: グループの lastID を変更します
+//   - DESTROY: グループを削除します。そのグループで XREADGROUP BLOCK しているクライアントにはエラーを返します
+//   - CREATECONSUMER / DELCONSUMER: 消費者を作成・削除します（DELCONSUMER はその消費者の PEL も破棄します）
+func xgroup(c *Client, args []Value) Value {
+	if len(args) < 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xgroup' command"}
+	}
+
+	subcommand := strings.ToUpper(args[0].bulk)
+	if subcommand == "HELP" && len(args) == 1 {
+		lines := []string{
+			"XGROUP <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"CREATE <key> <groupname> <id|$> [option]",
+			"    Create a new consumer group. Options are:",
+			"    * MKSTREAM",
+			"      Create the empty stream if it does not exist.",
+			"    * ENTRIESREAD entries_read",
+			"      Set the group's entries_read counter (internal use).",
+			"CREATECONSUMER <key> <groupname> <consumer>",
+			"    Create a new consumer in the specified group.",
+			"DELCONSUMER <key> <groupname> <consumer>",
+			"    Remove the specified consumer.",
+			"DESTROY <key> <groupname>",
+			"    Remove the specified group.",
+			"SETID <key> <groupname> <id|$> [ENTRIESREAD entries_read]",
+			"    Set the current group ID and entries_read counter.",
+			"HELP",
+			"    Print this help.",
+		}
+		reply := Value{typ: "array", array: make([]Value, len(lines))}
+		for i, line := range lines {
+			reply.array[i] = Value{typ: "string", str: line}
+		}
+		return reply
+	}
+
+	switch {
+	case subcommand == "CREATE" && len(args) >= 4 && len(args) <= 7:
+	case subcommand == "SETID" && (len(args) == 4 || len(args) == 6):
+	case subcommand == "DESTROY" && len(args) == 3:
+	case subcommand == "CREATECONSUMER" && len(args) == 4:
+	case subcommand == "DELCONSUMER" && len(args) == 4:
+	default:
+		return Value{typ: "error", str: "ERR unknown subcommand or wrong number of arguments for '" + args[0].bulk + "'. Try XGROUP HELP."}
+	}
+
+	key, group := args[1].bulk, args[2].bulk
+
+	// CREATE と SETID のオプションを解析します。
+	mkStream := false
+	entriesRead := int64(streamInvalidEntriesRead)
+	if subcommand == "CREATE" || subcommand == "SETID" {
+		for i := 4; i < len(args); i++ {
+			switch opt := strings.ToUpper(args[i].bulk); {
+			case opt == "MKSTREAM" && subcommand == "CREATE":
+				mkStream = true
+			case opt == "ENTRIESREAD" && i+1 < len(args):
+				i++
+				n, ok := parseInteger(args[i].bulk)
+				if !ok {
+					return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+				}
+				if n < 0 && n != streamInvalidEntriesRead {
+					return Value{typ: "error", str: "ERR value for ENTRIESREAD must be positive or -1"}
+				}
+				entriesRead = n
+			default:
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupStreamWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil && !(subcommand == "CREATE" && mkStream) {
+		return Value{typ: "error", str: "ERR The XGROUP subcommand requires the key to exist. " +
+			"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."}
+	}
+
+	// resolveID: CREATE と SETID のIDを解析します。"$" はストリームの最後のIDです。
+	resolveID := func(s *stream) (streamID, *Value) {
+		if args[3].bulk == "$" {
+			if s == nil {
+				return minStreamID, nil
+			}
+			return s.lastID, nil
+		}
+		id, ok := parseStreamID(args[3].bulk, 0)
+		if !ok {
+			return streamID{}, &invalidStreamIDError
+		}
+		return id, nil
+	}
+
+	switch subcommand {
+	case "CREATE":
+		var s *stream
+		if o != nil {
+			s = o.stream()
+		}
+		id, errValue := resolveID(s)
+		if errValue != nil {
+			return *errValue
+		}
+		if s != nil && s.groups[group] != nil {
+			return Value{typ: "error", str: "BUSYGROUP Consumer Group name already exists"}
+		}
+		if o == nil {
+			o = newStreamObject()
+			db.setKey(key, o, false)
+		}
+		o.stream().createGroup(group, id, entriesRead)
+		db.dirty++
+		return Value{typ: "string", str: "OK"}
+
+	case "SETID":
+		s := o.stream()
+		cg := s.groups[group]
+		if cg == nil {
+			return noGroupError(key, group)
+		}
+		id, errValue := resolveID(s)
+		if errValue != nil {
+			return *errValue
+		}
+		cg.lastID = id
+		cg.entriesRead = entriesRead
+		// "$" や省略した ENTRIESREAD を、実際に設定した値に置き換えて記録します。
+		propagateGroupID(key, cg)
+		preventPropagation(c)
+		return Value{typ: "string", str: "OK"}
+
+	case "DESTROY":
+		s := o.stream()
+		if s.groups[group] == nil {
+			return Value{typ: "integer", num: 0}
+		}
+		delete(s.groups, group)
+		db.dirty++
+		// このグループで XREADGROUP BLOCK しているクライアントに、グループがなくなったことを知らせます。
+		db.signalKeyAsReady(key)
+		return Value{typ: "integer", num: 1}
+
+	case "CREATECONSUMER":
+		cg := o.stream().groups[group]
+		if cg == nil {
+			return noGroupError(key, group)
+		}
+		if _, created := cg.createConsumer(args[3].bulk); !created {
+			return Value{typ: "integer", num: 0}
+		}
+		db.dirty++
+		return Value{typ: "integer", num: 1}
+
+	default: // DELCONSUMER
+		cg := o.stream().groups[group]
+		if cg == nil {
+			return noGroupError(key, group)
+		}
+		consumer := cg.consumers[args[3].bulk]
+		if consumer == nil {
+			return Value{typ: "integer", num: 0}
+		}
+		pending := cg.deleteConsumer(consumer)
+		db.dirty++
+		return Value{typ: "integer", num: pending}
+	}
+}
+
+// ------------------------------
+// XREADGROUP コマンド
+// ------------------------------
+
+// xreadgroup: XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+// 消費者グループの消費者として、ストリームのエントリーを読みます。
+//   - ">": グループのどの消費者にもまだ渡していないエントリーを受け取り、この消費者の PEL に追加します
+//     （NOACK を指定した場合は PEL に追加せず、受け取った時点で処理済みとみなします）
+//   - それ以外のID: この消費者の PEL にある（受け取ったが XACK していない）エントリーのうち、IDより後のものを返します。
+//     すでに XDEL で削除されたエントリーは、フィールドの代わりに null を返します
+//
+// BLOCK は ">" で読む場合にだけ意味があり、新しいエントリーが追加されるまで待ちます。
+func xreadgroup(c *Client, args []Value) Value {
+	parsed, errValue := parseStreamReadArgs(args, "xreadgroup", true)
+	if errValue != nil {
+		return *errValue
+	}
+	// AOFには XREADGROUP ではなく、消費者の作成と PEL・グループの変化を記録します。
+	preventPropagation(c)
+
+	db.mu.Lock()
+
+	// キーごとに、">" で新しいエントリーを読むか、PEL の履歴を読むか（その場合はどのIDより後か）を決めます。
+	newOnly := make([]bool, len(parsed.keys))
+	historyIDs := make([]streamID, len(parsed.keys))
+	for i, key := range parsed.keys {
+		_, cg, errValue := lookupStreamGroup(key, parsed.group)
+		if errValue != nil {
+			db.mu.Unlock()
+			return *errValue
+		}
+		if cg == nil {
+			db.mu.Unlock()
+			return Value{typ: "error", str: "NOGROUP No such key '" + key + "' or consumer group '" + parsed.group + "' in XREADGROUP with GROUP option"}
+		}
+		switch idArg := parsed.ids[i]; idArg {
+		case ">":
+			newOnly[i] = true
+		case "$":
+			db.mu.Unlock()
+			return Value{typ: "error", str: "ERR The $ ID is meaningless in the context of XREADGROUP: " +
+				"you want to read the history of this consumer by specifying a proper ID, " +
+				"or use the > ID to get new messages. The $ ID would just return an empty result set."}
+		default:
+			id, ok := parseStreamID(idArg, 0)
+			if !ok {
+				db.mu.Unlock()
+				return invalidStreamIDError
+			}
+			historyIDs[i] = id
+		}
+	}
+
+	// 消費者は、エントリーを受け取れなかった場合やブロックする場合も作成しておきます（Redisと同じです）。
+	for _, key := range parsed.keys {
+		_, cg, _ := lookupStreamGroup(key, parsed.group)
+		lookupConsumerForCommand(key, cg, parsed.consumer)
+	}
+
+	// readNew: まだ渡していないエントリーを消費者に渡します。ない場合は nil を返します。
+	readNew := func(key string) []Value {
+		s, cg, _ := lookupStreamGroup(key, parsed.group)
+		start, ok := cg.lastID.Incr()
+		if !ok {
+			return nil
+		}
+		var entries []*streamEntry
+		s.index.Range(start, maxStreamID, false, func(e *streamEntry) bool {
+			entries = append(entries, e)
+			return parsed.count == 0 || len(entries) < parsed.count
+		})
+		if len(entries) == 0 {
+			return nil
+		}
+
+		consumer := lookupConsumerForCommand(key, cg, parsed.consumer)
+		now := mstime()
+		consumer.activeTime = now
+		reply := make([]Value, len(entries))
+		for j, e := range entries {
+			s.advanceLastID(cg, e.id)
+			reply[j] = streamEntryReply(e)
+			if parsed.noAck {
+				continue
+			}
+			// SETID で lastID を戻した場合などは、すでに PEL にあることがあります。その場合は、この消費者に渡し直します。
+			nack := cg.pel.Get(e.id)
+			if nack == nil {
+				nack = &streamNACK{id: e.id}
+				cg.pel.Add(nack)
+			}
+			nack.assign(consumer)
+			nack.deliveryTime = now
+			nack.deliveryCount = 1
+			propagateClaim(key, cg, nack)
+		}
+		propagateGroupID(key, cg)
+		return reply
+	}
+
+	// readHistory: 消費者の PEL にある、id より後のエントリーを返します。
+	readHistory := func(key string, id streamID) []Value {
+		s, cg, _ := lookupStreamGroup(key, parsed.group)
+		consumer := lookupConsumerForCommand(key, cg, parsed.consumer)
+		reply := []Value{}
+		start, ok := id.Incr()
+		if !ok {
+			return reply
+		}
+		now := mstime()
+		for _, nack := range consumer.pel.Range(start, maxStreamID, parsed.count) {
+			e := s.index.Find(nack.id)
+			if e == nil {
+				reply = append(reply, Value{typ: "array", array: []Value{{typ: "bulk", bulk: nack.id.String()}, {typ: "nullarray"}}})
+				continue
+			}
+			reply = append(reply, streamEntryReply(e))
+			nack.deliveryTime = now
+			nack.deliveryCount++
+			propagateClaim(key, cg, nack)
+		}
+		if len(reply) > 0 {
+			consumer.activeTime = now
+		}
+		return reply
+	}
+
+	results := make([][]Value, len(parsed.keys))
+	found := false
+	for i, key := range parsed.keys {
+		if newOnly[i] {
+			results[i] = readNew(key)
+		} else {
+			results[i] = readHistory(key, historyIDs[i])
+		}
+		if results[i] != nil {
+			found = true
+		}
+	}
+	if found || !parsed.block || c.conn == nil {
+		defer db.mu.Unlock()
+		if !found {
+			return Value{typ: "nullarray"}
+		}
+		return streamReadReply(c, parsed.keys, results)
+	}
+
+	// どのストリームにも新しいエントリーがないので、追加されるまで待ちます。
+	bc := &blockedClient{keys: parsed.keys}
+	bc.tryServe = func(key string) (Value, bool) {
+		o, errValue := lookupStreamWrite(key)
+		if errValue != nil || o == nil {
+			return Value{}, false
+		}
+		// 待っている間にグループが削除された場合は、エラーを返して待つのをやめます。
+		if o.stream().groups[parsed.group] == nil {
+			return Value{typ: "error", str: "NOGROUP the consumer group this client was blocked on no longer exists"}, true
+		}
+		results := make([][]Value, len(parsed.keys))
+		found := false
+		for i, k := range parsed.keys {
+			if k == key {
+				if results[i] = readNew(key); results[i] != nil {
+					found = true
+				}
+				break
+			}
+		}
+		if !found {
+			return Value{}, false
+		}
+		return streamReadReply(c, parsed.keys, results), true
+	}
+	return blockClient(c, bc, parsed.timeout, Value{typ: "nullarray"})
+}
+
+// ------------------------------
+// XACK コマンド
+// ------------------------------
+
+// xack: XACK key group id [id ...]
+// エントリーの処理が終わったものとして、グループの PEL から削除します。削除した数を返します。
+func xack(c *Client, args []Value) Value {
+	if len(args) < 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xack' command"}
+	}
+
+	// 途中で失敗して一部だけ処理されることがないよう、先にすべてのIDを解析します。
+	ids := make([]streamID, len(args)-2)
+	for i, arg := range args[2:] {
+		id, ok := parseStreamID(arg.bulk, 0)
+		if !ok {
+			return invalidStreamIDError
+		}
+		ids[i] = id
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, cg, errValue := lookupStreamGroup(args[0].bulk, args[1].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if cg == nil {
+		return Value{typ: "integer", num: 0}
+	}
+
+	acked := 0
+	for _, id := range ids {
+		if nack := cg.pel.Get(id); nack != nil {
+			cg.ack(nack)
+			acked++
+		}
+	}
+	db.dirty += int64(acked)
+	return Value{typ: "integer", num: acked}
+}
+
+// ------------------------------
+// XPENDING コマンド
+// ------------------------------
+
+// xpending: XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+// グループの PEL を調べます。
+//   - 範囲を省略した場合: [エントリーの数, 最小のID, 最大のID, [[消費者, エントリーの数], ...]] の概要を返します
+//   - 範囲を指定した場合: 各エントリーの [ID, 消費者, 最後に渡してからの経過ミリ秒, 渡した回数] を返します。
+//     IDLE を指定すると、経過時間が min-idle-time ミリ秒以上のエントリーだけを返します
+func xpending(c *Client, args []Value) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xpending' command"}
+	}
+
+	key, group := args[0].bulk, args[1].bulk
+	extended := len(args) > 2
+	minIdle := int64(0)
+	var start, end streamID
+	count := 0
+	consumerName := ""
+	if extended {
+		rest := args[2:]
+		if strings.ToUpper(rest[0].bulk) == "IDLE" && len(rest) >= 2 {
+			n, ok := parseInteger(rest[1].bulk)
+			if !ok {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			minIdle = n
+			rest = rest[2:]
+		}
+		if len(rest) != 3 && len(rest) != 4 {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		var errValue *Value
+		if start, errValue = parseStreamRangeID(rest[0].bulk, 0, true); errValue != nil {
+			return *errValue
+		}
+		if end, errValue = parseStreamRangeID(rest[1].bulk, math.MaxUint64, false); errValue != nil {
+			return *errValue
+		}
+		n, ok := parseInteger(rest[2].bulk)
+		if !ok {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		count = int(max(min(n, math.MaxInt32), 0))
+		if len(rest) == 4 {
+			consumerName = rest[3].bulk
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, cg, errValue := lookupStreamGroup(key, group)
+	if errValue != nil {
+		return *errValue
+	}
+	if cg == nil {
+		return Value{typ: "error", str: "NOGROUP No such key '" + key + "' or consumer group '" + group + "'"}
+	}
+
+	if !extended {
+		if cg.pel.Len() == 0 {
+			return Value{typ: "array", array: []Value{{typ: "integer", num: 0}, {typ: "null"}, {typ: "null"}, {typ: "nullarray"}}}
+		}
+		consumers := []Value{}
+		for _, consumer := range cg.sortedConsumers() {
+			if consumer.pel.Len() == 0 {
+				continue
+			}
+			consumers = append(consumers, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: consumer.name}, {typ: "bulk", bulk: strconv.Itoa(consumer.pel.Len())},
+			}})
+		}
+		return Value{typ: "array", array: []Value{
+			{typ: "integer", num: cg.pel.Len()},
+			{typ: "bulk", bulk: cg.pel.ids[0].String()},
+			{typ: "bulk", bulk: cg.pel.ids[cg.pel.Len()-1].String()},
+			{typ: "array", array: consumers},
+		}}
+	}
+
+	pel := cg.pel
+	if consumerName != "" {
+		consumer := cg.consumers[consumerName]
+		if consumer == nil {
+			return Value{typ: "array", array: []Value{}}
+		}
+		pel = consumer.pel
+	}
+	if count == 0 {
+		return Value{typ: "array", array: []Value{}}
+	}
+
+	now := mstime()
+	entries := []Value{}
+	for i := pel.search(start); i < pel.Len() && !end.Less(pel.ids[i]) && len(entries) < count; i++ {
+		nack := pel.nacks[pel.ids[i]]
+		idle := now - nack.deliveryTime
+		if idle < minIdle {
+			continue
+		}
+		entries = append(entries, Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: nack.id.String()},
+			{typ: "bulk", bulk: nack.consumer.name},
+			{typ: "integer", num: int(max(idle, 0))},
+			{typ: "integer", num: int(nack.deliveryCount)},
+		}})
+	}
+	return Value{typ: "array", array: entries}
+}
+
+// ------------------------------
+// XCLAIM / XAUTOCLAIM コマンド
+// ------------------------------
+
+// xclaim: XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+// PEL のエントリーのうち、最後に渡してから min-idle-time ミリ秒以上経ったものを consumer に引き継ぎ、
+// そのエントリーを返します（処理中に落ちた消費者のエントリーを、別の消費者が引き取るために使います）。
+//   - IDLE / TIME: 引き継いだエントリーの「最後に渡した時刻」を、指定した経過時間前か時刻にします
+//   - RETRYCOUNT: 渡した回数を指定した値にします（省略時は1つ増やします）
+//   - FORCE: PEL にないエントリーでも、ストリームに存在すれば PEL に作成して引き継ぎます
+//   - JUSTID: エントリーの代わりにIDだけを返し、渡した回数を増やしません
+//   - LASTID: グループの lastID が lastid より小さければ、lastid に進めます
+//
+// すでに XDEL で削除されたエントリーは、引き継がずに PEL から削除します。
+func xclaim(c *Client, args []Value) Value {
+	if len(args) < 5 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xclaim' command"}
+	}
+
+	key, group, consumerName := args[0].bulk, args[1].bulk, args[2].bulk
+	minIdle, ok := parseInteger(args[3].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR Invalid min-idle-time argument for XCLAIM"}
+	}
+	minIdle = max(minIdle, 0)
+
+	// IDは、IDとして解析できない最初の引数（オプション）の手前までです。
+	var ids []streamID
+	i := 4
+	for ; i < len(args); i++ {
+		id, ok := parseStreamID(args[i].bulk, 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	now := mstime()
+	deliveryTime := int64(-1)
+	retryCount := int64(-1)
+	force, justID := false, false
+	var lastID *streamID
+	for ; i < len(args); i++ {
+		moreArgs := len(args) - 1 - i
+		switch opt := strings.ToUpper(args[i].bulk); {
+		case opt == "FORCE":
+			force = true
+		case opt == "JUSTID":
+			justID = true
+		case opt == "IDLE" && moreArgs > 0:
+			i++
+			n, ok := parseInteger(args[i].bulk)
+			if !ok {
+				return Value{typ: "error", str: "ERR Invalid IDLE option argument for XCLAIM"}
+			}
+			deliveryTime = now - n
+		case opt == "TIME" && moreArgs > 0:
+			i++
+			n, ok := parseInteger(args[i].bulk)
+			if !ok {
+				return Value{typ: "error", str: "ERR Invalid TIME option argument for XCLAIM"}
+			}
+			deliveryTime = n
+		case opt == "RETRYCOUNT" && moreArgs > 0:
+			i++
+			n, ok := parseInteger(args[i].bulk)
+			if !ok {
+				return Value{typ: "error", str: "ERR Invalid RETRYCOUNT option argument for XCLAIM"}
+			}
+			retryCount = n
+		case opt == "LASTID" && moreArgs > 0:
+			i++
+			id, ok := parseStreamID(args[i].bulk, 0)
+			if !ok {
+				return invalidStreamIDError
+			}
+			lastID = &id
+		default:
+			return Value{typ: "error", str: "ERR Unrecognized XCLAIM option '" + args[i].bulk + "'"}
+		}
+	}
+	// 未来の時刻や負の時刻が指定された場合は、現在時刻にします（Redisと同じです）。
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+	// 結果は実行した時刻で変わるため、AOFには引き継いだエントリーごとの状態を記録します（propagateClaim）。
+	preventPropagation(c)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	s, cg, errValue := lookupStreamGroup(key, group)
+	if errValue != nil {
+		return *errValue
+	}
+	if cg == nil {
+		return Value{typ: "error", str: "NOGROUP No such key '" + key + "' or consumer group '" + group + "'"}
+	}
+
+	groupIDChanged := false
+	if lastID != nil && cg.lastID.Less(*lastID) {
+		cg.lastID = *lastID
+		groupIDChanged = true
+	}
+
+	consumer := lookupConsumerForCommand(key, cg, consumerName)
+	claimed := []Value{}
+	for _, id := range ids {
+		e := s.index.Find(id)
+		nack := cg.pel.Get(id)
+		forced := false
+		if nack == nil {
+			// FORCE でも、すでに削除されたエントリーは PEL に作成しません。
+			if !force || e == nil {
+				continue
+			}
+			nack = &streamNACK{id: id, deliveryTime: now}
+			cg.pel.Add(nack)
+			forced = true
+		}
+
+		if e == nil {
+			// 削除済みのエントリーは、引き継がずに PEL から削除します。
+			cg.ack(nack)
+			propagate("XACK", key, group, id.String())
+			continue
+		}
+		if !forced && minIdle > 0 && now-nack.deliveryTime < minIdle {
+			continue
+		}
+
+		nack.assign(consumer)
+		nack.deliveryTime = deliveryTime
+		if retryCount >= 0 {
+			nack.deliveryCount = retryCount
+		} else if !justID {
+			nack.deliveryCount++
+		}
+		consumer.activeTime = now
+
+		if justID {
+			claimed = append(claimed, Value{typ: "bulk", bulk: id.String()})
+		} else {
+			claimed = append(claimed, streamEntryReply(e))
+		}
+		propagateClaim(key, cg, nack)
+	}
+	if groupIDChanged {
+		propagateGroupID(key, cg)
+	}
+	return Value{typ: "array", array: claimed}
+}
+
+// xautoclaim: XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+// XCLAIM と同じですが、IDを指定する代わりに、PEL を start から順に調べて、
+// 最後に渡してから min-idle-time ミリ秒以上経ったエントリーを最大 count 個（省略時は100個）引き継ぎます。
+// [次に調べ始めるID, 引き継いだエントリー, PEL から削除した（XDEL 済みの）エントリーのID] を返します。
+// 次に調べ始めるIDが "0-0" なら、PEL を最後まで調べ終わったことを表します。
+func xautoclaim(c *Client, args []Value) Value {
+	if len(args) < 5 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xautoclaim' command"}
+	}
+
+	key, group, consumerName := args[0].bulk, args[1].bulk, args[2].bulk
+	minIdle, ok := parseInteger(args[3].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR Invalid min-idle-time argument for XAUTOCLAIM"}
+	}
+	minIdle = max(minIdle, 0)
+	start, errValue := parseStreamRangeID(args[4].bulk, 0, true)
+	if errValue != nil {
+		return *errValue
+	}
+
+	// 1回に調べる PEL のエントリーは count の10倍までにして、長い PEL でも時間がかかりすぎないようにします。
+	const attemptsFactor = 10
+	count := int64(100)
+	justID := false
+	for i := 5; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i].bulk); {
+		case opt == "COUNT" && i+1 < len(args):
+			i++
+			n, ok := parseInteger(args[i].bulk)
+			if !ok {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			if n < 1 || n > math.MaxInt32/attemptsFactor {
+				return Value{typ: "error", str: "ERR COUNT must be > 0"}
+			}
+			count = n
+		case opt == "JUSTID":
+			justID = true
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+	// XCLAIM と同じく、AOFには引き継いだエントリーごとの状態を記録します。
+	preventPropagation(c)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	s, cg, errValue := lookupStreamGroup(key, group)
+	if errValue != nil {
+		return *errValue
+	}
+	if cg == nil {
+		return Value{typ: "error", str: "NOGROUP No such key '" + key + "' or consumer group '" + group + "'"}
+	}
+
+	consumer := lookupConsumerForCommand(key, cg, consumerName)
+	now := mstime()
+	attempts := int(count * attemptsFactor)
+	claimed, deleted := []Value{}, []Value{}
+	nacks := cg.pel.Range(start, maxStreamID, attempts+1)
+	next := minStreamID
+	for j, nack := range nacks {
+		if j == attempts || int64(len(claimed)) == count {
+			next = nack.id
+			break
+		}
+
+		e := s.index.Find(nack.id)
+		if e == nil {
+			// 削除済みのエントリーは、引き継がずに PEL から削除します。
+			cg.ack(nack)
+			propagate("XACK", key, group, nack.id.String())
+			deleted = append(deleted, Value{typ: "bulk", bulk: nack.id.String()})
+			continue
+		}
+		if minIdle > 0 && now-nack.deliveryTime < minIdle {
+			continue
+		}
+
+		nack.assign(consumer)
+		nack.deliveryTime = now
+		if !justID {
+			nack.deliveryCount++
+		}
+		consumer.activeTime = now
+
+		if justID {
+			claimed = append(claimed, Value{typ: "bulk", bulk: nack.id.String()})
+		} else {
+			claimed = append(claimed, streamEntryReply(e))
+		}
+		propagateClaim(key, cg, nack)
+	}
+
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: next.String()},
+		{typ: "array", array: claimed},
+		{typ: "array", array: deleted},
+	}}
+}
+
+// ------------------------------
+// XINFO STREAM FULL のグループの情報
+// ------------------------------
+
+// fullInfoReply: XINFO STREAM FULL で返す、グループの PEL と消費者の詳細です。
+// PEL のエントリーは、グループと消費者ごとに最大 count 個（0 なら全部）返します。
+func (cg *streamCG) fullInfoReply(s *stream, count int) Value {
+	pending := []Value{}
+	for _, nack := range cg.pel.Range(minStreamID, maxStreamID, count) {
+		pending = append(pending, Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: nack.id.String()},
+			{typ: "bulk", bulk: nack.consumer.name},
+			{typ: "integer", num: int(nack.deliveryTime)},
+			{typ: "integer", num: int(nack.deliveryCount)},
+		}})
+	}
+
+	consumers := []Value{}
+	for _, consumer := range cg.sortedConsumers() {
+		consumerPending := []Value{}
+		for _, nack := range consumer.pel.Range(minStreamID, maxStreamID, count) {
+			consumerPending = append(consumerPending, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: nack.id.String()},
+				{typ: "integer", num: int(nack.deliveryTime)},
+				{typ: "integer", num: int(nack.deliveryCount)},
+			}})
+		}
+		consumers = append(consumers, Value{typ: "map", array: []Value{
+			{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: consumer.name},
+			{typ: "bulk", bulk: "seen-time"}, {typ: "integer", num: int(consumer.seenTime)},
+			{typ: "bulk", bulk: "active-time"}, {typ: "integer", num: int(consumer.activeTime)},
+			{typ: "bulk", bulk: "pel-count"}, {typ: "integer", num: consumer.pel.Len()},
+			{typ: "bulk", bulk: "pending"}, {typ: "array", array: consumerPending},
+		}})
+	}
+
+	return Value{typ: "map", array: []Value{
+		{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: cg.name},
+		{typ: "bulk", bulk: "last-delivered-id"}, {typ: "bulk", bulk: cg.lastID.String()},
+		{typ: "bulk", bulk: "entries-read"}, entriesReadReply(cg.entriesRead),
+		{typ: "bulk", bulk: "lag"}, s.lagReply(cg),
+		{typ: "bulk", bulk: "pel-count"}, {typ: "integer", num: cg.pel.Len()},
+		{typ: "bulk", bulk: "pending"}, {typ: "array", array: pending},
+		{typ: "bulk", bulk: "consumers"}, {typ: "array", array: consumers},
+	}}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"slices"
+	"sort"
+)
+
+// ====================================================================
+// ストリームのエントリーの索引
+// ====================================================================
+//
+// Redisのストリームは、エントリーを「ノード」（listpack。最大 stream-node-max-entries 個のエントリーをまとめたもの）
+// に詰め、各ノードを先頭のエントリーのIDをキーとする基数木（rax）に登録して管理します。
+// ここでも同じく、エントリーをノードにまとめ、ノードを先頭のIDの順に並べた配列を索引にしています。
+//
+//	nodes: [ 1-0 ] [ 5-0 ] [ 9-3 ]        ← 各ノードの先頭のID（二分探索でノードを探します）
+//	         |       |       |
+//	       1-0     5-0     9-3            ← ノードの中のエントリー（IDの順。ここでも二分探索します）
+//	       2-0     7-1     9-4
+//	       ...     ...     ...
+//
+// B木の内部ノードが1段だけの形で、IDからエントリーを探すのは O(log N) です。
+// ストリームのIDは常に増えていくので、追加は最後のノードの末尾に行うだけで済みます。
+//
+// エントリーの削除（XDEL）は、Redisと同じくエントリーに削除済みの印を付けるだけで、
+// ノードの中のエントリーがすべて削除されたときにノードごと取り除きます。
+// 古いエントリーの削除（XTRIM など）も、先頭からノード単位でまとめて取り除けます。
+
+// streamEntry構造体: ストリームの1つのエントリーです。
+type streamEntry struct {
+	id      streamID
+	fields  []string // フィールドと値を交互に並べたもの
+	deleted bool     // XDEL などで削除済みかどうか
+}
+
+// streamNode構造体: 索引の1つのノードです。entries は IDの順に並びます。
+type streamNode struct {
+	entries []streamEntry
+	live    int // 削除されていないエントリーの数
+}
+
+// streamIndex構造体: ストリームのエントリーの索引です。nodes は先頭のエントリーのIDの順に並びます。
+type streamIndex struct {
+	nodes []*streamNode
+}
+
+// Append: エントリーを末尾に追加します。ID は最後のエントリーより大きい必要があります。
+// 最後のノードが maxEntries 個で満杯のときは、新しいノードを作ります。
+func (ix *streamIndex) Append(e streamEntry, maxEntries int) {
+	var last *streamNode
+	if len(ix.nodes) > 0 {
+		last = ix.nodes[len(ix.nodes)-1]
+	}
+	if last == nil || (maxEntries > 0 && len(last.entries) >= maxEntries) {
+		last = &streamNode{}
+		ix.nodes = append(ix.nodes, last)
+	}
+	last.entries = append(last.entries, e)
+	last.live++
+}
+
+// Dup: すべてのエントリーをコピーした新しい索引を返します（ノードの分け方も元と同じになります）。
+func (ix *streamIndex) Dup() streamIndex {
+	dup := streamIndex{nodes: make([]*streamNode, len(ix.nodes))}
+	for i, node := range ix.nodes {
+		entries := slices.Clone(node.entries)
+		for j := range entries {
+			entries[j].fields = slices.Clone(entries[j].fields)
+		}
+		dup.nodes[i] = &streamNode{entries: entries, live: node.live}
+	}
+	return dup
+}
+
+// locate: id 以上の最初のエントリーの位置（ノードの番号とノードの中の位置）を返します。
+// すべてのエントリーが id より小さい場合は、ノードの番号に len(nodes) を返します。
+func (ix *streamIndex) locate(id streamID) (int, int) {
+	// id 以下の先頭のIDを持つ、最後のノードを探します。
+	n := sort.Search(len(ix.nodes), func(i int) bool { return id.Less(ix.nodes[i].entries[0].id) }) - 1
+	if n < 0 {
+		return 0, 0
+	}
+	entries := ix.nodes[n].entries
+	i := sort.Search(len(entries), func(i int) bool { return !entries[i].id.Less(id) })
+	if i == len(entries) {
+		return n + 1, 0
+	}
+	return n, i
+}
+
+// Find: id のエントリーを返します。存在しないか、削除済みの場合は nil を返します。
+func (ix *streamIndex) Find(id streamID) *streamEntry {
+	n, i := ix.locate(id)
+	if n >= len(ix.nodes) {
+		return nil
+	}
+	e := &ix.nodes[n].entries[i]
+	if e.id != id || e.deleted {
+		return nil
+	}
+	return e
+}
+
+// Delete: id のエントリーを削除します。存在しない場合は false を返します。
+// ノードの中のエントリーがすべて削除されたら、ノードを索引から取り除きます。
+func (ix *streamIndex) Delete(id streamID) bool {
+	n, i := ix.locate(id)
+	if n >= len(ix.nodes) {
+		return false
+	}
+	node := ix.nodes[n]
+	e := &node.entries[i]
+	if e.id != id || e.deleted {
+		return false
+	}
+	e.deleted = true
+	e.fields = nil
+	node.live--
+	if node.live == 0 {
+		ix.nodes = slices.Delete(ix.nodes, n, n+1)
+	}
+	return true
+}
+
+// Range: start から end まで（両端を含む）の削除されていないエントリーを、IDの順に fn に渡します。
+// reverse が true なら、end から start に向かって逆順に渡します。fn が false を返したら止めます。
+func (ix *streamIndex) Range(start, end streamID, reverse bool, fn func(e *streamEntry) bool) {
+	if end.Less(start) {
+		return
+	}
+
+	if !reverse {
+		n, i := ix.locate(start)
+		for ; n < len(ix.nodes); n, i = n+1, 0 {
+			entries := ix.nodes[n].entries
+			for ; i < len(entries); i++ {
+				e := &entries[i]
+				if end.Less(e.id) {
+					return
+				}
+				if !e.deleted && !fn(e) {
+					return
+				}
+			}
+		}
+		return
+	}
+
+	// 逆順の場合は、end より大きい最初のエントリーの1つ前から、先頭に向かってたどります。
+	var n, i int
+	if end == maxStreamID {
+		n, i = len(ix.nodes), 0
+	} else {
+		next, _ := end.Incr()
+		n, i = ix.locate(next)
+	}
+	for {
+		// 1つ前の位置に移ります。
+		if i > 0 {
+			i--
+		} else {
+			if n == 0 {
+				return
+			}
+			n--
+			i = len(ix.nodes[n].entries) - 1
+		}
+		e := &ix.nodes[n].entries[i]
+		if e.id.Less(start) {
+			return
+		}
+		if !e.deleted && !fn(e) {
+			return
+		}
+	}
+}
+
+// First: 削除されていない最初のエントリーを返します。空の場合は nil を返します。
+func (ix *streamIndex) First() *streamEntry {
+	var first *streamEntry
+	ix.Range(minStreamID, maxStreamID, false, func(e *streamEntry) bool {
+		first = e
+		return false
+	})
+	return first
+}
+
+// Last: 削除されていない最後のエントリーを返します。空の場合は nil を返します。
+func (ix *streamIndex) Last() *streamEntry {
+	var last *streamEntry
+	ix.Range(minStreamID, maxStreamID, true, func(e *streamEntry) bool {
+		last = e
+		return false
+	})
+	return last
+}
+
+// Trim: 先頭から順に、canRemove が true を返すエントリーを最大 limit 個まで削除します（limit が 0 なら無制限）。
+// canRemove は、エントリーと「それより前に削除したエントリーの数」を受け取ります。
+// MAXLEN なら「残りの長さが上限を超えているか」、MINID なら「IDが基準より小さいか」を返します。
+// approx が true の場合は、Redisの "~" と同じく、ノードの中のすべてのエントリーを削除できる場合にだけ
+// ノード単位で削除します（ノードの途中のエントリーには触れないので高速です）。
+// 戻り値は削除したエントリーの数です。
+func (ix *streamIndex) Trim(canRemove func(e *streamEntry, removedBefore int) bool, approx bool, limit int) int {
+	removed := 0
+	for len(ix.nodes) > 0 {
+		node := ix.nodes[0]
+
+		// ノードの最後のエントリーまで削除できるなら、ノードごと取り除きます。
+		var last *streamEntry
+		for i := len(node.entries) - 1; i >= 0; i-- {
+			if !node.entries[i].deleted {
+				last = &node.entries[i]
+				break
+			}
+		}
+		if canRemove(last, removed+node.live-1) {
+			if limit > 0 && removed+node.live > limit {
+				return removed
+			}
+			removed += node.live
+			ix.nodes = ix.nodes[1:]
+			continue
+		}
+		if approx {
+			return removed
+		}
+
+		// ノードの途中まで削除します。
+		for i := range node.entries {
+			e := &node.entries[i]
+			if e.deleted {
+				continue
+			}
+			if !canRemove(e, removed) || (limit > 0 && removed >= limit) {
+				return removed
+			}
+			e.deleted = true
+			e.fields = nil
+			node.live--
+			removed++
+		}
+		return removed
+	}
+	return removed
+}
+
+// NodeCount: ノードの数を返します（XINFO STREAM の radix-tree-keys に相当します）。
+func (ix *streamIndex) NodeCount() int {
+	return len(ix.nodes)
+}
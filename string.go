@@ -0,0 +1,702 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ====================================================================
+// 文字列型のコマンド
+// ====================================================================
+//
+// 文字列の値は Object の value に string として保存されます（db.go を参照）。
+// INCR などの数値演算のコマンドも、値を文字列として保存し、実行のたびに数値へ変換します。
+
+// lookupStringWrite: 書き込みのために文字列のキーを検索します。
+// キーが存在しない場合は nil、文字列以外の型の場合は WRONGTYPE のエラー応答を返します。
+// 書き込みロックを取得した状態で呼び出します。
+func lookupStringWrite(key string) (*Object, *Value) {
+	o := db.lookupKeyWrite(key)
+	if o != nil && o.typ != ObjString {
+		return nil, &wrongTypeError
+	}
+	return o, nil
+}
+
+// checkStringLength: 長さ size の文字列に appendLen バイトを足した長さが、上限（proto-max-bulk-len）を超えないかを確認します。
+// APPEND や SETRANGE で、クライアントが送れないほど大きな文字列が作られるのを防ぎます。
+// SETRANGE の offset は int64 の最大値まで指定できるので、足し算があふれないよう、引き算で比べます（Redisと同じです）。
+func checkStringLength(size, appendLen int64) *Value {
+	if size > int64(config.getProtoMaxBulkLen())-appendLen {
+		return &Value{typ: "error", str: "ERR string exceeds maximum allowed size (proto-max-bulk-len)"}
+	}
+	return nil
+}
+
+// ------------------------------
+// SET コマンド
+// ------------------------------
+
+// set コマンドの処理関数です。キーと値をデータストアに保存します。
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+//   - NX: キーが存在しない場合のみ保存する
+//   - XX: キーが存在する場合のみ保存する
+//   - GET: 保存する前の値を返す（キーが存在しなければ null）
+func set(c *Client, args []Value) Value {
+	// 引数の数（キーと値の2つ以上）が正しいか検証します。
+	if len(args) < 2 {
+		// 間違っている場合、RESP Errorを返します。
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'set' command"}
+	}
+
+	key := args[0].bulk   // 最初の引数をキーとして取得
+	value := args[1].bulk // 2番目の引数を値として取得
+
+	// 3番目以降の引数（オプション）を解析します。
+	opts, errValue := parseStringOptions(args, 2, "set")
+	if errValue != nil {
+		return *errValue
+	}
+
+	// 書き込み操作なので、排他制御のためにロックを取得します。
+	db.mu.Lock()
+	// 処理が完了したらロックを解放します。
+	defer db.mu.Unlock()
+
+	old := db.lookupKeyWrite(key)
+
+	// GET が指定された場合の応答（以前の値）です。以前の値が文字列でなければ、何も保存せずにエラーを返します。
+	oldValue := Value{typ: "null"}
+	if opts.get && old != nil {
+		if old.typ != ObjString {
+			return wrongTypeError
+		}
+		oldValue = Value{typ: "bulk", bulk: old.str()}
+	}
+
+	// NX / XX の条件を満たさない場合は保存しません。
+	if (opts.nx && old != nil) || (opts.xx && old == nil) {
+		if opts.get {
+			return oldValue
+		}
+		return Value{typ: "null"}
+	}
+
+	// キースペースにキーと値を保存します。既存のキーは型に関係なく上書きされます。
+	// KEEPTTL が指定されていなければ、以前の有効期限は取り消されます（Redisと同じ動作です）。
+	db.setKey(key, newStringObject(value), opts.keepTTL)
+	if opts.expireAt != 0 {
+		db.setExpire(key, opts.expireAt)
+	}
+	db.dirty++
+
+	if opts.get {
+		return oldValue
+	}
+	// 成功応答として Simple String の "OK" を返します。
+	return Value{typ: "string", str: "OK"}
+}
+
+// stringOptions構造体: SET と GETEX コマンドのオプションを解析した結果です。
+type stringOptions struct {
+	expireOption string // 指定された有効期限のオプション（"EX", "PX", "EXAT", "PXAT"）。指定がなければ空文字列
+	expireAt     int64  // 有効期限（Unix時間のミリ秒）。指定がなければ 0
+	keepTTL      bool   // KEEPTTL が指定されたか（SET のみ）
+	persist      bool   // PERSIST が指定されたか（GETEX のみ）
+	nx           bool   // NX が指定されたか（SET のみ）
+	xx           bool   // XX が指定されたか（SET のみ）
+	get          bool   // GET が指定されたか（SET のみ）
+}
+
+// parseStringOptions: SET や GETEX コマンドのオプションを、args[start] 以降から解析します（Redisの parseExtendedStringArgumentsOrReply に相当します）。
+// command には "set" か "getex" を渡し、そのコマンドで使えないオプションは構文エラーにします。
+// 有効期限のオプションは1つだけ指定でき、値は正の整数でなければなりません。
+func parseStringOptions(args []Value, start int, command string) (stringOptions, *Value) {
+	var opts stringOptions
+	syntaxErr := &Value{typ: "error", str: "ERR syntax error"}
+	isSet := command == "set"
+
+	for i := start; i < len(args); i++ {
+		opt := strings.ToUpper(args[i].bulk)
+		switch {
+		case opt == "EX" || opt == "PX" || opt == "EXAT" || opt == "PXAT":
+			// 有効期限のオプションが複数指定されている場合や、値がない場合は構文エラーです。
+			if opts.expireOption != "" || opts.keepTTL || opts.persist || i+1 >= len(args) {
+				return opts, syntaxErr
+			}
+			n, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return opts, &Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			if n <= 0 {
+				return opts, &Value{typ: "error", str: "ERR invalid expire time in '" + command + "' command"}
+			}
+
+			// 秒で指定するオプションはミリ秒に、相対時間のオプションは絶対時刻に変換します。
+			basetime, unit := int64(0), int64(1)
+			if opt == "EX" || opt == "PX" {
+				basetime = mstime()
+			}
+			if opt == "EX" || opt == "EXAT" {
+				unit = 1000
+			}
+			when, errValue := parseExpireTime(args[i+1].bulk, basetime, unit, command)
+			if errValue != nil {
+				return opts, errValue
+			}
+
+			opts.expireOption = opt
+			opts.expireAt = when
+			i++
+		case opt == "KEEPTTL" && isSet:
+			if opts.expireOption != "" {
+				return opts, syntaxErr
+			}
+			opts.keepTTL = true
+		case opt == "PERSIST" && !isSet:
+			if opts.expireOption != "" {
+				return opts, syntaxErr
+			}
+			opts.persist = true
+		case opt == "NX" && isSet:
+			if opts.xx {
+				return opts, syntaxErr
+			}
+			opts.nx = true
+		case opt == "XX" && isSet:
+			if opts.nx {
+				return opts, syntaxErr
+			}
+			opts.xx = true
+		case opt == "GET" && isSet:
+			opts.get = true
+		default:
+			return opts, syntaxErr
+		}
+	}
+
+	return opts, nil
+}
+
+// ------------------------------
+// GET コマンド
+// ------------------------------
+
+// get コマンドの処理関数です。指定されたキーの値を取得します。
+func get(c *Client, args []Value) Value {
+	// 引数の数（キーの1つ）が正しいか検証します。
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'get' command"}
+	}
+
+	key := args[0].bulk // キーを取得
+
+	// 有効期限が切れていれば、読み取る前に削除します（遅延削除）。
+	db.expireIfNeeded(key)
+
+	// 読み取り操作なので、読み取りロックを取得します。
+	db.mu.RLock()
+	// 処理が完了したら読み取りロックを解放します。
+	defer db.mu.RUnlock()
+
+	// キースペースからキーを検索します。
+	o := db.lookupKeyRead(key)
+
+	// キーが存在しなかった場合
+	if o == nil {
+		// Null Bulk String 応答を返します（Redisの標準的な「値がない」という応答）。
+		return Value{typ: "null"}
+	}
+	// キーが文字列以外（ハッシュなど）の場合は、型のエラーを返します。
+	if o.typ != ObjString {
+		return wrongTypeError
+	}
+
+	// 値が存在した場合、その値を Bulk String として返します。
+	return Value{typ: "bulk", bulk: o.str()}
+}
+
+// ------------------------------
+// GETSET / GETDEL / GETEX コマンド
+// ------------------------------
+
+// getset: GETSET key value
+// 新しい値を保存し、以前の値を返します（SET key value GET と同じです）。有効期限は取り消されます。
+func getset(c *Client, args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'getset' command"}
+	}
+
+	key, value := args[0].bulk, args[1].bulk
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	old, errValue := lookupStringWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+	db.setKey(key, newStringObject(value), false)
+	db.dirty++
+
+	if old == nil {
+		return Value{typ: "null"}
+	}
+	return Value{typ: "bulk", bulk: old.str()}
+}
+
+// getdel: GETDEL key
+// 値を返し、キーを削除します。
+func getdel(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'getdel' command"}
+	}
+
+	key := args[0].bulk
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupStringWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "null"}
+	}
+
+	db.deleteKey(key)
+	db.dirty++
+	return Value{typ: "bulk", bulk: o.str()}
+}
+
+// getex: GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]
+// 値を返し、同時に有効期限を設定（または PERSIST で解除）します。
+func getex(c *Client, args []Value) Value {
+	if len(args) < 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'getex' command"}
+	}
+
+	key := args[0].bulk
+	opts, errValue := parseStringOptions(args, 1, "getex")
+	if errValue != nil {
+		return *errValue
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupStringWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "null"}
+	}
+
+	// 有効期限を変更した場合だけ、データを変更したものとして数えます（オプションのない GETEX は GET と同じです）。
+	switch {
+	case opts.expireAt != 0:
+		db.setExpire(key, opts.expireAt)
+		db.dirty++
+	case opts.persist:
+		if _, ok := db.expires[key]; ok {
+			delete(db.expires, key)
+			db.dirty++
+		}
+	}
+	return Value{typ: "bulk", bulk: o.str()}
+}
+
+// ------------------------------
+// INCR / DECR / INCRBY / DECRBY コマンド
+// ------------------------------
+
+// incr: INCR key
+func incr(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'incr' command"}
+	}
+	return incrDecr(args[0].bulk, 1)
+}
+
+// decr: DECR key
+func decr(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'decr' command"}
+	}
+	return incrDecr(args[0].bulk, -1)
+}
+
+// incrby: INCRBY key increment
+func incrby(c *Client, args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'incrby' command"}
+	}
+	incr, ok := parseInteger(args[1].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+	return incrDecr(args[0].bulk, incr)
+}
+
+// decrby: DECRBY key decrement
+func decrby(c *Client, args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'decrby' command"}
+	}
+	decr, ok := parseInteger(args[1].bulk)
+	// -math.MinInt64 は int64 に収まらないため、Redisと同じくエラーにします。
+	if !ok || decr == math.MinInt64 {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+	return incrDecr(args[0].bulk, -decr)
+}
+
+// incrDecr: INCR 系コマンドの共通処理です。キーの値に incr を足し、結果を Integer で返します。
+// キーが存在しない場合は 0 とみなします。有効期限はそのまま残ります。
+func incrDecr(key string, incr int64) Value {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupStringWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+
+	var value int64
+	if o != nil {
+		var ok bool
+		if value, ok = parseInteger(o.str()); !ok {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+	}
+
+	// 結果が int64 の範囲を超える場合はエラーにします。
+	if (incr < 0 && value < 0 && incr < math.MinInt64-value) ||
+		(incr > 0 && value > 0 && incr > math.MaxInt64-value) {
+		return Value{typ: "error", str: "ERR increment or decrement would overflow"}
+	}
+	value += incr
+
+	db.setKey(key, newStringObject(strconv.FormatInt(value, 10)), true)
+	db.dirty++
+	return Value{typ: "integer", num: int(value)}
+}
+
+// parseInteger: 文字列を64ビット整数に変換します（Redisの string2ll に相当します）。
+// "+1" や "01"、前後の空白のように、整数に戻したときに元の文字列と一致しないものは受け付けません。
+func parseInteger(s string) (int64, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		return 0, false
+	}
+	return n, true
+}
+
+// ------------------------------
+// INCRBYFLOAT コマンド
+// ------------------------------
+
+// incrbyfloat: INCRBYFLOAT key increment
+// キーの値に浮動小数点数を足し、結果を Bulk String で返します。
+func incrbyfloat(c *Client, args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'incrbyfloat' command"}
+	}
+
+	key := args[0].bulk
+	incr, ok := parseFloat(args[1].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR value is not a valid float"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupStringWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+
+	var value float64
+	if o != nil {
+		if value, ok = parseFloat(o.str()); !ok {
+			return Value{typ: "error", str: "ERR value is not a valid float"}
+		}
+	}
+
+	value += incr
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return Value{typ: "error", str: "ERR increment would produce NaN or Infinity"}
+	}
+
+	// 結果は指数表記を使わない、最も短い10進数の表記で保存します（例: 10.5 + 0.1 → "10.6"）。
+	result := strconv.FormatFloat(value, 'f', -1, 64)
+	db.setKey(key, newStringObject(result), true)
+	db.dirty++
+
+	// 浮動小数点数の計算結果は環境によってわずかに異なることがあるため、AOFには計算した結果を
+	// SET key result KEEPTTL として記録します（Redisと同じです）。
+	rewriteCommand(c, "SET", key, result, "KEEPTTL")
+	return Value{typ: "bulk", bulk: result}
+}
+
+// parseFloat: 文字列を浮動小数点数に変換します。NaN や、空白を含むものは受け付けません。
+func parseFloat(s string) (float64, bool) {
+	if s == "" || isArgSpace(s[0]) || isArgSpace(s[len(s)-1]) {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+// ------------------------------
+// APPEND / STRLEN コマンド
+// ------------------------------
+
+// appendCommand: APPEND key value
+// キーの値の末尾に文字列を追加し、追加後の長さを返します。キーが存在しない場合は SET と同じです。
+// （append は Go の組み込み関数と同じ名前なので appendCommand としています）
+func appendCommand(c *Client, args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'append' command"}
+	}
+
+	key, value := args[0].bulk, args[1].bulk
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupStringWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+
+	newValue := value
+	if o != nil {
+		if errValue := checkStringLength(int64(len(o.str())), int64(len(value))); errValue != nil {
+			return *errValue
+		}
+		newValue = o.str() + value
+	}
+
+	db.setKey(key, newStringObject(newValue), true)
+	db.dirty++
+	return Value{typ: "integer", num: len(newValue)}
+}
+
+// strlen: STRLEN key
+// キーの値の長さを返します。キーが存在しない場合は 0 です。
+func strlen(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'strlen' command"}
+	}
+
+	key := args[0].bulk
+	db.expireIfNeeded(key)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o := db.lookupKeyRead(key)
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	if o.typ != ObjString {
+		return wrongTypeError
+	}
+	return Value{typ: "integer", num: len(o.str())}
+}
+
+// ------------------------------
+// GETRANGE / SETRANGE コマンド
+// ------------------------------
+
+// getrange: GETRANGE key start end
+// キーの値の一部（start から end まで、両端を含む）を返します。負のインデックスは末尾からの位置です。
+func getrange(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'getrange' command"}
+	}
+
+	key := args[0].bulk
+	start, ok1 := parseInteger(args[1].bulk)
+	end, ok2 := parseInteger(args[2].bulk)
+	if !ok1 || !ok2 {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	db.expireIfNeeded(key)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o := db.lookupKeyRead(key)
+	if o == nil {
+		return Value{typ: "bulk", bulk: ""}
+	}
+	if o.typ != ObjString {
+		return wrongTypeError
+	}
+
+	s := o.str()
+	strlen := int64(len(s))
+
+	// 両方とも負で start > end の場合は、範囲が空です。
+	if start < 0 && end < 0 && start > end {
+		return Value{typ: "bulk", bulk: ""}
+	}
+	// 負のインデックスを末尾からの位置に変換し、範囲を文字列の中に収めます。
+	if start < 0 {
+		start += strlen
+	}
+	if end < 0 {
+		end += strlen
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= strlen {
+		end = strlen - 1
+	}
+	if start > end || strlen == 0 {
+		return Value{typ: "bulk", bulk: ""}
+	}
+
+	return Value{typ: "bulk", bulk: s[start : end+1]}
+}
+
+// setrange: SETRANGE key offset value
+// キーの値の offset の位置から value を上書きし、上書き後の長さを返します。
+// 値が offset より短い場合は、足りない部分をゼロバイトで埋めます。
+func setrange(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'setrange' command"}
+	}
+
+	key, value := args[0].bulk, args[2].bulk
+	offset, ok := parseInteger(args[1].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+	if offset < 0 {
+		return Value{typ: "error", str: "ERR offset is out of range"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupStringWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+
+	old := ""
+	if o != nil {
+		old = o.str()
+	}
+
+	// 書き込む値が空の場合は、何も変更せずに現在の長さを返します（キーも作成しません）。
+	if value == "" {
+		return Value{typ: "integer", num: len(old)}
+	}
+	if errValue := checkStringLength(offset, int64(len(value))); errValue != nil {
+		return *errValue
+	}
+
+	size := max(int(offset)+len(value), len(old))
+	buf := make([]byte, size)
+	copy(buf, old)
+	copy(buf[offset:], value)
+
+	db.setKey(key, newStringObject(string(buf)), true)
+	db.dirty++
+	return Value{typ: "integer", num: size}
+}
+
+// ------------------------------
+// MSET / MSETNX / MGET コマンド
+// ------------------------------
+
+// mset: MSET key value [key value ...]
+// 複数のキーと値をまとめて保存します。1つのロックの中で保存するため、他のクライアントから途中の状態は見えません。
+// AOFにも1つのコマンドとして記録されるので、再生したときも一部のキーだけが保存されることはありません。
+func mset(c *Client, args []Value) Value {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'mset' command"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	msetGeneric(args)
+	return Value{typ: "string", str: "OK"}
+}
+
+// msetnx: MSETNX key value [key value ...]
+// 指定したキーが1つも存在しない場合のみ、すべてのキーと値を保存します（すべて保存するか、何も保存しないかのどちらかです）。
+// 保存した場合は 1、保存しなかった場合は 0 を返します。
+func msetnx(c *Client, args []Value) Value {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'msetnx' command"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 保存する前に、すべてのキーが存在しないことを確認します（型は問いません）。
+	for i := 0; i < len(args); i += 2 {
+		if db.lookupKeyWrite(args[i].bulk) != nil {
+			return Value{typ: "integer", num: 0}
+		}
+	}
+
+	msetGeneric(args)
+	return Value{typ: "integer", num: 1}
+}
+
+// msetGeneric: キーと値の組をすべて保存します。既存の有効期限は取り消されます。
+// 書き込みロックを取得した状態で呼び出します。
+func msetGeneric(args []Value) {
+	for i := 0; i < len(args); i += 2 {
+		db.setKey(args[i].bulk, newStringObject(args[i+1].bulk), false)
+		db.dirty++
+	}
+}
+
+// mget: MGET key [key ...]
+// 複数のキーの値をまとめて取得します。存在しないキーや、文字列以外の型のキーは null になります。
+func mget(c *Client, args []Value) Value {
+	if len(args) == 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'mget' command"}
+	}
+
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.bulk
+	}
+	db.expireIfNeeded(keys...)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	values := make([]Value, len(keys))
+	for i, key := range keys {
+		o := db.lookupKeyRead(key)
+		if o == nil || o.typ != ObjString {
+			values[i] = Value{typ: "null"}
+			continue
+		}
+		values[i] = Value{typ: "bulk", bulk: o.str()}
+	}
+
+	return Value{typ: "array", array: values}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// SETRANGE の offset に int64 の最大値を指定しても、長さの計算があふれてパニックせず、エラーを返すことを確認します。
+func TestSetrangeOffsetOverflow(t *testing.T) {
+	db = NewDB()
+	defer func() { db = NewDB() }()
+
+	c := &Client{authenticated: true, proto: 2}
+	for _, offset := range []string{"9223372036854775807", "9223372036854775806", "536870912"} {
+		v := setrange(c, commandValue([]string{"k", offset, "a"}).array)
+		if v.typ != "error" || !strings.HasPrefix(v.str, "ERR string exceeds maximum allowed size") {
+			t.Errorf("SETRANGE k %s a = %+v, want a size error", offset, v)
+		}
+	}
+
+	if v := setrange(c, commandValue([]string{"k", "5", "a"}).array); v.typ != "integer" || v.num != 6 {
+		t.Errorf("SETRANGE k 5 a = %+v, want 6", v)
+	}
+}
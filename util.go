@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"math/rand/v2"
+)
+
+// ====================================================================
+// 汎用のユーティリティ関数
+// ====================================================================
+
+// stringMatch: Redisのglob形式のパターン（KEYS や CONFIG GET などで使われるもの）に文字列が一致するかを判定します。
+// Redisの stringmatchlen と同じ規則に従います。
+//   - "*" は任意の長さの任意の文字列に一致します。
+//   - "?" は任意の1文字に一致します。
+//   - "[abc]" は角括弧の中のいずれか1文字に一致します（"[^abc]" は否定、"[a-z]" は範囲）。
+//   - "\x" は文字 x そのものに一致します（特殊文字のエスケープ）。
+func stringMatch(pattern, str string, nocase bool) bool {
+	for len(pattern) > 0 && len(str) > 0 {
+		switch pattern[0] {
+		case '*':
+			// 連続する '*' は1つと同じ意味なので、まとめて読み飛ばします。
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			// パターンが '*' で終わっていれば、残りの文字列が何であっても一致します。
+			if len(pattern) == 1 {
+				return true
+			}
+			// '*' が何文字分に一致するかを1文字ずつずらしながら試します。
+			for len(str) > 0 {
+				if stringMatch(pattern[1:], str, nocase) {
+					return true
+				}
+				str = str[1:]
+			}
+			return false
+		case '?':
+			str = str[1:]
+		case '[':
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 {
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					// エスケープされた文字はそのまま比較します。
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				} else if pattern[0] == ']' {
+					break
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					// [a-z] のような範囲指定です。
+					start, end, c := pattern[0], pattern[2], str[0]
+					if start > end {
+						start, end = end, start
+					}
+					if nocase {
+						start, end, c = toLowerByte(start), toLowerByte(end), toLowerByte(c)
+					}
+					pattern = pattern[2:]
+					if c >= start && c <= end {
+						match = true
+					}
+				} else if equalByte(pattern[0], str[0], nocase) {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			str = str[1:]
+		case '\\':
+			// 次の文字をエスケープします（例: \* は '*' という文字そのもの）。
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if !equalByte(pattern[0], str[0], nocase) {
+				return false
+			}
+			str = str[1:]
+		}
+
+		// パターンを1文字進めます（'[' の場合は閉じ括弧 ']' を読み飛ばします）。
+		if len(pattern) > 0 {
+			pattern = pattern[1:]
+		}
+
+		// 文字列を最後まで読み終えた場合、残りのパターンが '*' だけなら一致とみなします。
+		if len(str) == 0 {
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			break
+		}
+	}
+
+	return len(pattern) == 0 && len(str) == 0
+}
+
+// equalByte: 2つのバイトが等しいかを判定します（nocase が true なら大文字小文字を区別しません）。
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return toLowerByte(a) == toLowerByte(b)
+	}
+	return a == b
+}
+
+// toLowerByte: ASCIIの大文字を小文字に変換します。
+func toLowerByte(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
+
+// ====================================================================
+// 引数の分割（引用符とエスケープの処理）
+// ====================================================================
+
+// splitArgs: 1行の文字列を空白で区切られた引数に分割します（Redisの sdssplitargs に相当します）。
+// ダブルクォートの中では \n や \xHH などのエスケープが使え、シングルクォートの中では \' のみが使えます。
+// 引用符が閉じられていない場合や、閉じ引用符の直後に空白以外の文字が続く場合はエラーを返します。
+//
+//	例: set "hello world" 'it\'s' → ["set", "hello world", "it's"]
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+	i, n := 0, len(line)
+
+	for {
+		// 引数の前の空白を読み飛ばします。
+		for i < n && isArgSpace(line[i]) {
+			i++
+		}
+		if i >= n {
+			return args, nil
+		}
+
+		var cur []byte
+		inDoubleQuotes := false // ダブルクォートの中にいるか
+		inSingleQuotes := false // シングルクォートの中にいるか
+		done := false
+
+		for !done {
+			switch {
+			case inDoubleQuotes:
+				if i >= n {
+					return nil, errUnbalancedQuotes
+				}
+				if line[i] == '\\' && i+3 < n && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					// \xHH: 16進数で表された1バイト
+					cur = append(cur, hexDigitToInt(line[i+2])<<4|hexDigitToInt(line[i+3]))
+					i += 3
+				} else if line[i] == '\\' && i+1 < n {
+					// \n, \r, \t, \b, \a とそれ以外（\\ や \" はその文字自身）
+					i++
+					c := line[i]
+					switch c {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					}
+					cur = append(cur, c)
+				} else if line[i] == '"' {
+					// 閉じ引用符の直後は空白か行末でなければなりません。
+					if i+1 < n && !isArgSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					cur = append(cur, line[i])
+				}
+			case inSingleQuotes:
+				if i >= n {
+					return nil, errUnbalancedQuotes
+				}
+				if line[i] == '\\' && i+1 < n && line[i+1] == '\'' {
+					i++
+					cur = append(cur, '\'')
+				} else if line[i] == '\'' {
+					if i+1 < n && !isArgSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					cur = append(cur, line[i])
+				}
+			default:
+				if i >= n {
+					done = true
+					break
+				}
+				switch line[i] {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inDoubleQuotes = true
+				case '\'':
+					inSingleQuotes = true
+				default:
+					cur = append(cur, line[i])
+				}
+			}
+			if i < n {
+				i++
+			}
+		}
+
+		args = append(args, string(cur))
+	}
+}
+
+// errUnbalancedQuotes: 引用符の対応が取れていない場合のエラーです。
+var errUnbalancedQuotes = errors.New("unbalanced quotes")
+
+// isArgSpace: 引数の区切りとなる空白文字かどうかを判定します。
+func isArgSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\v' || c == '\f'
+}
+
+// isHexDigit: 16進数の数字（0-9, a-f, A-F）かどうかを判定します。
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// hexDigitToInt: 16進数の1文字を数値に変換します。
+func hexDigitToInt(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// randomMapKeySpan: randomMapKey が、ランダムな開始位置から何個先までのキーを候補にするかです。
+const randomMapKeySpan = 64
+
+// randomMapKey: マップのキーを1つランダムに選びます（空のマップには使えません）。
+// Goのマップは range を始めるたびに開始位置がランダムに決まるので、すべてのキーを並べずに選べます。
+// ただし、最初のキーをそのまま返すと、内部で空きの後ろにあるキーほど選ばれやすく、分布が大きく偏ります。
+// そこで、開始位置から randomMapKeySpan 個までのキーのうち1つをランダムに選び、偏りを均します
+// （Redisの dictGetFairRandomKey と同じ考え方です）。完全に一様な分布ではありませんが、
+// 要素数に関係なく O(randomMapKeySpan) の時間で選べます。
+func randomMapKey[V any](m map[string]V) string {
+	skip := rand.IntN(min(len(m), randomMapKeySpan))
+	for key := range m {
+		if skip == 0 {
+			return key
+		}
+		skip--
+	}
+	panic("randomMapKey: empty map")
+}
+
+// sampleDistinct: size 個の要素から、重複しない count 個（0 <= count < size）をランダムに選びます
+// （Redisの SRANDMEMBER と HRANDFIELD の、正の count の場合の選び方です）。
+// random は要素を1つランダムに返す関数、members はすべての要素を返す関数です。
+func sampleDistinct(size, count int, random func() string, members func() []string) []string {
+	// count が size に近い場合は、すべての要素を並べて、先頭の count 個だけをシャッフルします。
+	// ランダムに選んで重複を除く方法では、まだ選んでいない要素が少なくなるほど、重複ばかり引いてしまうためです。
+	// すべてを並べる O(size) の手間は、count が size の 1/3 を超えるので O(count) と同じ程度です。
+	if count*3 > size {
+		all := members()
+		for i := range count {
+			j := i + rand.IntN(len(all)-i)
+			all[i], all[j] = all[j], all[i]
+		}
+		return all[:count]
+	}
+
+	// count が小さい場合は、重複しない要素が count 個集まるまでランダムに選びます。
+	seen := make(map[string]struct{}, count)
+	picked := make([]string, 0, count)
+	for len(picked) < count {
+		member := random()
+		if _, ok := seen[member]; ok {
+			continue
+		}
+		seen[member] = struct{}{}
+		picked = append(picked, member)
+	}
+	return picked
+}
@@ -0,0 +1,1284 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+)
+
+// ====================================================================
+// ソート済みセット型のコマンド
+// ====================================================================
+//
+// ソート済みセットは、メンバーごとにスコア（浮動小数点数）を持ち、スコアの順に並んだセットです。
+// 値は、Redisと同じく2種類のエンコーディングのどちらかで保存されます。
+//   - listpack: 要素数が zset-max-listpack-entries 以下で、メンバーがすべて zset-max-listpack-value バイト以下の場合。
+//     (スコア, メンバー) の順に並べた配列（zsetListpack）で、検索は二分探索で行います
+//   - skiplist: それ以外の場合。スキップリスト（順序と順位のため）と辞書（メンバーからスコアを O(1) で引くため）の
+//     2つで同じ要素を持ちます（zset）
+//
+// コマンドはエンコーディングを直接扱わず、zset で始まる関数を通して要素を操作します。
+// listpack の上限を超えたときは、zsetInsert の中でスキップリストに変換します。一度変換したら、listpack には戻しません。
+// 空のソート済みセットは存在できません。最後の要素を削除したときは、キーごと削除します。
+
+// zsetEntry構造体: ソート済みセットの1つの要素です。
+type zsetEntry struct {
+	member string
+	score  float64
+}
+
+// zsetListpack構造体: listpack エンコーディングのソート済みセットです。要素は (スコア, メンバー) の順に並びます。
+type zsetListpack struct {
+	entries []zsetEntry
+}
+
+// zset構造体: skiplist エンコーディングのソート済みセットです。
+type zset struct {
+	dict map[string]float64 // メンバー -> スコア
+	zsl  *zskiplist         // スコアの順に並んだ要素
+}
+
+// newZset: 空の skiplist エンコーディングのソート済みセットを作成します。
+func newZset() *zset {
+	return &zset{dict: map[string]float64{}, zsl: newZskiplist()}
+}
+
+// dup: すべての要素をコピーした新しいソート済みセットを返します。
+func (zs *zset) dup() *zset {
+	dup := newZset()
+	for x := zs.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		dup.zsl.Insert(x.score, x.member)
+		dup.dict[x.member] = x.score
+	}
+	return dup
+}
+
+// zsetListpack: listpack エンコーディングのソート済みセットの値を返します。
+func (o *Object) zsetListpack() *zsetListpack {
+	return o.value.(*zsetListpack)
+}
+
+// zset: skiplist エンコーディングのソート済みセットの値を返します。
+func (o *Object) zset() *zset {
+	return o.value.(*zset)
+}
+
+// zsetLength: 要素数を返します。
+func zsetLength(o *Object) int {
+	if o.encoding == EncodingListpack {
+		return len(o.zsetListpack().entries)
+	}
+	return o.zset().zsl.length
+}
+
+// zsetRandomEntry: 要素を1つランダムに選びます。空のソート済みセットには使えません。
+// listpack は位置を指定して取り出せるので一様に選び、skiplist は辞書から randomMapKey で選びます。
+func zsetRandomEntry(o *Object) zsetEntry {
+	if o.encoding == EncodingListpack {
+		entries := o.zsetListpack().entries
+		return entries[rand.IntN(len(entries))]
+	}
+	zs := o.zset()
+	member := randomMapKey(zs.dict)
+	return zsetEntry{member: member, score: zs.dict[member]}
+}
+
+// zsetScore: メンバーのスコアを返します。メンバーが存在しない場合は false を返します。
+func zsetScore(o *Object, member string) (float64, bool) {
+	if o.encoding == EncodingListpack {
+		// listpack はスコアの順に並んでいるため、メンバーからは先頭から順に探します（要素が少ないので十分速いです）。
+		for _, e := range o.zsetListpack().entries {
+			if e.member == member {
+				return e.score, true
+			}
+		}
+		return 0, false
+	}
+	score, ok := o.zset().dict[member]
+	return score, ok
+}
+
+// zsetInsert: 存在しないメンバーを追加します。listpack の上限を超える場合は、先にスキップリストに変換します。
+func zsetInsert(o *Object, score float64, member string) {
+	if o.encoding == EncodingListpack {
+		lp := o.zsetListpack()
+		maxEntries, maxValue := config.getZsetMaxListpack()
+		if len(lp.entries)+1 > maxEntries || len(member) > maxValue {
+			zsetConvert(o)
+		} else {
+			i := sort.Search(len(lp.entries), func(i int) bool {
+				return !zslLess(lp.entries[i].score, lp.entries[i].member, score, member)
+			})
+			lp.entries = append(lp.entries, zsetEntry{})
+			copy(lp.entries[i+1:], lp.entries[i:])
+			lp.entries[i] = zsetEntry{member: member, score: score}
+			return
+		}
+	}
+
+	zs := o.zset()
+	zs.dict[member] = score
+	zs.zsl.Insert(score, member)
+}
+
+// zsetDelete: メンバーを削除します。存在しない場合は false を返します。
+func zsetDelete(o *Object, member string) bool {
+	if o.encoding == EncodingListpack {
+		lp := o.zsetListpack()
+		for i, e := range lp.entries {
+			if e.member == member {
+				lp.entries = append(lp.entries[:i], lp.entries[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+
+	zs := o.zset()
+	score, ok := zs.dict[member]
+	if !ok {
+		return false
+	}
+	delete(zs.dict, member)
+	zs.zsl.Delete(score, member)
+	return true
+}
+
+// zsetConvert: listpack のソート済みセットをスキップリストに変換します。
+func zsetConvert(o *Object) {
+	zs := newZset()
+	for _, e := range o.zsetListpack().entries {
+		zs.dict[e.member] = e.score
+		zs.zsl.Insert(e.score, e.member)
+	}
+	o.encoding = EncodingSkiplist
+	o.value = zs
+}
+
+// zsetRank: メンバーの順位（0 始まり）とスコアを返します。reverse が true なら、スコアの大きい順の順位です。
+// メンバーが存在しない場合は false を返します。
+func zsetRank(o *Object, member string, reverse bool) (int, float64, bool) {
+	score, ok := zsetScore(o, member)
+	if !ok {
+		return 0, 0, false
+	}
+
+	var rank int
+	if o.encoding == EncodingListpack {
+		lp := o.zsetListpack()
+		rank = sort.Search(len(lp.entries), func(i int) bool {
+			return !zslLess(lp.entries[i].score, lp.entries[i].member, score, member)
+		})
+	} else {
+		rank = o.zset().zsl.Rank(score, member) - 1
+	}
+	if reverse {
+		rank = zsetLength(o) - 1 - rank
+	}
+	return rank, score, true
+}
+
+// zsetRange: 順位 start から end まで（0 始まり、両端を含む、スコアの小さい順の順位）の要素を返します。
+// reverse が true なら、end から start に向かって（スコアの大きい順に）返します。
+func zsetRange(o *Object, start, end int, reverse bool) []zsetEntry {
+	if start > end {
+		return nil
+	}
+	result := make([]zsetEntry, 0, end-start+1)
+
+	if o.encoding == EncodingListpack {
+		entries := o.zsetListpack().entries
+		if reverse {
+			for i := end; i >= start; i-- {
+				result = append(result, entries[i])
+			}
+		} else {
+			result = append(result, entries[start:end+1]...)
+		}
+		return result
+	}
+
+	// スキップリストでは、最初の要素を順位から O(log N) で見つけ、あとは level 0 を順番にたどります。
+	zsl := o.zset().zsl
+	if reverse {
+		for x := zsl.ByRank(end + 1); x != nil && len(result) < end-start+1; x = x.backward {
+			result = append(result, zsetEntry{member: x.member, score: x.score})
+		}
+	} else {
+		for x := zsl.ByRank(start + 1); x != nil && len(result) < end-start+1; x = x.level[0].forward {
+			result = append(result, zsetEntry{member: x.member, score: x.score})
+		}
+	}
+	return result
+}
+
+// zsetCountLess: スコアが score より小さい要素の数を返します。inclusive が true なら score と等しい要素も数えます。
+func zsetCountLess(o *Object, score float64, inclusive bool) int {
+	if o.encoding == EncodingListpack {
+		entries := o.zsetListpack().entries
+		return sort.Search(len(entries), func(i int) bool {
+			return !(entries[i].score < score || (inclusive && entries[i].score == score))
+		})
+	}
+	return o.zset().zsl.CountLess(score, inclusive)
+}
+
+// zsetCountLessLex: メンバーが member より辞書順で前の要素の数を返します。inclusive が true なら等しい要素も数えます。
+func zsetCountLessLex(o *Object, member string, inclusive bool) int {
+	if o.encoding == EncodingListpack {
+		entries := o.zsetListpack().entries
+		return sort.Search(len(entries), func(i int) bool {
+			return !(entries[i].member < member || (inclusive && entries[i].member == member))
+		})
+	}
+	return o.zset().zsl.CountLessLex(member, inclusive)
+}
+
+// zaddFlags構造体: ZADD のオプションです。
+type zaddFlags struct {
+	nx, xx, gt, lt, incr bool
+}
+
+// zsetAdd の結果です。
+const (
+	zaddNop       = iota // 何もしなかった（NX / XX / GT / LT の条件に合わなかった）
+	zaddAdded            // 新しいメンバーを追加した
+	zaddUpdated          // 既存のメンバーのスコアを更新した
+	zaddUnchanged        // 既存のメンバーのスコアが新しいスコアと同じだったので、何も変えなかった
+)
+
+// zsetAdd: メンバーを追加するか、既存のメンバーのスコアを更新します（ZADD と ZINCRBY の共通処理です）。
+// 戻り値は、更新後のスコアと結果（zaddNop など）です。
+// INCR で結果が NaN になる場合（+inf と -inf を足した場合）は、何もせずに false を返します。
+func zsetAdd(o *Object, score float64, member string, flags zaddFlags) (float64, int, bool) {
+	current, exists := zsetScore(o, member)
+	if !exists {
+		if flags.xx {
+			return 0, zaddNop, true
+		}
+		zsetInsert(o, score, member)
+		return score, zaddAdded, true
+	}
+
+	if flags.nx {
+		return current, zaddNop, true
+	}
+	if flags.incr {
+		score += current
+		if math.IsNaN(score) {
+			return 0, zaddNop, false
+		}
+	}
+	// GT は新しいスコアが大きい場合だけ、LT は小さい場合だけ更新します。
+	if (flags.gt && score <= current) || (flags.lt && score >= current) {
+		return current, zaddNop, true
+	}
+	if score == current {
+		return score, zaddUnchanged, true
+	}
+	zsetDelete(o, member)
+	zsetInsert(o, score, member)
+	return score, zaddUpdated, true
+}
+
+// zsetPop: スコアの最も小さい要素（highest が true なら最も大きい要素）を取り出します。
+func zsetPop(o *Object, highest bool) zsetEntry {
+	rank := 0
+	if highest {
+		rank = zsetLength(o) - 1
+	}
+	e := zsetRange(o, rank, rank, false)[0]
+	zsetDelete(o, e.member)
+	return e
+}
+
+// zsetEntries: 全要素をスコアの順に返します。
+func zsetEntries(o *Object) []zsetEntry {
+	return zsetRange(o, 0, zsetLength(o)-1, false)
+}
+
+// lookupZsetRead: 読み取りのためにソート済みセットのキーを検索します。
+// キーが存在しない場合は nil、ソート済みセット以外の型の場合は WRONGTYPE のエラー応答を返します。
+// 読み取りロックを取得した状態で呼び出します。
+func lookupZsetRead(key string) (*Object, *Value) {
+	o := db.lookupKeyRead(key)
+	if o != nil && o.typ != ObjZset {
+		return nil, &wrongTypeError
+	}
+	return o, nil
+}
+
+// lookupZsetWrite: 書き込みのためにソート済みセットのキーを検索します。
+// キーが存在しない場合は nil、ソート済みセット以外の型の場合は WRONGTYPE のエラー応答を返します。
+// 書き込みロックを取得した状態で呼び出します。
+func lookupZsetWrite(key string) (*Object, *Value) {
+	o := db.lookupKeyWrite(key)
+	if o != nil && o.typ != ObjZset {
+		return nil, &wrongTypeError
+	}
+	return o, nil
+}
+
+// deleteZsetIfEmpty: ソート済みセットが空になっていれば、キーを削除します。書き込みロックを取得した状態で呼び出します。
+func deleteZsetIfEmpty(key string, o *Object) {
+	if zsetLength(o) == 0 {
+		db.deleteKey(key)
+	}
+}
+
+// zsetReply: 要素の一覧を応答にします。withScores が true なら、メンバーとスコアを組にして返します。
+// RESP2 では [メンバー, スコア, メンバー, スコア, ...] の平らな配列、
+// RESP3 では [[メンバー, スコア], ...] のように、組ごとの2要素の配列を並べます（スコアは Double 型です）。
+func zsetReply(c *Client, entries []zsetEntry, withScores bool) Value {
+	items := make([]Value, 0, len(entries))
+	for _, e := range entries {
+		switch {
+		case !withScores:
+			items = append(items, Value{typ: "bulk", bulk: e.member})
+		case c.proto == 3:
+			items = append(items, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: e.member}, {typ: "double", dbl: e.score},
+			}})
+		default:
+			items = append(items, Value{typ: "bulk", bulk: e.member}, Value{typ: "double", dbl: e.score})
+		}
+	}
+	return Value{typ: "array", array: items}
+}
+
+// ------------------------------
+// スコアと範囲の解析
+// ------------------------------
+
+// zrangeSpec構造体: ZRANGE BYSCORE や ZCOUNT のスコアの範囲です。
+type zrangeSpec struct {
+	min, max     float64
+	minex, maxex bool // 範囲の端を含まない（"(" が付いている）かどうか
+}
+
+// parseRangeBound: スコアの範囲の端を解析します。"(1.5" のように "(" を付けると、その値を含みません。
+// 値は parseFloat で解析するので、"-inf" や "+inf" も指定できます。
+func parseRangeBound(s string) (float64, bool, bool) {
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	score, ok := parseFloat(s)
+	return score, exclusive, ok
+}
+
+// parseRangeSpec: スコアの範囲 min max を解析します。
+func parseRangeSpec(min, max string) (zrangeSpec, *Value) {
+	var spec zrangeSpec
+	var ok1, ok2 bool
+	spec.min, spec.minex, ok1 = parseRangeBound(min)
+	spec.max, spec.maxex, ok2 = parseRangeBound(max)
+	if !ok1 || !ok2 {
+		return spec, &Value{typ: "error", str: "ERR min or max is not a float"}
+	}
+	return spec, nil
+}
+
+// ranks: 範囲に含まれる要素の順位（0 始まり、両端を含む）を返します。範囲が空なら start > end になります。
+func (spec zrangeSpec) ranks(o *Object) (int, int) {
+	start := zsetCountLess(o, spec.min, spec.minex)
+	end := zsetCountLess(o, spec.max, !spec.maxex) - 1
+	return start, end
+}
+
+// zlexBound構造体: ZRANGE BYLEX や ZLEXCOUNT の範囲の端です。
+type zlexBound struct {
+	member    string
+	exclusive bool
+	inf       int // -1 なら "-"（負の無限大）、1 なら "+"（正の無限大）、0 なら member を使います
+}
+
+// zlexRangeSpec構造体: メンバーの辞書順の範囲です。
+type zlexRangeSpec struct {
+	min, max zlexBound
+}
+
+// parseLexBound: 辞書順の範囲の端を解析します。"[a" は a を含み、"(a" は a を含みません。"-" と "+" は両端の無限大です。
+func parseLexBound(s string) (zlexBound, bool) {
+	switch {
+	case s == "-":
+		return zlexBound{inf: -1}, true
+	case s == "+":
+		return zlexBound{inf: 1}, true
+	case strings.HasPrefix(s, "["):
+		return zlexBound{member: s[1:]}, true
+	case strings.HasPrefix(s, "("):
+		return zlexBound{member: s[1:], exclusive: true}, true
+	}
+	return zlexBound{}, false
+}
+
+// parseLexRangeSpec: 辞書順の範囲 min max を解析します。
+func parseLexRangeSpec(min, max string) (zlexRangeSpec, *Value) {
+	var spec zlexRangeSpec
+	var ok1, ok2 bool
+	spec.min, ok1 = parseLexBound(min)
+	spec.max, ok2 = parseLexBound(max)
+	if !ok1 || !ok2 {
+		return spec, &Value{typ: "error", str: "ERR min or max not valid string range item"}
+	}
+	return spec, nil
+}
+
+// countLessLex: 範囲の端より前にある要素の数を返します。upper が true なら、端と等しい要素も（含む場合は）数えます。
+func (b zlexBound) countLess(o *Object, upper bool) int {
+	switch b.inf {
+	case -1:
+		return 0
+	case 1:
+		return zsetLength(o)
+	}
+	if upper {
+		return zsetCountLessLex(o, b.member, !b.exclusive)
+	}
+	return zsetCountLessLex(o, b.member, b.exclusive)
+}
+
+// ranks: 範囲に含まれる要素の順位（0 始まり、両端を含む）を返します。範囲が空なら start > end になります。
+func (spec zlexRangeSpec) ranks(o *Object) (int, int) {
+	return spec.min.countLess(o, false), spec.max.countLess(o, true) - 1
+}
+
+// ------------------------------
+// ZADD / ZINCRBY コマンド
+// ------------------------------
+
+// zadd: ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+// メンバーを追加するか、既存のメンバーのスコアを更新し、新しく追加したメンバーの数を返します。
+//   - NX: 新しいメンバーの追加だけを行う / XX: 既存のメンバーの更新だけを行う
+//   - GT: 新しいスコアが大きい場合だけ更新する / LT: 小さい場合だけ更新する
+//   - CH: 追加したメンバーに加えて、スコアを更新したメンバーの数も返す
+//   - INCR: ZINCRBY と同じく、スコアを加算して更新後のスコアを返す
+func zadd(c *Client, args []Value) Value {
+	if len(args) < 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'zadd' command"}
+	}
+
+	var flags zaddFlags
+	ch := false
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "NX":
+			flags.nx = true
+		case "XX":
+			flags.xx = true
+		case "GT":
+			flags.gt = true
+		case "LT":
+			flags.lt = true
+		case "CH":
+			ch = true
+		case "INCR":
+			flags.incr = true
+		default:
+			break options
+		}
+	}
+
+	elements := args[i:]
+	if len(elements) == 0 || len(elements)%2 != 0 {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	if flags.nx && flags.xx {
+		return Value{typ: "error", str: "ERR XX and NX options at the same time are not compatible"}
+	}
+	if (flags.gt && flags.nx) || (flags.lt && flags.nx) || (flags.gt && flags.lt) {
+		return Value{typ: "error", str: "ERR GT, LT, and/or NX options at the same time are not compatible"}
+	}
+	if flags.incr && len(elements) > 2 {
+		return Value{typ: "error", str: "ERR INCR option supports a single increment-element pair"}
+	}
+
+	// 途中で失敗して一部だけ追加されることがないよう、先にすべてのスコアを解析します。
+	scores := make([]float64, len(elements)/2)
+	maxLen := 0
+	for j := range scores {
+		score, ok := parseFloat(elements[j*2].bulk)
+		if !ok {
+			return Value{typ: "error", str: "ERR value is not a valid float"}
+		}
+		scores[j] = score
+		maxLen = max(maxLen, len(elements[j*2+1].bulk))
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key := args[0].bulk
+	o, errValue := lookupZsetWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		// XX の場合は既存のメンバーしか更新しないので、キーを作成しません。
+		if flags.xx {
+			if flags.incr {
+				return Value{typ: "null"}
+			}
+			return Value{typ: "integer", num: 0}
+		}
+		o = newZsetObject(len(scores), maxLen)
+		db.setKey(key, o, false)
+	}
+
+	added, updated := 0, 0
+	var score float64
+	for j := range scores {
+		var result int
+		var ok bool
+		score, result, ok = zsetAdd(o, scores[j], elements[j*2+1].bulk, flags)
+		if !ok {
+			return Value{typ: "error", str: "ERR resulting score is not a number (NaN)"}
+		}
+		switch result {
+		case zaddAdded:
+			added++
+			db.dirty++
+		case zaddUpdated:
+			updated++
+			db.dirty++
+		}
+		// INCR で NX / XX / GT / LT の条件に合わなかった場合は null を返します。
+		if flags.incr && result == zaddNop {
+			return Value{typ: "null"}
+		}
+	}
+
+	// このキーを BZPOPMIN などで待っているクライアントがいれば、要素を渡します（blocking.go を参照）。
+	db.signalKeyAsReady(key)
+
+	if flags.incr {
+		return Value{typ: "double", dbl: score}
+	}
+	if ch {
+		return Value{typ: "integer", num: added + updated}
+	}
+	return Value{typ: "integer", num: added}
+}
+
+// zincrby: ZINCRBY key increment member
+// メンバーのスコアに increment を加算し、更新後のスコアを返します。メンバーが存在しない場合は 0 から加算します。
+func zincrby(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'zincrby' command"}
+	}
+	return zadd(c, []Value{args[0], {typ: "bulk", bulk: "INCR"}, args[1], args[2]})
+}
+
+// ------------------------------
+// ZREM / ZCARD / ZSCORE コマンド
+// ------------------------------
+
+// zrem: ZREM key member [member ...]
+// メンバーを削除し、実際に削除したメンバーの数を返します。
+func zrem(c *Client, args []Value) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'zrem' command"}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key := args[0].bulk
+	o, errValue := lookupZsetWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+
+	removed := 0
+	for _, member := range args[1:] {
+		if zsetDelete(o, member.bulk) {
+			removed++
+		}
+	}
+	deleteZsetIfEmpty(key, o)
+	db.dirty += int64(removed)
+	return Value{typ: "integer", num: removed}
+}
+
+// zcard: ZCARD key
+// 要素数を返します。キーが存在しない場合は 0 です。
+func zcard(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'zcard' command"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupZsetRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	return Value{typ: "integer", num: zsetLength(o)}
+}
+
+// zscore: ZSCORE key member
+// メンバーのスコアを返します。メンバーが存在しない場合は null を返します。
+func zscore(c *Client, args []Value) Value {
+	if len(args) != 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'zscore' command"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupZsetRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "null"}
+	}
+	score, ok := zsetScore(o, args[1].bulk)
+	if !ok {
+		return Value{typ: "null"}
+	}
+	return Value{typ: "double", dbl: score}
+}
+
+// ------------------------------
+// ZRANK / ZREVRANK コマンド
+// ------------------------------
+
+// zrank: ZRANK key member [WITHSCORE]
+// スコアの小さい順で、メンバーが何番目（0 始まり）かを返します。WITHSCORE を指定すると [順位, スコア] を返します。
+func zrank(c *Client, args []Value) Value {
+	return zrankGeneric(args, "zrank", false)
+}
+
+// zrevrank: ZREVRANK key member [WITHSCORE]
+// スコアの大きい順で、メンバーが何番目（0 始まり）かを返します。
+func zrevrank(c *Client, args []Value) Value {
+	return zrankGeneric(args, "zrevrank", true)
+}
+
+// zrankGeneric: ZRANK と ZREVRANK の共通処理です。
+func zrankGeneric(args []Value, name string, reverse bool) Value {
+	if len(args) < 2 || len(args) > 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+	withScore := len(args) == 3
+	if withScore && !strings.EqualFold(args[2].bulk, "WITHSCORE") {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	notFound := Value{typ: "null"}
+	if withScore {
+		notFound = Value{typ: "nullarray"}
+	}
+
+	o, errValue := lookupZsetRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return notFound
+	}
+	rank, score, ok := zsetRank(o, args[1].bulk, reverse)
+	if !ok {
+		return notFound
+	}
+	if withScore {
+		return Value{typ: "array", array: []Value{{typ: "integer", num: rank}, {typ: "double", dbl: score}}}
+	}
+	return Value{typ: "integer", num: rank}
+}
+
+// ------------------------------
+// ZRANGE コマンド
+// ------------------------------
+
+// zrange: ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+// 範囲に含まれる要素を返します。範囲の指定方法はオプションによって変わります。
+//   - 指定なし: 順位（0 始まり、負の値は末尾からの位置）の範囲
+//   - BYSCORE: スコアの範囲（"(" で端を含まない、"-inf" / "+inf" で無限大）
+//   - BYLEX: メンバーの辞書順の範囲（"[a" / "(a" / "-" / "+"）。すべてのスコアが同じ場合に使います
+//
+// REV を指定すると大きい順に返します。このとき BYSCORE と BYLEX では、start に大きい方の端を指定します。
+// LIMIT は BYSCORE か BYLEX と一緒にだけ指定でき、先頭の offset 個を飛ばして最大 count 個を返します（count が負なら全部）。
+func zrange(c *Client, args []Value) Value {
+	if len(args) < 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'zrange' command"}
+	}
+
+	byScore, byLex, reverse, withScores, withLimit := false, false, false, false, false
+	var offset, count int64 = 0, -1
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "BYSCORE":
+			byScore = true
+		case "BYLEX":
+			byLex = true
+		case "REV":
+			reverse = true
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			var ok1, ok2 bool
+			offset, ok1 = parseInteger(args[i+1].bulk)
+			count, ok2 = parseInteger(args[i+2].bulk)
+			if !ok1 || !ok2 {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			withLimit = true
+			i += 2
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+	if byScore && byLex {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+	if withLimit && !byScore && !byLex {
+		return Value{typ: "error", str: "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"}
+	}
+	if withScores && byLex {
+		return Value{typ: "error", str: "ERR syntax error, WITHSCORES not supported in combination with BYLEX"}
+	}
+
+	// REV の場合、BYSCORE と BYLEX の範囲は「大きい方の端、小さい方の端」の順に指定されます。
+	minArg, maxArg := args[1].bulk, args[2].bulk
+	if reverse && (byScore || byLex) {
+		minArg, maxArg = maxArg, minArg
+	}
+
+	// 範囲を、スコアの小さい順の順位 start から end に変換する関数を用意します。
+	var ranks func(o *Object) (int, int)
+	switch {
+	case byScore:
+		spec, errValue := parseRangeSpec(minArg, maxArg)
+		if errValue != nil {
+			return *errValue
+		}
+		ranks = spec.ranks
+	case byLex:
+		spec, errValue := parseLexRangeSpec(minArg, maxArg)
+		if errValue != nil {
+			return *errValue
+		}
+		ranks = spec.ranks
+	default:
+		start, ok1 := parseInteger(minArg)
+		end, ok2 := parseInteger(maxArg)
+		if !ok1 || !ok2 {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		ranks = func(o *Object) (int, int) {
+			length := zsetLength(o)
+			from, to, ok := listRange(start, end, length)
+			if !ok {
+				return 0, -1
+			}
+			// REV の順位は大きい順で数えたものなので、小さい順の順位に直します。
+			if reverse {
+				from, to = length-1-to, length-1-from
+			}
+			return from, to
+		}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupZsetRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "array", array: []Value{}}
+	}
+
+	start, end := ranks(o)
+
+	// LIMIT を適用します。REV の場合は、大きい方から offset 個を飛ばします。
+	n := int64(end - start + 1)
+	if offset < 0 || offset >= n {
+		return Value{typ: "array", array: []Value{}}
+	}
+	n -= offset
+	if count >= 0 && count < n {
+		n = count
+	}
+	if reverse {
+		end -= int(offset)
+		start = end - int(n) + 1
+	} else {
+		start += int(offset)
+		end = start + int(n) - 1
+	}
+
+	return zsetReply(c, zsetRange(o, start, end, reverse), withScores)
+}
+
+// ------------------------------
+// ZCOUNT / ZLEXCOUNT コマンド
+// ------------------------------
+
+// zcount: ZCOUNT key min max
+// スコアが min から max の範囲にある要素の数を返します。
+func zcount(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'zcount' command"}
+	}
+	spec, errValue := parseRangeSpec(args[1].bulk, args[2].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	return zcountGeneric(args[0].bulk, spec.ranks)
+}
+
+// zlexcount: ZLEXCOUNT key min max
+// メンバーが辞書順で min から max の範囲にある要素の数を返します。
+func zlexcount(c *Client, args []Value) Value {
+	if len(args) != 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'zlexcount' command"}
+	}
+	spec, errValue := parseLexRangeSpec(args[1].bulk, args[2].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	return zcountGeneric(args[0].bulk, spec.ranks)
+}
+
+// zcountGeneric: ZCOUNT と ZLEXCOUNT の共通処理です。範囲の両端の順位の差から、要素を数えずに O(log N) で求めます。
+func zcountGeneric(key string, ranks func(o *Object) (int, int)) Value {
+	db.expireIfNeeded(key)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupZsetRead(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "integer", num: 0}
+	}
+	start, end := ranks(o)
+	return Value{typ: "integer", num: max(end-start+1, 0)}
+}
+
+// ------------------------------
+// ZPOPMIN / ZPOPMAX / BZPOPMIN / BZPOPMAX コマンド
+// ------------------------------
+
+// zpopmin: ZPOPMIN key [count]
+// スコアの最も小さい要素を最大 count 個（省略時は1個）取り出し、メンバーとスコアを返します。
+func zpopmin(c *Client, args []Value) Value {
+	return zpopGeneric(c, args, "zpopmin", false)
+}
+
+// zpopmax: ZPOPMAX key [count]
+// スコアの最も大きい要素を最大 count 個（省略時は1個）取り出し、メンバーとスコアを返します。
+func zpopmax(c *Client, args []Value) Value {
+	return zpopGeneric(c, args, "zpopmax", true)
+}
+
+// zpopGeneric: ZPOPMIN と ZPOPMAX の共通処理です。
+// RESP3 で count を指定した場合は [メンバー, スコア] の組の配列、それ以外は平らな配列で返します（Redisと同じ形式です）。
+func zpopGeneric(c *Client, args []Value, name string, highest bool) Value {
+	if len(args) < 1 || len(args) > 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	key := args[0].bulk
+	withCount := len(args) == 2
+	count := int64(1)
+	if withCount {
+		var ok bool
+		if count, ok = parseInteger(args[1].bulk); !ok || count < 0 {
+			return Value{typ: "error", str: "ERR value is out of range, must be positive"}
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	o, errValue := lookupZsetWrite(key)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		return Value{typ: "array", array: []Value{}}
+	}
+
+	popped := make([]zsetEntry, 0, min(count, int64(zsetLength(o))))
+	for range cap(popped) {
+		popped = append(popped, zsetPop(o, highest))
+		db.dirty++
+	}
+	deleteZsetIfEmpty(key, o)
+
+	if !withCount && c.proto == 3 {
+		// RESP3 でも count なしの場合は、[メンバー, スコア] の平らな配列で返します。
+		return Value{typ: "array", array: []Value{{typ: "bulk", bulk: popped[0].member}, {typ: "double", dbl: popped[0].score}}}
+	}
+	return zsetReply(c, popped, true)
+}
+
+// bzpopmin: BZPOPMIN key [key ...] timeout
+// ZPOPMIN と同じですが、すべてのキーが空の場合は要素が追加されるまで最大 timeout 秒待ちます。
+// [キー, メンバー, スコア] を返します。
+func bzpopmin(c *Client, args []Value) Value {
+	return bzpopGeneric(c, args, "bzpopmin", false)
+}
+
+// bzpopmax: BZPOPMAX key [key ...] timeout
+// ZPOPMAX と同じですが、すべてのキーが空の場合は要素が追加されるまで最大 timeout 秒待ちます。
+func bzpopmax(c *Client, args []Value) Value {
+	return bzpopGeneric(c, args, "bzpopmax", true)
+}
+
+// bzpopGeneric: BZPOPMIN と BZPOPMAX の共通処理です（blocking.go の blockingPopGeneric と同じ流れです）。
+func bzpopGeneric(c *Client, args []Value, name string, highest bool) Value {
+	if len(args) < 2 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	timeout, errValue := parseBlockingTimeout(args[len(args)-1].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[:len(args)-1] {
+		keys[i] = arg.bulk
+	}
+	preventPropagation(c)
+
+	// pop: キーのソート済みセットから要素を1つ取り出し、[キー, メンバー, スコア] の応答を作ります。
+	// AOFには BZPOPMIN ではなく、実際に行った ZPOPMIN / ZPOPMAX を記録します。
+	popCommand := "ZPOPMIN"
+	if highest {
+		popCommand = "ZPOPMAX"
+	}
+	pop := func(key string, o *Object) Value {
+		e := zsetPop(o, highest)
+		deleteZsetIfEmpty(key, o)
+		propagate(popCommand, key)
+		return Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: key}, {typ: "bulk", bulk: e.member}, {typ: "double", dbl: e.score},
+		}}
+	}
+
+	db.mu.Lock()
+
+	for _, key := range keys {
+		o, errValue := lookupZsetWrite(key)
+		if errValue != nil {
+			db.mu.Unlock()
+			return *errValue
+		}
+		if o != nil {
+			defer db.mu.Unlock()
+			return pop(key, o)
+		}
+	}
+
+	// AOFの再生中の疑似クライアントはブロックできないので、タイムアウトしたものとして扱います。
+	if c.conn == nil {
+		db.mu.Unlock()
+		return Value{typ: "nullarray"}
+	}
+
+	bc := &blockedClient{keys: keys}
+	bc.tryServe = func(key string) (Value, bool) {
+		o, errValue := lookupZsetWrite(key)
+		if errValue != nil || o == nil {
+			return Value{}, false
+		}
+		return pop(key, o), true
+	}
+	return blockClient(c, bc, timeout, Value{typ: "nullarray"})
+}
+
+// ------------------------------
+// ZUNIONSTORE / ZINTERSTORE コマンド
+// ------------------------------
+
+// ZUNIONSTORE などで、同じメンバーのスコアをまとめる方法です。
+const (
+	zaggregateSum = iota
+	zaggregateMin
+	zaggregateMax
+)
+
+// zaggregate: 2つのスコアを aggregate の方法でまとめます。
+// +inf と -inf を足すと NaN になりますが、Redisと同じく 0 とします。
+func zaggregate(a, b float64, aggregate int) float64 {
+	switch aggregate {
+	case zaggregateMin:
+		return min(a, b)
+	case zaggregateMax:
+		return max(a, b)
+	}
+	sum := a + b
+	if math.IsNaN(sum) {
+		return 0
+	}
+	return sum
+}
+
+// zunionstore: ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+// いずれかの入力に含まれるメンバーを destination に保存し、その要素数を返します。
+func zunionstore(c *Client, args []Value) Value {
+	return zsetStoreGeneric(args, "zunionstore", setOpUnion)
+}
+
+// zinterstore: ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+// すべての入力に含まれるメンバーを destination に保存し、その要素数を返します。
+func zinterstore(c *Client, args []Value) Value {
+	return zsetStoreGeneric(args, "zinterstore", setOpInter)
+}
+
+// zsetStoreGeneric: ZUNIONSTORE と ZINTERSTORE の共通処理です。
+// 入力にはソート済みセットだけでなく、通常のセットも指定できます（スコアはすべて 1 とみなします）。
+// 各入力のスコアには WEIGHTS の重み（省略時は 1）を掛け、同じメンバーのスコアは AGGREGATE の方法（省略時は SUM）でまとめます。
+func zsetStoreGeneric(args []Value, name string, op int) Value {
+	if len(args) < 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for '" + name + "' command"}
+	}
+
+	destination := args[0].bulk
+	numkeys, ok := parseInteger(args[1].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+	if numkeys < 1 {
+		return Value{typ: "error", str: "ERR at least 1 input key is needed for '" + name + "' command"}
+	}
+	if numkeys > int64(len(args)-2) {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	keys := make([]string, numkeys)
+	weights := make([]float64, numkeys)
+	for i := range keys {
+		keys[i] = args[2+i].bulk
+		weights[i] = 1
+	}
+
+	aggregate := zaggregateSum
+	for i := 2 + int(numkeys); i < len(args); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "WEIGHTS":
+			if i+int(numkeys) >= len(args) {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			for j := range weights {
+				w, ok := parseFloat(args[i+1+j].bulk)
+				if !ok {
+					return Value{typ: "error", str: "ERR weight value is not a float"}
+				}
+				weights[j] = w
+			}
+			i += int(numkeys)
+		case "AGGREGATE":
+			if i+1 >= len(args) {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			switch strings.ToUpper(args[i+1].bulk) {
+			case "SUM":
+				aggregate = zaggregateSum
+			case "MIN":
+				aggregate = zaggregateMin
+			case "MAX":
+				aggregate = zaggregateMax
+			default:
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			i++
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 各入力を、重みを掛けた (メンバー, スコア) の一覧にします。存在しないキーは空の入力です。
+	inputs := make([][]zsetEntry, len(keys))
+	for i, key := range keys {
+		o := db.lookupKeyWrite(key)
+		if o == nil {
+			continue
+		}
+		var entries []zsetEntry
+		switch o.typ {
+		case ObjZset:
+			entries = zsetEntries(o)
+		case ObjSet:
+			for _, member := range setTypeMembers(o) {
+				entries = append(entries, zsetEntry{member: member, score: 1})
+			}
+		default:
+			return wrongTypeError
+		}
+		for j := range entries {
+			entries[j].score *= weights[i]
+			// 0 と無限大を掛けると NaN になりますが、Redisと同じく 0 とします。
+			if math.IsNaN(entries[j].score) {
+				entries[j].score = 0
+			}
+		}
+		inputs[i] = entries
+	}
+
+	// メンバーごとにスコアをまとめます。counts は、そのメンバーを含んでいた入力の数です（積集合の判定に使います）。
+	scores := map[string]float64{}
+	counts := map[string]int{}
+	var order []string
+	for _, entries := range inputs {
+		for _, e := range entries {
+			if current, ok := scores[e.member]; ok {
+				scores[e.member] = zaggregate(current, e.score, aggregate)
+			} else {
+				scores[e.member] = e.score
+				order = append(order, e.member)
+			}
+			counts[e.member]++
+		}
+	}
+
+	result := make([]zsetEntry, 0, len(order))
+	maxLen := 0
+	for _, member := range order {
+		if op == setOpInter && counts[member] != len(inputs) {
+			continue
+		}
+		result = append(result, zsetEntry{member: member, score: scores[member]})
+		maxLen = max(maxLen, len(member))
+	}
+
+	if len(result) == 0 {
+		if db.deleteKey(destination) {
+			db.dirty++
+		}
+		return Value{typ: "integer", num: 0}
+	}
+
+	o := newZsetObject(len(result), maxLen)
+	for _, e := range result {
+		zsetInsert(o, e.score, e.member)
+	}
+	db.setKey(destination, o, false)
+	db.dirty++
+	db.signalKeyAsReady(destination)
+	return Value{typ: "integer", num: len(result)}
+}
+
+// ------------------------------
+// ZRANDMEMBER コマンド
+// ------------------------------
+
+// zrandmember: ZRANDMEMBER key [count [WITHSCORES]]
+// ランダムに選んだメンバーを返します（削除はしません）。
+// count が正の場合は重複なしで最大 count 個、負の場合は重複を許して |count| 個を返します。
+func zrandmember(c *Client, args []Value) Value {
+	if len(args) < 1 || len(args) > 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'zrandmember' command"}
+	}
+
+	withCount := len(args) >= 2
+	var count int64
+	if withCount {
+		var ok bool
+		if count, ok = parseInteger(args[1].bulk); !ok {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		// 負の count は符号を反転して使うため、反転できない math.MinInt64 などは受け付けません（Redisと同じ範囲です）。
+		if count < -math.MaxInt64/2 {
+			return Value{typ: "error", str: "ERR value is out of range"}
+		}
+	}
+	withScores := false
+	if len(args) == 3 {
+		if !strings.EqualFold(args[2].bulk, "WITHSCORES") {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		withScores = true
+		// スコアと組にすると応答の要素数が2倍になるため、オーバーフローしないように制限します。
+		if count > math.MaxInt64/2 {
+			return Value{typ: "error", str: "ERR value is out of range"}
+		}
+	}
+
+	db.expireIfNeeded(args[0].bulk)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	o, errValue := lookupZsetRead(args[0].bulk)
+	if errValue != nil {
+		return *errValue
+	}
+	if o == nil {
+		if withCount {
+			return Value{typ: "array", array: []Value{}}
+		}
+		return Value{typ: "null"}
+	}
+
+	// count なしの場合は、メンバーを1つだけ Bulk String で返します。
+	if !withCount {
+		return Value{typ: "bulk", bulk: zsetRandomEntry(o).member}
+	}
+
+	// すべての要素を並べるのは、count が要素数に近い場合だけにします（HRANDFIELD と同じです）。
+	length := zsetLength(o)
+	var picked []zsetEntry
+	switch {
+	case count < 0:
+		// 重複あり: -count 回、ランダムに選びます。
+		for range -count {
+			picked = append(picked, zsetRandomEntry(o))
+		}
+	case count >= int64(length):
+		// 重複なしで要素数以上: すべての要素を返します。
+		picked = zsetEntries(o)
+	default:
+		members := sampleDistinct(length, int(count),
+			func() string { return zsetRandomEntry(o).member },
+			func() []string {
+				members := make([]string, 0, length)
+				for _, e := range zsetEntries(o) {
+					members = append(members, e.member)
+				}
+				return members
+			})
+		picked = make([]zsetEntry, 0, len(members))
+		for _, member := range members {
+			score, _ := zsetScore(o, member)
+			picked = append(picked, zsetEntry{member: member, score: score})
+		}
+	}
+	return zsetReply(c, picked, withScores)
+}